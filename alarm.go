@@ -0,0 +1,599 @@
+package mpris
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd"
+	"github.com/pkg/errors"
+)
+
+// This file implements alarms, which start playback at a given time, once or every week.
+// Alarms are read from a JSON file, if any, which also keeps the alarms added over D-Bus.
+
+// How long an alarm is snoozed for, unless it says otherwise.
+const defaultSnooze = 9 * time.Minute
+
+// Alarms missed by more than this, e.g. while the computer was off, are skipped.
+const alarmGrace = 30 * time.Minute
+
+// The longest wait between two checks of the alarms. Timers follow a clock that stops while the computer is suspended:
+// alarms are compared against the wall clock, which is re-read at least this often.
+const alarmCheckInterval = 15 * time.Second
+
+// AlarmSchedule is when an alarm fires: once, or every week.
+type AlarmSchedule struct {
+	// Once is when a one-off alarm fires. It is zero for weekly alarms.
+	Once time.Time
+	// Days are the days of the week on which a weekly alarm fires, indexed by time.Weekday,
+	// and At is the time of the day, as a duration since midnight.
+	Days [7]bool
+	At   time.Duration
+}
+
+var weekdayNames = [7]string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseAlarmSchedule parses a schedule written as:
+//   - "07:30": once, at the next 07:30 after now;
+//   - "2026-11-02 07:30": once, at that date and time;
+//   - "mon,wed 07:30", "mon-fri 07:30" or "daily 07:30": every week, on these days.
+func ParseAlarmSchedule(s string, now time.Time) (AlarmSchedule, error) {
+	var a AlarmSchedule
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return a, errors.Errorf("alarm schedule %q: expected a time of the day, after a date or days of the week", s)
+	}
+	at, err := parseTimeOfDay(fields[len(fields)-1])
+	if err != nil {
+		return a, errors.Wrapf(err, "alarm schedule %q", s)
+	}
+	if len(fields) == 1 {
+		a.Once = atTimeOfDay(now, at)
+		if !a.Once.After(now) {
+			a.Once = atTimeOfDay(now.AddDate(0, 0, 1), at)
+		}
+		return a, nil
+	}
+	if day, err := time.ParseInLocation("2006-01-02", fields[0], now.Location()); err == nil {
+		a.Once = atTimeOfDay(day, at)
+		return a, nil
+	}
+	if a.Days, err = parseWeekdays(fields[0]); err != nil {
+		return a, errors.Wrapf(err, "alarm schedule %q", s)
+	}
+	a.At = at
+	return a, nil
+}
+
+// parseWeekdays parses days of the week written as "daily", or a comma-separated list of days ("mon") and ranges ("mon-fri").
+func parseWeekdays(s string) (days [7]bool, err error) {
+	if strings.EqualFold(s, "daily") {
+		return [7]bool{true, true, true, true, true, true, true}, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := parseWeekday(from)
+		if err != nil {
+			return days, err
+		}
+		last := first
+		if isRange {
+			if last, err = parseWeekday(to); err != nil {
+				return days, err
+			}
+		}
+		// Ranges may wrap around the end of the week, e.g. "fri-mon".
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for d := range weekdayNames {
+		// "mon", "mond" or "monday"
+		if len(s) >= 3 && strings.HasPrefix(strings.ToLower(time.Weekday(d).String()), s) {
+			return time.Weekday(d), nil
+		}
+	}
+	return 0, errors.Errorf("%q is not a day of the week, like mon", s)
+}
+
+// atTimeOfDay returns the given time of the day on the day of t.
+func atTimeOfDay(t time.Time, at time.Duration) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, int(at/time.Hour), int(at%time.Hour/time.Minute), 0, 0, t.Location())
+}
+
+// String returns the schedule in the form parsed by ParseAlarmSchedule. One-off schedules always have a date.
+func (a AlarmSchedule) String() string {
+	if !a.Once.IsZero() {
+		return a.Once.Format("2006-01-02 15:04")
+	}
+	var days []string
+	for d, on := range a.Days {
+		if on {
+			days = append(days, weekdayNames[d])
+		}
+	}
+	if len(days) == 7 {
+		days = []string{"daily"}
+	}
+	return strings.Join(days, ",") + " " + formatTimeOfDay(a.At)
+}
+
+// next returns when the alarm fires after the given time. One-off alarms always fire at their time.
+func (a AlarmSchedule) next(after time.Time) time.Time {
+	if !a.Once.IsZero() {
+		return a.Once
+	}
+	for i := 0; i <= 7; i++ {
+		t := atTimeOfDay(after.AddDate(0, 0, i), a.At)
+		if t.After(after) && a.Days[t.Weekday()] {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Alarm starts playback on a schedule.
+type Alarm struct {
+	Name     string
+	Schedule AlarmSchedule
+	// Playlist is a stored playlist that replaces the queue, or URI is a song or stream added to it.
+	// Without either, the queue plays from where it is.
+	Playlist string
+	URI      string
+	// Volume is the volume of the alarm, from 0 to 1. 0 keeps the current volume.
+	// With a Ramp, the volume rises from StartVolume to Volume over its duration.
+	Volume      float64
+	StartVolume float64
+	Ramp        time.Duration
+	// Snooze is how long the Snooze method silences the alarm for. 0 means 9 minutes.
+	Snooze time.Duration
+}
+
+// validate checks the alarm's settings.
+func (a Alarm) validate() error {
+	switch {
+	case a.Name == "":
+		return errors.New("alarms need a name")
+	case a.Playlist != "" && a.URI != "":
+		return errors.Errorf("alarm %q: a playlist and a URI cannot both be played", a.Name)
+	case a.Volume < 0 || a.Volume > 1 || a.StartVolume < 0 || a.StartVolume > 1:
+		return errors.Errorf("alarm %q: volumes should be between 0 and 1", a.Name)
+	case a.Ramp < 0 || a.Snooze < 0:
+		return errors.Errorf("alarm %q: durations should not be negative", a.Name)
+	case a.Schedule.Once.IsZero() && a.Schedule.Days == [7]bool{}:
+		return errors.Errorf("alarm %q: no day to fire on", a.Name)
+	}
+	return nil
+}
+
+// alarmJSON is an alarm as written in the alarm file.
+type alarmJSON struct {
+	Name        string  `json:"name"`
+	When        string  `json:"when"`
+	Playlist    string  `json:"playlist,omitempty"`
+	URI         string  `json:"uri,omitempty"`
+	Volume      float64 `json:"volume,omitempty"`
+	StartVolume float64 `json:"start_volume,omitempty"`
+	Ramp        string  `json:"ramp,omitempty"`
+	Snooze      string  `json:"snooze,omitempty"`
+}
+
+// MarshalJSON writes the alarm as in the alarm file, with its schedule written as parsed by ParseAlarmSchedule,
+// and durations as parsed by time.ParseDuration.
+func (a Alarm) MarshalJSON() ([]byte, error) {
+	j := alarmJSON{
+		Name:        a.Name,
+		When:        a.Schedule.String(),
+		Playlist:    a.Playlist,
+		URI:         a.URI,
+		Volume:      a.Volume,
+		StartVolume: a.StartVolume,
+	}
+	if a.Ramp != 0 {
+		j.Ramp = a.Ramp.String()
+	}
+	if a.Snooze != 0 {
+		j.Snooze = a.Snooze.String()
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON reads an alarm written as by MarshalJSON.
+func (a *Alarm) UnmarshalJSON(data []byte) error {
+	var j alarmJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return errors.WithStack(err)
+	}
+	schedule, err := ParseAlarmSchedule(j.When, time.Now())
+	if err != nil {
+		return errors.Wrapf(err, "alarm %q", j.Name)
+	}
+	*a = Alarm{
+		Name:        j.Name,
+		Schedule:    schedule,
+		Playlist:    j.Playlist,
+		URI:         j.URI,
+		Volume:      j.Volume,
+		StartVolume: j.StartVolume,
+	}
+	for _, d := range []struct {
+		s      string
+		target *time.Duration
+	}{{j.Ramp, &a.Ramp}, {j.Snooze, &a.Snooze}} {
+		if d.s == "" {
+			continue
+		}
+		if *d.target, err = time.ParseDuration(d.s); err != nil {
+			return errors.Wrapf(err, "alarm %q", j.Name)
+		}
+	}
+	return nil
+}
+
+// alarmFromDBus creates an alarm from the arguments of the AddAlarm method.
+func alarmFromDBus(name, when string, options map[string]dbus.Variant, now time.Time) (Alarm, error) {
+	a := Alarm{Name: name}
+	var err error
+	if a.Schedule, err = ParseAlarmSchedule(when, now); err != nil {
+		return a, err
+	}
+	for key, v := range options {
+		var ok bool
+		switch key {
+		case "playlist":
+			a.Playlist, ok = v.Value().(string)
+		case "uri":
+			a.URI, ok = v.Value().(string)
+		case "volume":
+			a.Volume, ok = v.Value().(float64)
+		case "start_volume":
+			a.StartVolume, ok = v.Value().(float64)
+		case "ramp", "snooze":
+			var us int64
+			if us, ok = v.Value().(int64); ok {
+				if key == "ramp" {
+					a.Ramp = TimeInUs(us).Duration()
+				} else {
+					a.Snooze = TimeInUs(us).Duration()
+				}
+			}
+		default:
+			return a, errors.Errorf("unknown alarm option %q", key)
+		}
+		if !ok {
+			return a, errors.Errorf("alarm option %q has the wrong type %s", key, v.Signature())
+		}
+	}
+	return a, nil
+}
+
+// dbusOptions returns the options of the alarm, as given to the AddAlarm method.
+func (a Alarm) dbusOptions() map[string]dbus.Variant {
+	options := map[string]dbus.Variant{
+		"volume":       dbus.MakeVariant(a.Volume),
+		"start_volume": dbus.MakeVariant(a.StartVolume),
+		"ramp":         dbus.MakeVariant(int64(UsFromDuration(a.Ramp))),
+		"snooze":       dbus.MakeVariant(int64(UsFromDuration(a.Snooze))),
+	}
+	if a.Playlist != "" {
+		options["playlist"] = dbus.MakeVariant(a.Playlist)
+	}
+	if a.URI != "" {
+		options["uri"] = dbus.MakeVariant(a.URI)
+	}
+	return options
+}
+
+// A scheduledAlarm is an alarm, with the next time it fires.
+type scheduledAlarm struct {
+	Alarm
+	next time.Time
+}
+
+// An alarmRing is an alarm going off. Snoozed alarms resume playback, rather than loading their playlist or URI again.
+type alarmRing struct {
+	Alarm
+	snoozed bool
+}
+
+// alarmClock schedules alarms, and keeps them in a file, if any.
+type alarmClock struct {
+	mu      sync.Mutex
+	path    string
+	alarms  []*scheduledAlarm
+	snoozed *scheduledAlarm
+	wake    chan struct{} // Wakes the scheduler up when the alarms change
+}
+
+// newAlarmClock creates an alarm clock, with the alarms of the file at path, if any.
+// The file does not need to exist: it is created once alarms are added.
+func newAlarmClock(path string) (*alarmClock, error) {
+	c := &alarmClock{path: path, wake: make(chan struct{}, 1)}
+	if path == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var alarms []Alarm
+	if err := json.Unmarshal(data, &alarms); err != nil {
+		return nil, errors.Wrapf(err, "reading alarms from %s", path)
+	}
+	now := time.Now()
+	for _, a := range alarms {
+		if err := a.validate(); err != nil {
+			return nil, errors.Wrapf(err, "reading alarms from %s", path)
+		}
+		if c.find(a.Name) >= 0 {
+			return nil, errors.Errorf("reading alarms from %s: two alarms are named %q", path, a.Name)
+		}
+		c.alarms = append(c.alarms, &scheduledAlarm{Alarm: a, next: a.Schedule.next(now)})
+	}
+	return c, nil
+}
+
+// find returns the index of the alarm with the given name, or -1. It assumes that the lock is held.
+func (c *alarmClock) find(name string) int {
+	for i, a := range c.alarms {
+		if a.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// save writes the alarms into the file, if any. It assumes that the lock is held.
+func (c *alarmClock) save() error {
+	if c.path == "" {
+		return nil
+	}
+	alarms := make([]Alarm, len(c.alarms))
+	for i, a := range c.alarms {
+		alarms[i] = a.Alarm
+	}
+	data, err := json.MarshalIndent(alarms, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return writeFileAtomically(c.path, append(data, '\n'))
+}
+
+// writeFileAtomically writes data to the file at path, creating its directory if needed.
+// The data is written aside first, so that the file is never left half-written.
+func writeFileAtomically(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp, path))
+}
+
+// notify wakes the scheduler up.
+func (c *alarmClock) notify() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// add adds an alarm, replacing the alarm of the same name, if any.
+func (c *alarmClock) add(a Alarm, now time.Time) error {
+	if err := a.validate(); err != nil {
+		return err
+	}
+	if !a.Schedule.Once.IsZero() && !a.Schedule.Once.After(now) {
+		return errors.Errorf("alarm %q: %s is in the past", a.Name, a.Schedule)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	scheduled := &scheduledAlarm{Alarm: a, next: a.Schedule.next(now)}
+	if i := c.find(a.Name); i >= 0 {
+		c.alarms[i] = scheduled
+	} else {
+		c.alarms = append(c.alarms, scheduled)
+	}
+	c.notify()
+	return c.save()
+}
+
+// remove removes the alarm with the given name.
+func (c *alarmClock) remove(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.find(name)
+	if i < 0 {
+		return errors.Errorf("there is no alarm named %q", name)
+	}
+	c.alarms = append(c.alarms[:i], c.alarms[i+1:]...)
+	if c.snoozed != nil && c.snoozed.Name == name {
+		c.snoozed = nil
+	}
+	c.notify()
+	return c.save()
+}
+
+// list returns the alarms, with the next time they fire.
+func (c *alarmClock) list() []scheduledAlarm {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	alarms := make([]scheduledAlarm, len(c.alarms))
+	for i, a := range c.alarms {
+		alarms[i] = *a
+	}
+	return alarms
+}
+
+// snooze makes the alarm a ring again after its snooze time.
+func (c *alarmClock) snooze(a Alarm, now time.Time) {
+	d := a.Snooze
+	if d == 0 {
+		d = defaultSnooze
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.snoozed = &scheduledAlarm{Alarm: a, next: now.Add(d)}
+	log.Printf("Alarm %q snoozed until %s\n", a.Name, c.snoozed.next.Format("15:04:05"))
+	c.notify()
+}
+
+// due returns the alarms due at now, and schedules their next time. One-off alarms are removed once due.
+// It also returns how long to wait until the next alarm, or -1 if there is none.
+func (c *alarmClock) due(now time.Time) (rings []alarmRing, wait time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kept := make([]*scheduledAlarm, 0, len(c.alarms))
+	for _, a := range c.alarms {
+		if !a.next.After(now) {
+			if late := now.Sub(a.next); late <= alarmGrace {
+				rings = append(rings, alarmRing{Alarm: a.Alarm})
+			} else {
+				log.Printf("Alarm %q was missed by %v, skipping it\n", a.Name, late.Round(time.Second))
+			}
+			if !a.Schedule.Once.IsZero() {
+				continue
+			}
+			a.next = a.Schedule.next(now)
+		}
+		kept = append(kept, a)
+	}
+	if len(kept) != len(c.alarms) {
+		c.alarms = kept
+		if err := c.save(); err != nil {
+			log.Printf("Cannot save the alarms: %+v\n", err)
+		}
+	}
+	if c.snoozed != nil && !c.snoozed.next.After(now) {
+		rings = append(rings, alarmRing{Alarm: c.snoozed.Alarm, snoozed: true})
+		c.snoozed = nil
+	}
+
+	wait = -1
+	next := c.alarms
+	if c.snoozed != nil {
+		next = append(next[:len(next):len(next)], c.snoozed)
+	}
+	for _, a := range next {
+		if d := a.next.Sub(now); wait < 0 || d < wait {
+			wait = d
+		}
+	}
+	if wait > alarmCheckInterval {
+		wait = alarmCheckInterval
+	}
+	return rings, wait
+}
+
+// runAlarms rings the alarms when they are due.
+func (p *Player) runAlarms(ctx context.Context) {
+	c := p.alarms
+	for {
+		// Round(0) drops the monotonic clock reading, which stops during suspend: alarms follow the wall clock.
+		rings, wait := c.due(time.Now().Round(0))
+		for _, r := range rings {
+			p.ringAlarm(r)
+		}
+		var timer <-chan time.Time
+		if wait >= 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.wake:
+		case <-timer:
+		}
+	}
+}
+
+// ringAlarm starts playback for an alarm.
+func (p *Player) ringAlarm(r alarmRing) {
+	log.Printf("Alarm %q is ringing\n", r.Name)
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	if err := p.startAlarm(b, r); err != nil {
+		log.Printf("Cannot ring alarm %q: %v\n", r.Name, err)
+		return
+	}
+	s.ringing = &r.Alarm
+}
+
+// startAlarm loads what the alarm plays, sets its volume, and starts playback. It assumes that the status lock is held.
+func (p *Player) startAlarm(b *batch, r alarmRing) *dbus.Error {
+	s := &p.status
+	play := func(cl *mpd.CommandList) { cl.Play(-1) }
+	switch {
+	case r.snoozed:
+	case r.Playlist != "":
+		play = func(cl *mpd.CommandList) {
+			cl.Clear()
+			cl.Load(r.Playlist)
+			cl.Play(0)
+		}
+	case r.URI != "":
+		id, err := p.mpd.AddID(p.mpd.PathFromURI(r.URI), -1)
+		if err != nil {
+			return p.transformErr(err)
+		}
+		play = func(cl *mpd.CommandList) { cl.PlayID(id) }
+	}
+	if !s.HasVolume {
+		return s.updateWith(p, b, play)
+	}
+
+	volume := s.mpdVolume
+	if r.Volume > 0 {
+		volume = p.volumeCurve.ToMPD(r.Volume)
+	}
+	if limit := p.maxMPDVolume(); volume > limit {
+		volume = limit
+	}
+	if r.Ramp > 0 {
+		// The ramp goes first, so that the volume is low from the start.
+		p.rampVolume(p.volumeCurve.ToMPD(r.StartVolume), volume, r.Ramp, nil)
+		return s.updateWith(p, b, play)
+	}
+	return s.updateWith(p, b, func(cl *mpd.CommandList) {
+		cl.SetVolume(volume)
+		play(cl)
+	})
+}
+
+// snoozeAlarm pauses the ringing alarm, and returns it.
+func (p *Player) snoozeAlarm() (*Alarm, *dbus.Error) {
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	a := s.ringing
+	if a == nil {
+		return nil, dbus.MakeFailedError(errors.New("no alarm is ringing"))
+	}
+	s.ringing = nil
+	return a, p.fadeOut(b, func(cl *mpd.CommandList) { cl.Pause(true) })
+}
@@ -0,0 +1,215 @@
+package mpris
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func TestParseAlarmSchedule(t *testing.T) {
+	// A Sunday.
+	now := time.Date(2026, 10, 18, 8, 0, 0, 0, time.Local)
+	weekdays := [7]bool{false, true, true, true, true, true, false}
+	for _, test := range []struct {
+		s    string
+		want AlarmSchedule
+	}{
+		{"07:30", AlarmSchedule{Once: time.Date(2026, 10, 19, 7, 30, 0, 0, time.Local)}},
+		{"09:00", AlarmSchedule{Once: time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)}},
+		{"2026-11-02 06:15", AlarmSchedule{Once: time.Date(2026, 11, 2, 6, 15, 0, 0, time.Local)}},
+		{"mon-fri 07:30", AlarmSchedule{Days: weekdays, At: 7*time.Hour + 30*time.Minute}},
+		{"Monday,tue,wed-fri 07:30", AlarmSchedule{Days: weekdays, At: 7*time.Hour + 30*time.Minute}},
+		{"fri-mon 10:00", AlarmSchedule{Days: [7]bool{true, true, false, false, false, true, true}, At: 10 * time.Hour}},
+		{"daily 10:00", AlarmSchedule{Days: [7]bool{true, true, true, true, true, true, true}, At: 10 * time.Hour}},
+	} {
+		a, err := ParseAlarmSchedule(test.s, now)
+		if err != nil {
+			t.Errorf("ParseAlarmSchedule(%q): %+v", test.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(a, test.want) {
+			t.Errorf("ParseAlarmSchedule(%q) = %+v, want %+v", test.s, a, test.want)
+		}
+		if back, err := ParseAlarmSchedule(a.String(), now); err != nil || !reflect.DeepEqual(back, a) {
+			t.Errorf("ParseAlarmSchedule(%q) = %+v, %v", a.String(), back, err)
+		}
+	}
+	for _, s := range []string{"", "mon", "xyz 07:00", "mon 25:00", "mo 07:00", "mon tue 07:00"} {
+		if _, err := ParseAlarmSchedule(s, now); err == nil {
+			t.Errorf("ParseAlarmSchedule(%q) should fail", s)
+		}
+	}
+}
+
+func TestAlarmScheduleNext(t *testing.T) {
+	a, err := ParseAlarmSchedule("mon-fri 07:30", time.Now())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	saturday := time.Date(2026, 10, 17, 12, 0, 0, 0, time.Local)
+	monday := time.Date(2026, 10, 19, 7, 30, 0, 0, time.Local)
+	if next := a.next(saturday); !next.Equal(monday) {
+		t.Errorf("next(saturday) = %v, want %v", next, monday)
+	}
+	if next := a.next(monday); !next.Equal(monday.AddDate(0, 0, 1)) {
+		t.Errorf("next(monday) = %v, want the day after", next)
+	}
+}
+
+func TestAlarmFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mpd-mpris", "alarms.json")
+	c, err := newAlarmClock(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	now := time.Now()
+	weekly := Alarm{Name: "weekdays", Playlist: "Morning", Volume: 0.4, StartVolume: 0.1, Ramp: 5 * time.Minute}
+	weekly.Schedule, _ = ParseAlarmSchedule("mon-fri 07:30", now)
+	once := Alarm{Name: "flight", URI: "http://radio.example/stream", Schedule: AlarmSchedule{Once: now.Add(time.Hour).Truncate(time.Minute)}}
+	for _, a := range []Alarm{weekly, once} {
+		if err := c.add(a, now); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if err := c.add(Alarm{Name: "past", Schedule: AlarmSchedule{Once: now.Add(-time.Hour)}}, now); err == nil {
+		t.Error("alarms in the past should be refused")
+	}
+
+	c, err = newAlarmClock(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var got []Alarm
+	for _, a := range c.list() {
+		got = append(got, a.Alarm)
+	}
+	if want := []Alarm{weekly, once}; !reflect.DeepEqual(got, want) {
+		t.Errorf("alarms read back = %+v, want %+v", got, want)
+	}
+
+	var written []map[string]interface{}
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if written[0]["when"] != "mon,tue,wed,thu,fri 07:30" || written[0]["ramp"] != "5m0s" {
+		t.Errorf("alarm written as %v", written[0])
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"name": "a", "when": "someday"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newAlarmClock(path); err == nil {
+		t.Error("invalid alarm files should be refused")
+	}
+}
+
+func TestAlarmClockDue(t *testing.T) {
+	c, _ := newAlarmClock("")
+	now := time.Date(2026, 10, 19, 7, 0, 0, 0, time.Local)
+	daily, _ := ParseAlarmSchedule("daily 07:30", now)
+	mustAdd := func(a Alarm) {
+		if err := c.add(a, now); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	mustAdd(Alarm{Name: "daily", Schedule: daily})
+	mustAdd(Alarm{Name: "once", Schedule: AlarmSchedule{Once: now.Add(10 * time.Minute)}})
+
+	if rings, wait := c.due(now); len(rings) != 0 || wait != alarmCheckInterval {
+		t.Errorf("due at 07:00 = %v, %v", rings, wait)
+	}
+	if rings, wait := c.due(now.Add(10*time.Minute - time.Second)); len(rings) != 0 || wait != time.Second {
+		t.Errorf("due at 07:09:59 = %v, %v", rings, wait)
+	}
+	// Waking up from suspend a little late.
+	rings, _ := c.due(now.Add(12 * time.Minute))
+	if len(rings) != 1 || rings[0].Name != "once" {
+		t.Errorf("due at 07:12 = %v, want once", rings)
+	}
+	if alarms := c.list(); len(alarms) != 1 {
+		t.Errorf("one-off alarms should be removed once due, left %v", alarms)
+	}
+	// Waking up from suspend much too late.
+	if rings, _ := c.due(now.Add(2 * time.Hour)); len(rings) != 0 {
+		t.Errorf("due at 09:00 = %v, the daily alarm was missed", rings)
+	}
+	if next := c.list()[0].next; !next.Equal(time.Date(2026, 10, 20, 7, 30, 0, 0, time.Local)) {
+		t.Errorf("next daily alarm at %v", next)
+	}
+
+	c.snooze(Alarm{Name: "daily", Snooze: time.Minute}, now)
+	if rings, wait := c.due(now); len(rings) != 0 || wait != alarmCheckInterval {
+		t.Errorf("due at 07:00 = %v, %v", rings, wait)
+	}
+	if rings, _ := c.due(now.Add(time.Minute)); len(rings) != 1 || !rings[0].snoozed {
+		t.Errorf("due at 07:01 = %v, want the snoozed alarm", rings)
+	}
+}
+
+func TestPlayerAlarm(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		for _, q := range st.Queue {
+			st.Library = append(st.Library, q.Song)
+		}
+		st.Volume = 80
+		st.Playlists["Morning"] = []string{"b.flac", "c.flac"}
+	})
+	drain(p)
+
+	mustOK(t, p.extension.AddAlarm("morning", "daily 07:30", map[string]dbus.Variant{
+		"playlist":     dbus.MakeVariant("Morning"),
+		"volume":       dbus.MakeVariant(0.4),
+		"start_volume": dbus.MakeVariant(0.1),
+		"ramp":         dbus.MakeVariant(int64(UsFromDuration(200 * time.Millisecond))),
+	}))
+	alarms, err := p.extension.ListAlarms()
+	mustOK(t, err)
+	if when := alarms["morning"]["when"].Value(); when != "daily 07:30" {
+		t.Errorf("ListAlarms()[morning][when] = %v", when)
+	}
+	if err := p.extension.AddAlarm("bad", "daily 07:30", map[string]dbus.Variant{"volume": dbus.MakeVariant("loud")}); err == nil {
+		t.Error("AddAlarm with a string volume should fail")
+	}
+	if err := p.extension.Snooze(); err == nil {
+		t.Error("Snooze without a ringing alarm should fail")
+	}
+
+	p.ringAlarm(alarmRing{Alarm: p.alarms.list()[0].Alarm})
+	s.Inspect(func(st *mpdtest.State) {
+		if len(st.Queue) != 2 || st.Queue[0].Song["file"] != "b.flac" || st.Playback != "play" {
+			t.Errorf("queue %v, %s after the alarm, want the Morning playlist playing", st.Queue, st.Playback)
+		}
+		if st.Volume != 10 {
+			t.Errorf("volume = %d, want the start volume", st.Volume)
+		}
+	})
+	waitForVolume(t, s, 40)
+
+	mustOK(t, p.extension.Snooze())
+	if _, playback, _ := current(s); playback != "pause" {
+		t.Errorf("playback = %s after snoozing", playback)
+	}
+	if p.alarms.snoozed == nil {
+		t.Fatal("the alarm should be snoozed")
+	}
+	p.ringAlarm(alarmRing{Alarm: p.alarms.snoozed.Alarm, snoozed: true})
+	s.Inspect(func(st *mpdtest.State) {
+		if len(st.Queue) != 2 || st.Playback != "play" {
+			t.Errorf("queue %v, %s after the snoozed alarm, want the same queue playing", st.Queue, st.Playback)
+		}
+	})
+	waitForVolume(t, s, 40)
+
+	mustOK(t, p.extension.RemoveAlarm("morning"))
+	if err := p.extension.RemoveAlarm("morning"); err == nil {
+		t.Error("removing a missing alarm should fail")
+	}
+}
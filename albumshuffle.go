@@ -0,0 +1,228 @@
+package mpris
+
+import (
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd"
+)
+
+// This file implements album shuffle: whole albums play in a random order, each one in disc and track order.
+// The queue holds the album playing and the next one: as an album starts, the one after it is queued,
+// and the one before it is removed.
+
+// An album is a group of songs sharing their album and album artist, in disc and track order.
+type album struct {
+	key  string
+	uris []string
+}
+
+// albumShuffle is the order of the albums played by album shuffle.
+// It is guarded by the status lock.
+type albumShuffle struct {
+	albums  []album
+	next    int    // The album to queue next
+	last    string // The key of the album queued last
+	playing string // The key of the album playing, once the last one queued starts
+	rand    *rand.Rand
+}
+
+// albumKey returns the key grouping f with the other songs of its album.
+// Songs without an album each make up their own.
+func albumKey(f mpd.File) string {
+	if f.Album == "" {
+		return "\x00" + f.Path()
+	}
+	artist := f.AlbumArtist
+	if artist == "" {
+		artist = f.Artist
+	}
+	return f.Album + "\x00" + artist
+}
+
+// discNumber returns the disc of f, from tags like "2" or "2/3", or 0 without one.
+func discNumber(f mpd.File) int {
+	disc := f.Attrs["Disc"]
+	end := 0
+	for end < len(disc) && disc[end] >= '0' && disc[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(disc[:end])
+	return n
+}
+
+// groupAlbums groups files by album, in the order their albums first appear.
+// Songs are in disc and track order within their album.
+func groupAlbums(files []mpd.File) []album {
+	var albums []album
+	songs := make(map[string][]mpd.File)
+	for _, f := range files {
+		key := albumKey(f)
+		if _, ok := songs[key]; !ok {
+			albums = append(albums, album{key: key})
+		}
+		songs[key] = append(songs[key], f)
+	}
+	for i := range albums {
+		files := songs[albums[i].key]
+		sort.SliceStable(files, func(i, j int) bool {
+			if di, dj := discNumber(files[i]), discNumber(files[j]); di != dj {
+				return di < dj
+			}
+			return files[i].Track < files[j].Track
+		})
+		albums[i].uris = make([]string, len(files))
+		for j, f := range files {
+			albums[i].uris[j] = f.Path()
+		}
+	}
+	return albums
+}
+
+// albumSource returns the songs album shuffle plays from source: "queue" for the queue,
+// or "library" for the whole library, or for the songs matching filter, as arguments of MPD's find command.
+func (p *Player) albumSource(source string, filter []string) ([]mpd.File, *dbus.Error) {
+	switch source {
+	case "queue":
+		if len(filter) > 0 {
+			return nil, invalidArgs("filters only apply to the library")
+		}
+		files, err := p.mpd.PlaylistInfo(-1, -1)
+		return files, p.transformErr(err)
+	case "library":
+		if len(filter) > 0 {
+			if len(filter)%2 != 0 {
+				return nil, invalidArgs("filters should be pairs of a tag and a value")
+			}
+			files, err := p.mpd.Find(filter...)
+			return files, p.transformErr(err)
+		}
+		items, err := p.mpd.ListAllInfo("/")
+		if err != nil {
+			return nil, p.transformErr(err)
+		}
+		var files []mpd.File
+		for _, item := range items {
+			if f, ok := item.(mpd.File); ok {
+				files = append(files, f)
+			}
+		}
+		return files, nil
+	default:
+		return nil, invalidArgs("unknown source %q, should be \"queue\" or \"library\"", source)
+	}
+}
+
+// startAlbumShuffle replaces the queue with the first album of a random order of the albums of source,
+// and plays it. The other albums follow as the queue runs out.
+func (p *Player) startAlbumShuffle(source string, filter []string) *dbus.Error {
+	files, derr := p.albumSource(source, filter)
+	if derr != nil {
+		return derr
+	}
+	albums := groupAlbums(files)
+	if len(albums) == 0 {
+		return invalidArgs("there are no songs to shuffle")
+	}
+	shuffle := &albumShuffle{albums: albums, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	shuffle.rand.Shuffle(len(albums), func(i, j int) { albums[i], albums[j] = albums[j], albums[i] })
+
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	if _, _, err := s.fetch(p, func(cl *mpd.CommandList) {
+		cl.Stop()
+		cl.Clear()
+		cl.Random(false) // Albums play in order.
+		cl.Single(false)
+	}, false); err != nil {
+		return err
+	}
+	s.queueOrder = nil // There is no order to put back anymore.
+	first := p.queueAlbum(shuffle)
+	s.albums = shuffle
+	b.set("org.mpd.MediaPlayer2.Player", "AlbumShuffle", true)
+	log.Printf("Album shuffle started, with %d albums\n", len(albums))
+	if first == -1 {
+		return s.updateWith(p, b, nil)
+	}
+	return s.updateWith(p, b, func(cl *mpd.CommandList) { cl.PlayID(first) })
+}
+
+// queueAlbum adds the next album of shuffle at the end of the queue, and returns the ID of its first song,
+// or -1 if none could be added. It assumes that the status lock is held.
+func (p *Player) queueAlbum(shuffle *albumShuffle) int {
+	a := shuffle.albums[shuffle.next]
+	shuffle.next++
+	shuffle.last = a.key
+	first := -1
+	// Added one by one, as a missing song would interrupt a command list.
+	for _, uri := range a.uris {
+		id, err := p.mpd.AddID(uri, -1)
+		if err != nil {
+			log.Printf("Cannot add %s to the queue: %v\n", uri, err)
+			continue
+		}
+		if first == -1 {
+			first = id
+		}
+	}
+	return first
+}
+
+// stockAlbums queues the next album once song, which just became current, starts the album queued last,
+// and removes the songs before it. Once every album was queued, they are shuffled again if the queue repeats.
+// It assumes that the status lock is held.
+func (p *Player) stockAlbums(status mpd.Status, song mpd.Song) {
+	shuffle := p.status.albums
+	key := albumKey(song.File)
+	if shuffle == nil || song.ID == -1 || key != shuffle.last || key == shuffle.playing {
+		return
+	}
+	shuffle.playing = key
+	if pos, err := strconv.Atoi(song.Attrs["Pos"]); err == nil && pos > 0 {
+		if err := p.mpd.Delete(0, pos); err != nil {
+			log.Printf("Cannot remove the albums played: %v\n", err)
+		}
+	}
+	if shuffle.next == len(shuffle.albums) {
+		if !status.Repeat {
+			return
+		}
+		albums := shuffle.albums
+		shuffle.rand.Shuffle(len(albums), func(i, j int) { albums[i], albums[j] = albums[j], albums[i] })
+		// The album playing does not play again right away.
+		if len(albums) > 1 && albums[0].key == shuffle.last {
+			albums[0], albums[len(albums)-1] = albums[len(albums)-1], albums[0]
+		}
+		shuffle.next = 0
+	}
+	p.queueAlbum(shuffle)
+}
+
+// endAlbumShuffle stops queueing albums, and leaves the queue as it is. It assumes that the status lock is held.
+func (s *Status) endAlbumShuffle(b *batch) {
+	if s.albums == nil {
+		return
+	}
+	s.albums = nil
+	b.set("org.mpd.MediaPlayer2.Player", "AlbumShuffle", false)
+	log.Printf("Album shuffle ended\n")
+}
+
+// stopAlbumShuffle ends album shuffle, if it is on.
+func (p *Player) stopAlbumShuffle() *dbus.Error {
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	s.endAlbumShuffle(b)
+	return s.updateWith(p, b, nil)
+}
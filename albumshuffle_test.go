@@ -0,0 +1,179 @@
+package mpris
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd"
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func TestGroupAlbums(t *testing.T) {
+	file := func(path, album, albumArtist, artist, disc string, track int) mpd.File {
+		return mpd.File{Album: album, AlbumArtist: albumArtist, Artist: artist, Track: track, Attrs: map[string]string{"file": path, "Disc": disc}}
+	}
+	albums := groupAlbums([]mpd.File{
+		file("x/2-1.flac", "X", "A", "A", "2/2", 1),
+		file("single.flac", "", "", "A", "", 0),
+		file("x/1-2.flac", "X", "A", "A", "1/2", 2),
+		file("y/1.flac", "X", "", "B", "", 1), // Another artist's X
+		file("x/1-1.flac", "X", "A", "Guest", "1/2", 1),
+	})
+	var got [][]string
+	for _, a := range albums {
+		got = append(got, a.uris)
+	}
+	want := [][]string{{"x/1-1.flac", "x/1-2.flac", "x/2-1.flac"}, {"single.flac"}, {"y/1.flac"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groupAlbums() = %v, want %v", got, want)
+	}
+}
+
+func TestPlayerAlbumShuffle(t *testing.T) {
+	albums := map[string][]string{
+		"X": {"x/1.flac", "x/2.flac", "x/3.flac"},
+		"Y": {"y/1.flac", "y/2.flac"},
+		"Z": {"z/1.flac"},
+	}
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		for name, uris := range albums {
+			// In reverse, to be put back in track order.
+			for i := len(uris) - 1; i >= 0; i-- {
+				st.Library = append(st.Library, mpdtest.Song{"file": uris[i], "Album": name, "AlbumArtist": "A", "Track": string(rune('1' + i))})
+			}
+		}
+		threeSongs(st)
+		st.Play(0)
+	})
+	queue := func() (files []string, current string) {
+		s.Inspect(func(st *mpdtest.State) {
+			for _, q := range st.Queue {
+				files = append(files, q.Song["file"])
+			}
+			if st.Current != -1 {
+				current = st.Queue[st.Current].Song["file"]
+			}
+		})
+		return
+	}
+	// albumOf returns the album of the song at uri.
+	albumOf := func(uri string) string { return string(uri[0] - 'a' + 'A') }
+	drain(p)
+
+	mustOK(t, p.extension.StartAlbumShuffle("library", nil))
+	// The first album plays, and the next one is queued.
+	files, current := queue()
+	first, second := albumOf(files[0]), albumOf(files[len(files)-1])
+	if want := append(append([]string{}, albums[first]...), albums[second]...); !reflect.DeepEqual(files, want) || first == second {
+		t.Fatalf("queue %v, want two whole albums", files)
+	}
+	if current != files[0] {
+		t.Errorf("playing %s, want %s", current, files[0])
+	}
+	b := drain(p)
+	if v, ok := b.changed("Shuffle"); !ok || v != true {
+		t.Errorf("Shuffle = %v (changed %v), want true", v, ok)
+	}
+	if v, _ := p.Instance.props.Get("org.mpd.MediaPlayer2.Player", "AlbumShuffle"); v.Value() != true {
+		t.Errorf("AlbumShuffle = %v", v)
+	}
+
+	// The last album is queued as soon as the second one starts, and the first one is removed.
+	s.Modify(func(st *mpdtest.State) { st.Play(len(albums[first])) })
+	mustOK(t, p.status.UpdatePlayer(p))
+	files, current = queue()
+	third := albumOf(files[len(files)-1])
+	if want := append(append([]string{}, albums[second]...), albums[third]...); !reflect.DeepEqual(files, want) || third == first || third == second {
+		t.Errorf("queue %v, want the second and last albums", files)
+	}
+	if current != files[0] {
+		t.Errorf("playing %s, want %s", current, files[0])
+	}
+	// Nothing is left to queue, as the queue does not repeat.
+	s.Modify(func(st *mpdtest.State) { st.Play(len(albums[second])) })
+	mustOK(t, p.status.UpdatePlayer(p))
+	if files, _ = queue(); !reflect.DeepEqual(files, albums[third]) {
+		t.Errorf("queue %v, want the last album only", files)
+	}
+
+	// Turning Shuffle off ends album shuffle, and leaves the queue.
+	mustOK(t, p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "Shuffle", dbus.MakeVariant(false)))
+	if v := getProp(t, p, "Shuffle"); v != false {
+		t.Errorf("Shuffle = %v", v)
+	}
+	if v, _ := p.Instance.props.Get("org.mpd.MediaPlayer2.Player", "AlbumShuffle"); v.Value() != false {
+		t.Errorf("AlbumShuffle = %v", v)
+	}
+	if after, _ := queue(); !reflect.DeepEqual(after, files) {
+		t.Errorf("queue %v, want %v", after, files)
+	}
+}
+
+func TestPlayerAlbumShuffleRepeat(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		st.Library = []mpdtest.Song{
+			{"file": "x.flac", "Album": "X", "Genre": "Jazz"},
+			{"file": "y.flac", "Album": "Y", "Genre": "Jazz"},
+			{"file": "z.flac", "Album": "Z", "Genre": "Rock"},
+		}
+		st.Repeat = true
+	})
+	queue := func() (files []string) {
+		s.Inspect(func(st *mpdtest.State) {
+			for _, q := range st.Queue {
+				files = append(files, q.Song["file"])
+			}
+		})
+		return
+	}
+
+	mustOK(t, p.extension.StartAlbumShuffle("library", []string{"genre", "Jazz"}))
+	if files := queue(); len(files) != 2 || files[0] == files[1] || files[0] == "z.flac" || files[1] == "z.flac" {
+		t.Fatalf("queue %v, want the two Jazz albums", files)
+	}
+	if v := getProp(t, p, "LoopStatus"); v != string(LoopStatusPlaylist) {
+		t.Errorf("LoopStatus = %v", v)
+	}
+	// With every album queued, they are shuffled again, and the one playing does not come right after itself.
+	// Albums played are removed, so that the queue does not grow as it repeats.
+	for i := 0; i < 5; i++ {
+		s.Modify(func(st *mpdtest.State) { st.Play(1) })
+		mustOK(t, p.status.UpdatePlayer(p))
+		if files := queue(); len(files) != 2 || files[0] == files[1] {
+			t.Fatalf("queue %v after %d albums, want the album playing and another one", files, i+2)
+		}
+	}
+
+	// Another client turning random on ends album shuffle, while Shuffle stays on.
+	s.Modify(func(st *mpdtest.State) { st.Random = true })
+	mustOK(t, p.status.UpdateOptions(p))
+	if v := getProp(t, p, "Shuffle"); v != true {
+		t.Errorf("Shuffle = %v", v)
+	}
+	if p.status.albums != nil {
+		t.Error("album shuffle is still on with random playback")
+	}
+}
+
+func TestPlayerAlbumShuffleInvalid(t *testing.T) {
+	p, _ := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		for _, q := range st.Queue {
+			st.Library = append(st.Library, q.Song)
+		}
+	})
+	for _, c := range []struct {
+		source string
+		filter []string
+	}{{"playlist", nil}, {"queue", []string{"genre", "Jazz"}}, {"library", []string{"genre"}}, {"library", []string{"genre", "Jazz"}}} {
+		if err := p.extension.StartAlbumShuffle(c.source, c.filter); err == nil {
+			t.Errorf("StartAlbumShuffle(%q, %q) should fail", c.source, c.filter)
+		}
+	}
+	// The queue's own songs, without albums, each make up one.
+	mustOK(t, p.extension.StartAlbumShuffle("queue", nil))
+	if v := getProp(t, p, "Shuffle"); v != true {
+		t.Errorf("Shuffle = %v", v)
+	}
+}
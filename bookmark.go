@@ -0,0 +1,257 @@
+package mpris
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd"
+	"github.com/pkg/errors"
+)
+
+// This file implements bookmarks: named positions within tracks, like the topics of a lecture or the tracks of a DJ mix.
+// They are kept as JSON in a sticker of their track, where other clients can find them.
+
+// The sticker keeping the bookmarks of a track.
+const bookmarksSticker = "bookmarks"
+
+// A Bookmark is a named position within a track.
+// Its ID is the track's URI, followed by "#" and a number unique within the track.
+type Bookmark struct {
+	ID       string
+	Name     string
+	Position TimeInUs
+}
+
+// storedBookmark is a bookmark as kept in the sticker, with its position in seconds.
+type storedBookmark struct {
+	N        int     `json:"n"`
+	Name     string  `json:"name"`
+	Position float64 `json:"position"`
+}
+
+// bookmarkID returns the ID of the bookmark n of the track at uri.
+func bookmarkID(uri string, n int) string {
+	return uri + "#" + strconv.Itoa(n)
+}
+
+// parseBookmarkID returns the URI of the track and the number of the bookmark with the given ID.
+func parseBookmarkID(id string) (uri string, n int, err error) {
+	i := strings.LastIndexByte(id, '#')
+	if i <= 0 {
+		return "", 0, errors.Errorf("%q is not a bookmark ID", id)
+	}
+	if n, err = strconv.Atoi(id[i+1:]); err != nil {
+		return "", 0, errors.Errorf("%q is not a bookmark ID", id)
+	}
+	return id[:i], n, nil
+}
+
+// readBookmarks returns the bookmarks kept for the track at uri, by position.
+func (p *Player) readBookmarks(uri string) ([]storedBookmark, error) {
+	value, ok, err := p.mpd.Sticker(uri, bookmarksSticker)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var bookmarks []storedBookmark
+	if err := json.Unmarshal([]byte(value), &bookmarks); err != nil {
+		return nil, errors.Wrapf(err, "invalid bookmarks of %s", uri)
+	}
+	sort.SliceStable(bookmarks, func(i, j int) bool { return bookmarks[i].Position < bookmarks[j].Position })
+	return bookmarks, nil
+}
+
+// writeBookmarks keeps bookmarks for the track at uri, removing the sticker if there are none.
+func (p *Player) writeBookmarks(uri string, bookmarks []storedBookmark) error {
+	if len(bookmarks) == 0 {
+		return p.mpd.DeleteSticker(uri, bookmarksSticker)
+	}
+	data, err := json.Marshal(bookmarks)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return p.mpd.SetSticker(uri, bookmarksSticker, string(data))
+}
+
+// exportBookmarks returns the bookmarks of the track at uri, as exported on D-Bus.
+func exportBookmarks(uri string, stored []storedBookmark) []Bookmark {
+	bookmarks := make([]Bookmark, len(stored))
+	for i, b := range stored {
+		bookmarks[i] = Bookmark{ID: bookmarkID(uri, b.N), Name: b.Name, Position: TimeInUs(b.Position * 1e6)}
+	}
+	return bookmarks
+}
+
+// songBookmarks returns the bookmarks of song, or none if they cannot be read.
+// Without a sticker database, there are none, and MPD is not asked.
+func (p *Player) songBookmarks(song mpd.Song) []Bookmark {
+	if song.ID == -1 || strings.Contains(song.Path(), "://") || !p.mpd.HasStickers {
+		return nil
+	}
+	stored, err := p.readBookmarks(song.Path())
+	if err != nil {
+		log.Printf("Cannot read the bookmarks of %s: %+v\n", song.Path(), err)
+		return nil
+	}
+	return exportBookmarks(song.Path(), stored)
+}
+
+// UpdateBookmarks reads the bookmarks of the current track again.
+// Happens on "sticker" events, as other clients may change them, except those of our own resume positions.
+// MPD merges the events that happen between two idles, so a change by another client at the same time waits for the next one.
+func (s *Status) UpdateBookmarks(p *Player) *dbus.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stickerWritten {
+		s.stickerWritten = false
+		return nil
+	}
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	s.setBookmarks(b, p.songBookmarks(s.CurrentSong))
+	return nil
+}
+
+// setBookmarks sets the bookmarks of the current track. It assumes that the status lock is held.
+func (s *Status) setBookmarks(b *batch, bookmarks []Bookmark) {
+	if len(bookmarks) == 0 && len(s.bookmarks) == 0 {
+		return
+	}
+	s.bookmarks = bookmarks
+	b.set("org.mpris.MediaPlayer2.Player", "Metadata", s.metadata())
+}
+
+// addBookmark bookmarks the current position of the current track, and returns the bookmark's ID.
+func (p *Player) addBookmark(name string) (string, *dbus.Error) {
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	if err := s.updateWith(p, b, nil); err != nil {
+		return "", err
+	}
+	uri := s.CurrentSong.Path()
+	if s.CurrentSong.ID == -1 {
+		return "", dbus.MakeFailedError(errors.New("there is no current track to bookmark"))
+	}
+	stored, err := p.readBookmarks(uri)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	n := 1
+	for _, bm := range stored {
+		if bm.N >= n {
+			n = bm.N + 1
+		}
+	}
+	stored = append(stored, storedBookmark{N: n, Name: name, Position: s.Seek.Seconds()})
+	sort.SliceStable(stored, func(i, j int) bool { return stored[i].Position < stored[j].Position })
+	if err := p.writeBookmarks(uri, stored); err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	s.setBookmarks(b, exportBookmarks(uri, stored))
+	return bookmarkID(uri, n), nil
+}
+
+// removeBookmark removes the bookmark with the given ID.
+func (p *Player) removeBookmark(id string) *dbus.Error {
+	uri, n, err := parseBookmarkID(id)
+	if err != nil {
+		return invalidArgs("%v", err)
+	}
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	stored, err := p.readBookmarks(uri)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	i := -1
+	for j, bm := range stored {
+		if bm.N == n {
+			i = j
+		}
+	}
+	if i == -1 {
+		return dbus.MakeFailedError(errors.Errorf("there is no bookmark %s", id))
+	}
+	stored = append(stored[:i], stored[i+1:]...)
+	if err := p.writeBookmarks(uri, stored); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	if s.CurrentSong.ID != -1 && s.CurrentSong.Path() == uri {
+		s.setBookmarks(b, exportBookmarks(uri, stored))
+	}
+	return nil
+}
+
+// goToBookmark plays the track of the bookmark with the given ID from the bookmark.
+// The track is added after the current one if it is not in the queue.
+func (p *Player) goToBookmark(id string) *dbus.Error {
+	uri, n, err := parseBookmarkID(id)
+	if err != nil {
+		return invalidArgs("%v", err)
+	}
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	stored, err := p.readBookmarks(uri)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	var bookmark *storedBookmark
+	for i, bm := range stored {
+		if bm.N == n {
+			bookmark = &stored[i]
+		}
+	}
+	if bookmark == nil {
+		return dbus.MakeFailedError(errors.Errorf("there is no bookmark %s", id))
+	}
+
+	songID, err := p.queuedSong(uri)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	pos := TimeInUs(bookmark.Position * 1e6).Duration()
+	b.seek(UsFromDuration(pos))
+	return s.updateWith(p, b, func(cl *mpd.CommandList) {
+		cl.PlayID(songID)
+		cl.SeekID(songID, pos)
+	})
+}
+
+// queuedSong returns the ID of the song at uri in the queue: the current song if it is at uri,
+// otherwise the first one at uri, otherwise a new one, added after the current song.
+// It assumes that the status lock is held.
+func (p *Player) queuedSong(uri string) (int, error) {
+	s := &p.status
+	if s.CurrentSong.ID != -1 && s.CurrentSong.Path() == uri {
+		return s.CurrentSong.ID, nil
+	}
+	queue, err := p.mpd.PlaylistInfo(-1, -1)
+	if err != nil {
+		return -1, err
+	}
+	for _, f := range queue {
+		if f.Path() == uri {
+			id, err := strconv.Atoi(f.Attrs["Id"])
+			return id, errors.Wrap(err, "parsing song ID")
+		}
+	}
+	pos := -1
+	if s.CurrentSong.ID != -1 {
+		if current, err := strconv.Atoi(s.CurrentSong.Attrs["Pos"]); err == nil {
+			pos = current + 1
+		}
+	}
+	return p.mpd.AddID(uri, pos)
+}
@@ -0,0 +1,99 @@
+package mpris
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func TestParseBookmarkID(t *testing.T) {
+	if uri, n, err := parseBookmarkID(bookmarkID("Mixes/#1 hits.flac", 3)); err != nil || uri != "Mixes/#1 hits.flac" || n != 3 {
+		t.Errorf("parseBookmarkID() = %q, %d, %v", uri, n, err)
+	}
+	for _, id := range []string{"", "a.flac", "#1", "a.flac#x"} {
+		if _, _, err := parseBookmarkID(id); err == nil {
+			t.Errorf("parseBookmarkID(%q) should fail", id)
+		}
+	}
+}
+
+func TestPlayerBookmarks(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		for _, q := range st.Queue {
+			st.Library = append(st.Library, q.Song)
+		}
+		st.Library = append(st.Library, mpdtest.Song{"file": "d.flac", "Title": "D", "duration": "400"})
+		st.Stickers["d.flac"] = map[string]string{bookmarksSticker: `[{"n":1,"name":"Drop","position":123.5}]`}
+		st.Play(0)
+		st.Elapsed = 30 * time.Second
+	})
+	drain(p)
+
+	if _, err := p.extension.AddBookmark("Intro"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	s.Modify(func(st *mpdtest.State) { st.Elapsed = 10 * time.Second })
+	id, err := p.extension.AddBookmark("Before")
+	mustOK(t, err)
+	if id != "a.flac#2" {
+		t.Errorf("AddBookmark() = %q, want a.flac#2", id)
+	}
+	want := []Bookmark{
+		{ID: "a.flac#2", Name: "Before", Position: UsFromDuration(10 * time.Second)},
+		{ID: "a.flac#1", Name: "Intro", Position: UsFromDuration(30 * time.Second)},
+	}
+	if bookmarks, _ := p.extension.ListBookmarks(""); !reflect.DeepEqual(bookmarks, want) {
+		t.Errorf("ListBookmarks() = %v, want %v", bookmarks, want)
+	}
+	metadata, _ := drain(p).changed("Metadata")
+	if metadata == nil || !reflect.DeepEqual(metadata.(MetadataMap)["mpd:bookmarks"], want) {
+		t.Errorf("Metadata = %v, want the bookmarks", metadata)
+	}
+
+	// Within the current track.
+	mustOK(t, p.extension.GoToBookmark("a.flac#1"))
+	if pos, playback, elapsed := current(s); pos != 0 || playback != "play" || elapsed != 30*time.Second {
+		t.Errorf("playing %d (%s) at %v, want a.flac at 30s", pos, playback, elapsed)
+	}
+
+	// On a track missing from the queue.
+	mustOK(t, p.extension.GoToBookmark("d.flac#1"))
+	s.Inspect(func(st *mpdtest.State) {
+		if song := st.Queue[st.Current].Song; song["file"] != "d.flac" || st.Current != 1 || st.Elapsed != 123500*time.Millisecond {
+			t.Errorf("playing %v at %d, %v, want d.flac after a.flac at 123.5s", song, st.Current, st.Elapsed)
+		}
+	})
+	if b := drain(p); b.seeked == nil || *b.seeked != UsFromDuration(123500*time.Millisecond) {
+		t.Errorf("Seeked = %v, want 123.5s", b.seeked)
+	}
+
+	mustOK(t, p.extension.RemoveBookmark("a.flac#1"))
+	mustOK(t, p.extension.RemoveBookmark("a.flac#2"))
+	if err := p.extension.RemoveBookmark("a.flac#2"); err == nil {
+		t.Error("removing a missing bookmark should fail")
+	}
+	s.Inspect(func(st *mpdtest.State) {
+		if _, ok := st.Stickers["a.flac"][bookmarksSticker]; ok {
+			t.Error("the sticker should be removed with the last bookmark")
+		}
+	})
+	if bookmarks, _ := p.extension.ListBookmarks("a.flac"); len(bookmarks) != 0 {
+		t.Errorf("ListBookmarks(a.flac) = %v", bookmarks)
+	}
+
+	// Other clients changing the bookmarks.
+	s.Modify(func(st *mpdtest.State) {
+		st.Stickers["d.flac"][bookmarksSticker] = `[{"n":1,"name":"Drop","position":123.5},{"n":4,"name":"Outro","position":380}]`
+	})
+	for _, h := range p.handlers["sticker"] {
+		if err := h(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if bookmarks, _ := p.extension.ListBookmarks(""); len(bookmarks) != 2 || bookmarks[1].ID != "d.flac#4" {
+		t.Errorf("ListBookmarks() = %v after another client added one", bookmarks)
+	}
+}
@@ -0,0 +1,480 @@
+package mpris
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+	"github.com/natsukagami/mpd-mpris/mpd"
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+	"github.com/natsukagami/mpd-mpris/verify"
+)
+
+// These tests run the instance on a private bus, started with `dbus-daemon`.
+// They are skipped if it is not installed.
+
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:dir=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startBus starts a private bus daemon for the duration of the test, and returns its address.
+func startBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not available")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	if err := os.WriteFile(config, []byte(fmt.Sprintf(busConfig, dir)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--nopidfile", "--print-address=1")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading the bus address: %v", err)
+	}
+	return strings.TrimSpace(addr)
+}
+
+// busTest is an instance running on a private bus against a fake MPD, and a client of that bus.
+type busTest struct {
+	*testing.T
+	server  *mpdtest.Server
+	ins     *Instance
+	conn    *dbus.Conn // The instance's connection
+	client  *dbus.Conn
+	obj     dbus.BusObject
+	signals chan *dbus.Signal
+	stop    func() // Stops the instance, and waits for it
+}
+
+func newBusTest(t *testing.T, setup func(st *mpdtest.State), opts ...Option) *busTest {
+	t.Helper()
+	addr := startBus(t)
+
+	s, err := mpdtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if setup != nil {
+		s.Modify(setup)
+	}
+	c, err := mpd.Dial(s.Network(), s.Addr())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	ins, err := NewInstance(c, append([]Option{DBusConn(conn), InstanceName("test")}, opts...)...)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := ins.Start(ctx); err != nil {
+			t.Errorf("Start: %+v", err)
+		}
+	}()
+	bt := &busTest{
+		T:       t,
+		server:  s,
+		ins:     ins,
+		conn:    conn,
+		client:  client,
+		obj:     client.Object(ins.Name(), "/org/mpris/MediaPlayer2"),
+		signals: make(chan *dbus.Signal, 64),
+		stop: func() {
+			cancel()
+			<-done
+		},
+	}
+	t.Cleanup(func() {
+		bt.stop()
+		ins.Close()
+	})
+	if err := client.AddMatchSignal(dbus.WithMatchObjectPath("/org/mpris/MediaPlayer2")); err != nil {
+		t.Fatal(err)
+	}
+	client.Signal(bt.signals)
+	bt.waitForName()
+	return bt
+}
+
+// waitForName waits until the instance owns its name on the bus.
+func (bt *busTest) waitForName() {
+	bt.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var owned bool
+		if err := bt.client.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, bt.ins.Name()).Store(&owned); err != nil {
+			bt.Fatal(err)
+		}
+		if owned {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	bt.Fatalf("%s was never acquired", bt.ins.Name())
+}
+
+// call calls a method of the Player interface.
+func (bt *busTest) call(method string, args ...interface{}) error {
+	return bt.obj.Call("org.mpris.MediaPlayer2.Player."+method, 0, args...).Err
+}
+
+func (bt *busTest) mustCall(method string, args ...interface{}) {
+	bt.Helper()
+	if err := bt.call(method, args...); err != nil {
+		bt.Fatalf("%s: %v", method, err)
+	}
+}
+
+// set sets a property of the Player interface.
+func (bt *busTest) set(name string, value interface{}) error {
+	return bt.obj.SetProperty("org.mpris.MediaPlayer2.Player."+name, dbus.MakeVariant(value))
+}
+
+// next waits for the next signal.
+func (bt *busTest) next() *dbus.Signal {
+	bt.Helper()
+	select {
+	case sig := <-bt.signals:
+		return sig
+	case <-time.After(5 * time.Second):
+		bt.Fatal("timed out waiting for a signal")
+		return nil
+	}
+}
+
+// expectChanged waits for the next signal, which must be a `PropertiesChanged` of the Player interface, and returns the changes.
+func (bt *busTest) expectChanged() map[string]dbus.Variant {
+	bt.Helper()
+	sig := bt.next()
+	if sig.Name != "org.freedesktop.DBus.Properties.PropertiesChanged" {
+		bt.Fatalf("got %s %v, want PropertiesChanged", sig.Name, sig.Body)
+	}
+	if iface := sig.Body[0].(string); iface != "org.mpris.MediaPlayer2.Player" {
+		bt.Fatalf("PropertiesChanged on %s", iface)
+	}
+	return sig.Body[1].(map[string]dbus.Variant)
+}
+
+// expectSeeked waits for the next signal, which must be `Seeked`, and returns its position.
+func (bt *busTest) expectSeeked() time.Duration {
+	bt.Helper()
+	sig := bt.next()
+	if sig.Name != "org.mpris.MediaPlayer2.Player.Seeked" {
+		bt.Fatalf("got %s %v, want Seeked", sig.Name, sig.Body)
+	}
+	return time.Duration(sig.Body[0].(int64)) * time.Microsecond
+}
+
+// expectNone checks that no signal arrives for a short while.
+func (bt *busTest) expectNone() {
+	bt.Helper()
+	select {
+	case sig := <-bt.signals:
+		bt.Fatalf("unexpected signal %s %v", sig.Name, sig.Body)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestBusPlayback(t *testing.T) {
+	bt := newBusTest(t, threeSongs)
+
+	bt.mustCall("Play")
+	changed := bt.expectChanged()
+	if v := changed["PlaybackStatus"].Value(); v != "Playing" {
+		t.Errorf("PlaybackStatus = %v, want Playing", v)
+	}
+	if _, ok := changed["Metadata"]; !ok {
+		t.Error("Metadata should change when a song starts")
+	}
+	// The "player" event that follows changes nothing.
+	bt.expectNone()
+
+	bt.mustCall("Next")
+	changed = bt.expectChanged()
+	metadata := changed["Metadata"].Value().(map[string]dbus.Variant)
+	if title := metadata["xesam:title"].Value(); title != "B" {
+		t.Errorf("xesam:title = %v, want B", title)
+	}
+	if _, ok := changed["PlaybackStatus"]; ok {
+		t.Error("PlaybackStatus did not change")
+	}
+
+	bt.mustCall("Pause")
+	if v := bt.expectChanged()["PlaybackStatus"].Value(); v != "Paused" {
+		t.Errorf("PlaybackStatus = %v, want Paused", v)
+	}
+}
+
+func TestBusSeek(t *testing.T) {
+	bt := newBusTest(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Play(0)
+		st.Elapsed = 10 * time.Second
+	})
+
+	bt.mustCall("Seek", int64(20*time.Second/time.Microsecond))
+	if pos := bt.expectSeeked(); pos != 30*time.Second {
+		t.Errorf("Seeked(%v), want 30s", pos)
+	}
+
+	md, err := bt.obj.GetProperty("org.mpris.MediaPlayer2.Player.Metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trackID := md.Value().(map[string]dbus.Variant)["mpris:trackid"].Value().(dbus.ObjectPath)
+	if !strings.HasPrefix(string(trackID), "/org/mpd/MediaPlayer2/test/Track/") {
+		t.Errorf("mpris:trackid = %s, should be scoped to the instance", trackID)
+	}
+	bt.mustCall("SetPosition", trackID, int64(5*time.Second/time.Microsecond))
+	if pos := bt.expectSeeked(); pos != 5*time.Second {
+		t.Errorf("Seeked(%v), want 5s", pos)
+	}
+	bt.server.Inspect(func(st *mpdtest.State) {
+		if st.Elapsed != 5*time.Second {
+			t.Errorf("elapsed = %v, want 5s", st.Elapsed)
+		}
+	})
+}
+
+func TestBusProperties(t *testing.T) {
+	bt := newBusTest(t, threeSongs)
+
+	if err := bt.set("LoopStatus", "Track"); err != nil {
+		t.Fatal(err)
+	}
+	if v := bt.expectChanged()["LoopStatus"].Value(); v != "Track" {
+		t.Errorf("LoopStatus = %v, want Track", v)
+	}
+	bt.server.Inspect(func(st *mpdtest.State) {
+		if !st.Repeat || !st.Single {
+			t.Errorf("repeat %v single %v, want both", st.Repeat, st.Single)
+		}
+	})
+
+	if err := bt.set("Shuffle", true); err != nil {
+		t.Fatal(err)
+	}
+	if v := bt.expectChanged()["Shuffle"].Value(); v != true {
+		t.Errorf("Shuffle = %v, want true", v)
+	}
+
+	if err := bt.set("PlaybackStatus", "Playing"); err == nil {
+		t.Error("PlaybackStatus should be read-only")
+	}
+	if err := bt.set("Shuffle", "yes"); err == nil {
+		t.Error("Shuffle should only accept booleans")
+	}
+	bt.expectNone()
+
+	v, err := bt.obj.GetProperty("org.mpris.MediaPlayer2.Player.LoopStatus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value() != "Track" {
+		t.Errorf("LoopStatus = %v, want Track", v.Value())
+	}
+}
+
+func TestBusExternalChanges(t *testing.T) {
+	bt := newBusTest(t, threeSongs)
+
+	bt.server.Modify(func(st *mpdtest.State) { st.Volume = 20 }, "mixer")
+	if v := bt.expectChanged()["Volume"].Value(); v != 0.2 {
+		t.Errorf("Volume = %v, want 0.2", v)
+	}
+
+	bt.server.Modify(func(st *mpdtest.State) { st.Play(2) }, "player")
+	changed := bt.expectChanged()
+	if v := changed["PlaybackStatus"].Value(); v != "Playing" {
+		t.Errorf("PlaybackStatus = %v, want Playing", v)
+	}
+	if _, ok := changed["CanGoNext"]; ok {
+		t.Error("CanGoNext did not change")
+	}
+}
+
+func TestBusNoMixer(t *testing.T) {
+	bt := newBusTest(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Volume = -1
+	})
+	volumeAccess := func() string {
+		var data string
+		if err := bt.obj.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&data); err != nil {
+			t.Fatal(err)
+		}
+		var node introspect.Node
+		if err := xml.Unmarshal([]byte(data), &node); err != nil {
+			t.Fatal(err)
+		}
+		for _, iface := range node.Interfaces {
+			for _, p := range iface.Properties {
+				if iface.Name == "org.mpris.MediaPlayer2.Player" && p.Name == "Volume" {
+					return p.Access
+				}
+			}
+		}
+		t.Fatal("Volume is missing from the introspection data")
+		return ""
+	}
+
+	if access := volumeAccess(); access != "read" {
+		t.Errorf("Volume is %s without a mixer, want read", access)
+	}
+	err := bt.set("Volume", 0.5)
+	if dbusErr, ok := err.(dbus.Error); !ok || dbusErr.Name != prop.ErrReadOnly.Name {
+		t.Errorf("setting Volume without a mixer: %v", err)
+	}
+
+	bt.server.Modify(func(st *mpdtest.State) { st.Volume = 30 }, "mixer")
+	if v := bt.expectChanged()["Volume"].Value(); v != 0.3 {
+		t.Errorf("Volume = %v, want 0.3", v)
+	}
+	if access := volumeAccess(); access != "readwrite" {
+		t.Errorf("Volume is %s with a mixer, want readwrite", access)
+	}
+	if err := bt.set("Volume", 0.5); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBusInjectedConnection(t *testing.T) {
+	bt := newBusTest(t, nil)
+
+	var xml string
+	if err := bt.obj.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&xml); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(xml, `<interface name="org.mpris.MediaPlayer2.Player">`) {
+		t.Errorf("Player interface missing from the introspection data:\n%s", xml)
+	}
+
+	bt.stop()
+	if err := bt.ins.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// The connection is still usable, but the instance is gone from it.
+	var owned bool
+	if err := bt.conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, bt.ins.Name()).Store(&owned); err != nil {
+		t.Fatal(err)
+	}
+	if owned {
+		t.Errorf("%s is still owned after Close", bt.ins.Name())
+	}
+}
+
+func TestBusConformance(t *testing.T) {
+	for name, volume := range map[string]int{"mixer": 40, "no mixer": -1} {
+		t.Run(name, func(t *testing.T) {
+			bt := newBusTest(t, func(st *mpdtest.State) {
+				st.SetQueue(mpdtest.Song{
+					"file": "a.flac", "Title": "A", "Artist": "B", "Album": "C", "AlbumArtist": "D", "Genre": "E",
+					"Date": "2001-02", "Track": "3", "duration": "200.5",
+				})
+				st.Play(0)
+				st.Elapsed = 10 * time.Second
+				st.Volume = volume
+			})
+
+			report, err := verify.Run(bt.client, bt.ins.Name(), verify.Options{Timeout: time.Second})
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if report.Failed() {
+				var out strings.Builder
+				report.Print(&out)
+				t.Errorf("failed checks %v:\n%s", report.Failures(), out.String())
+			}
+		})
+	}
+}
+
+func TestBusCoalesceWindow(t *testing.T) {
+	bt := newBusTest(t, func(st *mpdtest.State) {
+		st.SetQueue(mpdtest.Song{"file": "http://radio.example/stream", "Title": "A"})
+	}, CoalesceWindow(500*time.Millisecond))
+	title := func(changed map[string]dbus.Variant) interface{} {
+		metadata, ok := changed["Metadata"]
+		if !ok {
+			return nil
+		}
+		return metadata.Value().(map[string]dbus.Variant)["xesam:title"].Value()
+	}
+
+	// One update is still one PropertiesChanged, with all of its changes.
+	bt.mustCall("Play")
+	changed := bt.expectChanged()
+	if v := changed["PlaybackStatus"].Value(); v != "Playing" || title(changed) != "A" {
+		t.Errorf("PlaybackStatus = %v and title %v, want Playing and A", v, title(changed))
+	}
+	bt.expectNone()
+
+	// A flapping title is merged into its last value. The titles are apart enough to be separate events.
+	retitle := func(titles ...string) {
+		for _, title := range titles {
+			bt.server.Modify(func(st *mpdtest.State) { st.Queue[st.Current].Song["Title"] = title }, "player")
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	retitle("One", "Two", "Final")
+	if v := title(bt.expectChanged()); v != "Final" {
+		t.Errorf("title %v, want Final", v)
+	}
+	bt.expectNone()
+	// Flapping back within the window changes nothing.
+	retitle("Glitch", "Final")
+	select {
+	case sig := <-bt.signals:
+		t.Errorf("unexpected signal %s %v", sig.Name, sig.Body)
+	case <-time.After(time.Second):
+	}
+}
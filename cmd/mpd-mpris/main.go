@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	mpris "github.com/natsukagami/mpd-mpris"
 	"github.com/natsukagami/mpd-mpris/mpd"
@@ -26,6 +28,29 @@ var (
 	noInstance bool
 	instance   string
 
+	coalesceWindow time.Duration
+
+	volumeCurve  string
+	volumeStep   float64
+	maxVolume    string
+	quietHours   string
+	rampOnPlay   time.Duration
+	fade         time.Duration
+	alarmFile    string
+	snapshotFile string
+
+	shuffleMode     string
+	nativePrevious  bool
+	previousRestart time.Duration
+
+	resumeLongerThan time.Duration
+	resumePaths      string
+	resumeGenres     string
+	resumeFile       string
+
+	recordProtocol string
+	replayProtocol string
+
 	isLocal = false
 )
 
@@ -37,6 +62,24 @@ func init() {
 	flag.StringVar(&passwordFile, "pwd-file", "", "Path to the file containing the mpd server password.")
 	flag.BoolVar(&noInstance, "no-instance", false, "Set the MPRIS's interface as 'org.mpris.MediaPlayer2.mpd' instead of 'org.mpris.MediaPlayer2.mpd.instance#'")
 	flag.StringVar(&instance, "instance-name", "", "Set the MPRIS's interface as 'org.mpris.MediaPlayer2.mpd.{instance-name}'")
+	flag.StringVar(&recordProtocol, "record-protocol", "", "Record everything sent to and received from MPD into this file, e.g. to attach it to a bug report. The password is not recorded.")
+	flag.StringVar(&replayProtocol, "replay-protocol", "", "Instead of connecting to MPD, replay a transcript recorded with -record-protocol.")
+	flag.DurationVar(&coalesceWindow, "coalesce", 0, "Merge property changes happening within this duration of each other (e.g. \"200ms\") into a single signal.")
+	flag.StringVar(&volumeCurve, "volume-curve", "linear", "How MPRIS volumes map to MPD's: \"linear\", \"cubic\" or \"db\" (linear in decibels, over 60dB). Use cubic or db if most of the volume slider sounds too loud.")
+	flag.Float64Var(&volumeStep, "volume-step", 0.05, "The size of a volume step taken with the StepVolume method, from 0 to 1.")
+	flag.StringVar(&maxVolume, "max-volume", "", "The highest volume, from 0 to 1 or as a percentage (e.g. \"80%\"). Higher volumes, including those set by other MPD clients, are lowered to it.")
+	flag.StringVar(&quietHours, "quiet-hours", "", "Comma-separated times of the day with a lower maximum volume, e.g. \"22:00-07:00=30%\".")
+	flag.DurationVar(&rampOnPlay, "ramp-on-play", 0, "Raise the volume from 0 over this duration (e.g. \"3s\") when playback starts or resumes.")
+	flag.DurationVar(&fade, "fade", 0, "Fade the volume out over this duration (e.g. \"500ms\") before pausing or stopping, and fade it in when resuming.")
+	flag.StringVar(&alarmFile, "alarms", "", "A JSON file of alarms, which also keeps the alarms added over D-Bus. See the README for its format.")
+	flag.StringVar(&shuffleMode, "shuffle", "random", "What Shuffle does: \"random\" turns MPD's random mode on, and \"queue\" shuffles the queue itself, so that clients show what comes next, and puts it back in order afterwards.")
+	flag.BoolVar(&nativePrevious, "native-previous", false, "Make Previous go to the song before the current one in the queue, as MPD does, even while the queue is shuffled, rather than to the song played before it. In random mode, MPD's previous song is any song.")
+	flag.DurationVar(&previousRestart, "previous-restart", 3*time.Second, "Make Previous restart the current song once it has played for longer than this duration, and at the start of the queue. 0 disables this.")
+	flag.StringVar(&snapshotFile, "snapshots", "", "A JSON file keeping the queue snapshots, which are lost when mpd-mpris stops otherwise.")
+	flag.DurationVar(&resumeLongerThan, "resume-longer-than", 0, "Remember the position of tracks longer than this duration (e.g. \"20m\"), and resume them from it.")
+	flag.StringVar(&resumePaths, "resume-paths", "", "Comma-separated directories of MPD's music directory (e.g. \"Audiobooks,Podcasts\") whose tracks remember their position.")
+	flag.StringVar(&resumeGenres, "resume-genres", "", "Comma-separated genres (e.g. \"Audiobook,Podcast\") whose tracks remember their position.")
+	flag.StringVar(&resumeFile, "resume-file", "", "Keep remembered positions in this JSON file, instead of MPD's stickers.")
 }
 
 func detectLocalSocket() {
@@ -77,7 +120,76 @@ func getPassword() string {
 	return ""
 }
 
+// volumePolicy returns the volume policy set by the flags.
+func volumePolicy() (policy mpris.VolumePolicy, err error) {
+	if maxVolume != "" {
+		if policy.MaxVolume, err = mpris.ParseVolume(maxVolume); err != nil {
+			return policy, err
+		}
+	}
+	if quietHours != "" {
+		for _, s := range strings.Split(quietHours, ",") {
+			q, err := mpris.ParseQuietHours(s)
+			if err != nil {
+				return policy, err
+			}
+			policy.QuietHours = append(policy.QuietHours, q)
+		}
+	}
+	policy.RampOnPlay = rampOnPlay
+	return policy, nil
+}
+
+// resumePolicy returns the policy of the tracks remembering their position, from the flags.
+func resumePolicy() mpris.ResumePolicy {
+	policy := mpris.ResumePolicy{MinLength: resumeLongerThan, StateFile: resumeFile}
+	if resumePaths != "" {
+		policy.Paths = strings.Split(resumePaths, ",")
+	}
+	if resumeGenres != "" {
+		policy.Genres = strings.Split(resumeGenres, ",")
+	}
+	return policy
+}
+
+// dialRecorded connects to MPD, recording the connection's transcript into the file at path.
+func dialRecorded(network, addr, password, path string) (*mpd.Client, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	log.Printf("Recording the MPD protocol into %s\n", path)
+	// The file stays open for as long as the connection.
+	return mpd.NewClient(mpd.NewRecorder(f).Wrap(conn), addr, password)
+}
+
+// startReplay starts a fake MPD server replaying the transcript at path.
+func startReplay(path string) (*mpd.ReplayServer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	log.Printf("Replaying the MPD protocol transcript %s\n", path)
+	return mpd.NewReplayServer(f)
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			os.Exit(runVerify(os.Args[2:]))
+		case "sleep":
+			os.Exit(runSleep(os.Args[2:]))
+		case "snapshot":
+			os.Exit(runSnapshot(os.Args[2:]))
+		}
+	}
 	flag.Parse()
 	password := getPassword()
 	if len(addr) == 0 {
@@ -126,7 +238,18 @@ func main() {
 		fullAddress = addr
 	}
 
-	if password == "" {
+	if replayProtocol != "" {
+		server, err := startReplay(replayProtocol)
+		if err != nil {
+			log.Fatalf("Cannot replay the transcript: %+v", err)
+		}
+		defer server.Close()
+		network, fullAddress = server.Network(), server.Addr()
+	}
+
+	if recordProtocol != "" {
+		c, err = dialRecorded(network, fullAddress, password, recordProtocol)
+	} else if password == "" {
 		c, err = mpd.Dial(network, fullAddress)
 	} else {
 		c, err = mpd.DialAuthenticated(network, fullAddress, password)
@@ -136,12 +259,40 @@ func main() {
 		log.Fatalf("Cannot connect to mpd: %+v", err)
 	}
 
+	curve, err := mpris.ParseVolumeCurve(volumeCurve)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if volumeStep <= 0 || volumeStep > 1 {
+		log.Fatalln("-volume-step should be between 0 and 1")
+	}
+	shuffle, err := mpris.ParseShuffleMode(shuffleMode)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	policy, err := volumePolicy()
+	if err != nil {
+		log.Fatalln(err)
+	}
 	opts := []mpris.Option{
 		mpris.IsLocal(isLocal),
+		mpris.CoalesceWindow(coalesceWindow),
+		mpris.UseVolumeCurve(curve),
+		mpris.VolumeStep(volumeStep),
+		mpris.LimitVolume(policy),
+		mpris.Fade(fade),
+		mpris.AlarmFile(alarmFile),
+		mpris.SnapshotFile(snapshotFile),
+		mpris.SmartPrevious(previousRestart),
+		mpris.UseShuffleMode(shuffle),
+		mpris.ResumePositions(resumePolicy()),
 	}
 	if noInstance && instance != "" {
 		log.Fatalln("-no-instance cannot be used with -instance-name")
 	}
+	if nativePrevious {
+		opts = append(opts, mpris.NativePrevious())
+	}
 	if noInstance {
 		opts = append(opts, mpris.NoInstance())
 	}
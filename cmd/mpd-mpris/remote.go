@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/pkg/errors"
+)
+
+// The bus names of mpd-mpris start with this prefix, followed by the instance, if any.
+const busNamePrefix = "org.mpris.MediaPlayer2.mpd"
+
+// findInstance returns the bus name of a running mpd-mpris: the given one, which may leave out
+// "org.mpris.MediaPlayer2.", or the first one found.
+func findInstance(conn *dbus.Conn, name string) (string, error) {
+	if name != "" && !strings.HasPrefix(name, "org.") {
+		name = "org.mpris.MediaPlayer2." + name
+	}
+	var names []string
+	if err := conn.BusObject().Call("org.freedesktop.DBus.ListNames", 0).Store(&names); err != nil {
+		return "", errors.WithStack(err)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if (name == "" && (n == busNamePrefix || strings.HasPrefix(n, busNamePrefix+"."))) || n == name {
+			return n, nil
+		}
+	}
+	if name == "" {
+		return "", errors.New("mpd-mpris is not running")
+	}
+	return "", errors.Errorf("%s is not on the bus", name)
+}
+
+// extension returns the object of the running mpd-mpris with the given bus name,
+// on which `org.mpd.MediaPlayer2.Player` methods are called.
+func extension(name string) (dbus.BusObject, func(), error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot connect to the session bus")
+	}
+	if name, err = findInstance(conn, name); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn.Object(name, "/org/mpris/MediaPlayer2"), func() { conn.Close() }, nil
+}
+
+// parseArgs parses args with flags, which may come before, after or between the positional arguments,
+// e.g. `sleep 30m -fade 5m`. The positional arguments are left in flags.Args(), and "--" ends the flags.
+func parseArgs(flags *flag.FlagSet, args []string) {
+	var positional []string
+	for len(args) > 0 {
+		flags.Parse(args)
+		rest := flags.Args()
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		if len(rest) == 0 {
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	flags.Parse(append([]string{"--"}, positional...))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	mpris "github.com/natsukagami/mpd-mpris"
+)
+
+// runSleep implements `mpd-mpris sleep`, which sets the sleep timer of a running mpd-mpris, and returns the exit code.
+func runSleep(args []string) int {
+	flags := flag.NewFlagSet("sleep", flag.ExitOnError)
+	tracks := flags.Int("tracks", -1, "Stop after this number of tracks after the current one (0 to stop at the end of the current track), rather than after a duration.")
+	fade := flags.Duration("fade", 0, "Fade the volume out over the last part of the timer, e.g. \"2m\".")
+	cancel := flags.Bool("cancel", false, "Cancel the sleep timer.")
+	name := flags.String("instance", "", "The bus name of mpd-mpris, or the part after \"org.mpris.MediaPlayer2.\" (default: the first one found).")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s sleep [flags] [duration]\n\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Stops playback after the duration (e.g. \"30m\"), or after some tracks with -tracks.")
+		fmt.Fprintln(flags.Output(), "Without a duration or -tracks, prints the time left.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	parseArgs(flags, args)
+	if flags.NArg() > 1 || (flags.NArg() == 1 && (*tracks >= 0 || *cancel)) {
+		flags.Usage()
+		return 2
+	}
+
+	obj, closeBus, err := extension(*name)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	defer closeBus()
+	const iface = "org.mpd.MediaPlayer2.Player"
+	var call *dbus.Call
+	switch {
+	case *cancel:
+		call = obj.Call(iface+".CancelSleepTimer", 0)
+	case *tracks >= 0:
+		call = obj.Call(iface+".SleepAfterTracks", 0, uint32(*tracks), mpris.UsFromDuration(*fade))
+	case flags.NArg() == 1:
+		after, err := time.ParseDuration(flags.Arg(0))
+		if err != nil {
+			log.Fatalf("Invalid duration: %v", err)
+		}
+		call = obj.Call(iface+".SleepAfter", 0, mpris.UsFromDuration(after), mpris.UsFromDuration(*fade))
+	default:
+		v, err := obj.GetProperty(iface + ".SleepTimerRemaining")
+		if err != nil {
+			log.Fatalf("Cannot get the sleep timer: %v", err)
+		}
+		if left, ok := v.Value().(int64); !ok || left < 0 {
+			fmt.Println("No sleep timer")
+		} else {
+			fmt.Println(mpris.TimeInUs(left).Duration())
+		}
+		return 0
+	}
+	if call.Err != nil {
+		log.Fatalf("Cannot set the sleep timer: %v", call.Err)
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	mpris "github.com/natsukagami/mpd-mpris"
+)
+
+// runSnapshot implements `mpd-mpris snapshot`, which saves and restores queue snapshots of a running mpd-mpris,
+// and returns the exit code.
+func runSnapshot(args []string) int {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	name := flags.String("instance", "", "The bus name of mpd-mpris, or the part after \"org.mpris.MediaPlayer2.\" (default: the first one found).")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s snapshot [flags] [list | save NAME | restore NAME | rm NAME]\n\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Saves the queue, with the current track, its position and the playback options, to restore them later.")
+		fmt.Fprintln(flags.Output(), "Without a command, lists the snapshots.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	parseArgs(flags, args)
+	command := "list"
+	if flags.NArg() > 0 {
+		command = flags.Arg(0)
+	}
+	methods := map[string]string{"save": "SaveSnapshot", "restore": "RestoreSnapshot", "rm": "RemoveSnapshot"}
+	method, ok := methods[command]
+	if (command == "list" && flags.NArg() > 1) || (command != "list" && (!ok || flags.NArg() != 2)) {
+		flags.Usage()
+		return 2
+	}
+
+	obj, closeBus, err := extension(*name)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	defer closeBus()
+	const iface = "org.mpd.MediaPlayer2.Player"
+	if command != "list" {
+		if err := obj.Call(iface+"."+method, 0, flags.Arg(1)).Err; err != nil {
+			log.Fatalf("Cannot %s the snapshot: %v", command, err)
+		}
+		return 0
+	}
+
+	var snapshots map[string]map[string]dbus.Variant
+	if err := obj.Call(iface+".ListSnapshots", 0).Store(&snapshots); err != nil {
+		log.Fatalf("Cannot list the snapshots: %v", err)
+	}
+	names := make([]string, 0, len(snapshots))
+	for n := range snapshots {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, n := range names {
+		s := snapshots[n]
+		taken, _ := s["taken"].Value().(int64)
+		length, _ := s["length"].Value().(uint32)
+		current, _ := s["current"].Value().(int32)
+		elapsed, _ := s["elapsed"].Value().(int64)
+		state, _ := s["state"].Value().(string)
+		position := "-"
+		if current >= 0 {
+			position = fmt.Sprintf("#%d at %v (%s)", current+1, mpris.TimeInUs(elapsed).Duration().Round(time.Second), state)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d songs\t%s\n", n, time.Unix(taken, 0).Format("2006-01-02 15:04"), length, position)
+	}
+	w.Flush()
+	return 0
+}
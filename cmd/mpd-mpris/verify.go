@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/verify"
+)
+
+// runVerify implements `mpd-mpris verify [busname]`, and returns the exit code.
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	readOnly := flags.Bool("read-only", false, "Do not run the checks that change the player's state (seeking the current track).")
+	timeout := flags.Duration("timeout", 2*time.Second, "How long to wait for signals.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s verify [flags] [busname]\n\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Checks that an MPRIS player on the session bus follows the MPRIS specification.")
+		fmt.Fprintln(flags.Output(), "busname is the player's bus name, or the part after \"org.mpris.MediaPlayer2.\" (default: the first player found).")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		log.Fatalf("Cannot connect to the session bus: %+v", err)
+	}
+	defer conn.Close()
+	name, err := verify.FindPlayer(conn, flags.Arg(0))
+	if err != nil {
+		log.Fatalf("Cannot find the player: %+v", err)
+	}
+	report, err := verify.Run(conn, name, verify.Options{ReadOnly: *readOnly, Timeout: *timeout})
+	if err != nil {
+		log.Fatalf("Cannot check %s: %+v", name, err)
+	}
+	report.Print(os.Stdout)
+	if report.Failed() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,182 @@
+package mpris
+
+import (
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+	"github.com/pkg/errors"
+)
+
+// A batch of property changes and signals that belong together, e.g. the results of one update.
+// They are emitted together, properties first.
+type batch struct {
+	props  []propChange
+	seeked *TimeInUs
+}
+
+type propChange struct {
+	iface, name string
+	value       interface{}
+}
+
+// set records a property change, replacing any earlier change of the same property.
+func (b *batch) set(iface, name string, value interface{}) {
+	for i := range b.props {
+		if b.props[i].iface == iface && b.props[i].name == name {
+			b.props[i].value = value
+			return
+		}
+	}
+	b.props = append(b.props, propChange{iface: iface, name: name, value: value})
+}
+
+// seek records a Seeked signal.
+func (b *batch) seek(x TimeInUs) {
+	b.seeked = &x
+}
+
+// merge adds all changes of other to b, with other's changes taking precedence.
+func (b *batch) merge(other *batch) {
+	for _, c := range other.props {
+		b.set(c.iface, c.name, c.value)
+	}
+	if other.seeked != nil {
+		b.seeked = other.seeked
+	}
+}
+
+func (b *batch) empty() bool {
+	return len(b.props) == 0 && b.seeked == nil
+}
+
+// The emitter is the single goroutine that sends the signals of an Instance, in order.
+// Batches that arrive within the coalescing window of each other are merged into one.
+type emitter struct {
+	conn   *dbus.Conn
+	props  *Properties
+	window time.Duration
+
+	batches chan *batch
+	quit    chan struct{}
+	done    chan struct{}
+
+	// The last emitted value of each property, so that we don't repeat ourselves.
+	last map[propKey]interface{}
+}
+
+type propKey struct{ iface, name string }
+
+func newEmitter(conn *dbus.Conn, window time.Duration) *emitter {
+	return &emitter{
+		conn:    conn,
+		window:  window,
+		batches: make(chan *batch, 64),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+		last:    make(map[propKey]interface{}),
+	}
+}
+
+// push queues a batch for emission.
+func (e *emitter) push(b *batch) {
+	select {
+	case e.batches <- b:
+	case <-e.done:
+	}
+}
+
+// run emits batches until stop is called.
+func (e *emitter) run() {
+	defer close(e.done)
+	for {
+		var b *batch
+		select {
+		case b = <-e.batches:
+		case <-e.quit:
+			return
+		}
+		if e.window > 0 {
+			// Wait for more changes to come, then merge them.
+			timeout := time.After(e.window)
+		collect:
+			for {
+				select {
+				case other := <-e.batches:
+					b.merge(other)
+				case <-timeout:
+					break collect
+				case <-e.quit:
+					e.emit(b)
+					return
+				}
+			}
+		} else {
+			// Only merge whatever is already queued.
+		drain:
+			for {
+				select {
+				case other := <-e.batches:
+					b.merge(other)
+				default:
+					break drain
+				}
+			}
+		}
+		e.emit(b)
+	}
+}
+
+// stop emits whatever is left and stops the emitter.
+func (e *emitter) stop() {
+	select {
+	case <-e.quit:
+	default:
+		close(e.quit)
+	}
+	<-e.done
+}
+
+// emit sends one `PropertiesChanged` per interface, followed by the signals in b.
+func (e *emitter) emit(b *batch) {
+	var ifaces []string
+	changed := make(map[string]map[string]dbus.Variant)
+	invalidated := make(map[string][]string)
+	for _, c := range b.props {
+		key := propKey{c.iface, c.name}
+		if last, ok := e.last[key]; ok && reflect.DeepEqual(last, c.value) {
+			continue
+		}
+		e.last[key] = c.value
+
+		var emitType prop.EmitType
+		if e.props != nil {
+			emitType = e.props.emits(c.iface, c.name)
+		}
+		if emitType != prop.EmitTrue && emitType != prop.EmitInvalidates {
+			continue
+		}
+		if _, ok := changed[c.iface]; !ok {
+			ifaces = append(ifaces, c.iface)
+			changed[c.iface] = make(map[string]dbus.Variant)
+			invalidated[c.iface] = []string{}
+		}
+		if emitType == prop.EmitTrue {
+			changed[c.iface][c.name] = dbus.MakeVariant(c.value)
+		} else {
+			invalidated[c.iface] = append(invalidated[c.iface], c.name)
+		}
+	}
+	for _, iface := range ifaces {
+		if err := e.conn.Emit("/org/mpris/MediaPlayer2", "org.freedesktop.DBus.Properties.PropertiesChanged", iface, changed[iface], invalidated[iface]); err != nil {
+			log.Printf("Emitting changes of %s failed: %+v\n", iface, errors.WithStack(err))
+		}
+	}
+	if b.seeked != nil {
+		if err := e.conn.Emit("/org/mpris/MediaPlayer2", "org.mpris.MediaPlayer2.Player.Seeked", *b.seeked); err != nil {
+			log.Printf("Emitting Seeked failed: %+v\n", errors.WithStack(err))
+		}
+	}
+}
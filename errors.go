@@ -1,12 +1,24 @@
 package mpris
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
 	"github.com/pkg/errors"
 )
 
+// errNoVolume is returned when setting the volume while MPD has no mixer.
+// It has the same name as the error for other read-only properties.
+var errNoVolume = dbus.NewError(prop.ErrReadOnly.Name,
+	[]interface{}{"the volume is not available: MPD has no mixer"})
+
+// invalidArgs returns an error for a method called with invalid arguments.
+func invalidArgs(format string, args ...interface{}) *dbus.Error {
+	return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{fmt.Sprintf(format, args...)})
+}
+
 // Transform any error into a *dbus.Error.
 func (ins *Instance) transformErr(err error) *dbus.Error {
 	if err == nil {
@@ -22,3 +34,11 @@ func (ins *Instance) transformErr(err error) *dbus.Error {
 	}
 	return &dbusErr
 }
+
+// asError turns a possibly nil *dbus.Error into an error, keeping nil as nil.
+func asError(err *dbus.Error) error {
+	if err == nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,246 @@
+package mpris
+
+import (
+	"log"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+// This file implements a struct that satisfies the `org.mpd.MediaPlayer2.Player` interface,
+// which extends `org.mpris.MediaPlayer2.Player` with what MPRIS has no room for.
+
+// PlayerExtension is a DBus object satisfying the `org.mpd.MediaPlayer2.Player` interface.
+type PlayerExtension struct {
+	*Instance
+}
+
+// StepVolume changes the volume by the given number of steps (negative to lower it), and returns the new volume.
+// Unlike setting Volume from its last known value, steps taken by concurrent clients all count.
+func (e *PlayerExtension) StepVolume(steps int32) (float64, *dbus.Error) {
+	log.Printf("StepVolume(%d) requested\n", steps)
+	return e.player.stepVolume(float64(steps) * e.volumeStep)
+}
+
+// properties returns the properties of the `org.mpd.MediaPlayer2.Player` interface.
+func (e *PlayerExtension) properties() map[string]*prop.Prop {
+	return map[string]*prop.Prop{
+		// The time left before the sleep timer stops playback, updated every second, or -1 without a sleep timer.
+		"SleepTimerRemaining": newProp(TimeInUs(-1), nil),
+		// The points of the A–B loop in the current track, or -1 without a loop.
+		"LoopPointA": newProp(TimeInUs(-1), nil),
+		"LoopPointB": newProp(TimeInUs(-1), nil),
+		// Whether album shuffle is on.
+		"AlbumShuffle": newProp(false, nil),
+	}
+}
+
+// SleepAfter stops playback after the given time.
+// The volume fades out over the last part of it, if fade is not zero, and is restored once playback has stopped.
+// It replaces the running sleep timer, if any.
+func (e *PlayerExtension) SleepAfter(after, fade TimeInUs) *dbus.Error {
+	log.Printf("SleepAfter(%v, %v) requested\n", after.Duration(), fade.Duration())
+	if after <= 0 || fade < 0 {
+		return invalidArgs("the time before sleeping should be positive, and the fade not negative")
+	}
+	return e.player.setSleepTimer(&sleepTimer{deadline: time.Now().Add(after.Duration()), fade: fade.Duration()})
+}
+
+// SleepAfterTracks stops playback at the end of the current track, or after the given number of tracks after it.
+// The volume fades out over the last part of the last track, as with SleepAfter.
+func (e *PlayerExtension) SleepAfterTracks(tracks uint32, fade TimeInUs) *dbus.Error {
+	log.Printf("SleepAfterTracks(%d, %v) requested\n", tracks, fade.Duration())
+	if fade < 0 {
+		return invalidArgs("the fade should not be negative")
+	}
+	return e.player.setSleepTimer(&sleepTimer{tracks: int(tracks), fade: fade.Duration()})
+}
+
+// CancelSleepTimer cancels the sleep timer, if any. If the volume is fading out, it is restored.
+func (e *PlayerExtension) CancelSleepTimer() *dbus.Error {
+	log.Printf("CancelSleepTimer requested\n")
+	s := &e.player.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer e.props.apply(b)
+	e.player.cancelSleepTimer(b)
+	return nil
+}
+
+// SetLoopPoints loops the current track from a to b: playback seeks back to a whenever it reaches b.
+// Playback starts from a, unless it is already between a and b. The loop is cleared when the track changes.
+func (e *PlayerExtension) SetLoopPoints(a, b TimeInUs) *dbus.Error {
+	log.Printf("SetLoopPoints(%v, %v) requested\n", a.Duration(), b.Duration())
+	if a < 0 || b <= a {
+		return invalidArgs("the loop should start at a positive time, before it ends")
+	}
+	return e.player.setLoop(a.Duration(), b.Duration())
+}
+
+// ClearLoopPoints clears the A–B loop, if any, and lets playback go on.
+func (e *PlayerExtension) ClearLoopPoints() *dbus.Error {
+	log.Printf("ClearLoopPoints requested\n")
+	s := &e.player.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer e.props.apply(b)
+	e.player.endLoop(b)
+	return nil
+}
+
+// AddAlarm adds an alarm, replacing the alarm with the same name, if any.
+// when is a schedule, like "mon-fri 07:30" (see the README), and options may hold "playlist" or "uri" (strings),
+// "volume" and "start_volume" (doubles from 0 to 1), and "ramp" and "snooze" (int64 microseconds).
+func (e *PlayerExtension) AddAlarm(name, when string, options map[string]dbus.Variant) *dbus.Error {
+	log.Printf("AddAlarm(%q, %q) requested\n", name, when)
+	a, err := alarmFromDBus(name, when, options, time.Now())
+	if err != nil {
+		return invalidArgs("%v", err)
+	}
+	if err := e.alarms.add(a, time.Now()); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// RemoveAlarm removes the alarm with the given name.
+func (e *PlayerExtension) RemoveAlarm(name string) *dbus.Error {
+	log.Printf("RemoveAlarm(%q) requested\n", name)
+	if err := e.alarms.remove(name); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// ListAlarms returns the alarms by name, with their options as given to AddAlarm,
+// their schedule as "when", and the next time they fire as "next" (int64 seconds since the Unix epoch).
+func (e *PlayerExtension) ListAlarms() (map[string]map[string]dbus.Variant, *dbus.Error) {
+	alarms := make(map[string]map[string]dbus.Variant)
+	for _, a := range e.alarms.list() {
+		options := a.dbusOptions()
+		options["when"] = dbus.MakeVariant(a.Schedule.String())
+		options["next"] = dbus.MakeVariant(a.next.Unix())
+		alarms[a.Name] = options
+	}
+	return alarms, nil
+}
+
+// Snooze pauses the ringing alarm, which rings again after its snooze time.
+func (e *PlayerExtension) Snooze() *dbus.Error {
+	log.Printf("Snooze requested\n")
+	a, err := e.player.snoozeAlarm()
+	if err != nil {
+		return err
+	}
+	e.alarms.snooze(*a, time.Now())
+	return nil
+}
+
+// ClearResumePosition forgets the position remembered by the track at uri, or by the current track if uri is empty,
+// which then starts from the beginning the next time it plays.
+func (e *PlayerExtension) ClearResumePosition(uri string) *dbus.Error {
+	log.Printf("ClearResumePosition(%q) requested\n", uri)
+	if err := e.player.clearResume(uri); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// AddBookmark bookmarks the current position of the current track under the given name, and returns the bookmark's ID.
+func (e *PlayerExtension) AddBookmark(name string) (string, *dbus.Error) {
+	log.Printf("AddBookmark(%q) requested\n", name)
+	return e.player.addBookmark(name)
+}
+
+// ListBookmarks returns the bookmarks of the track at the given URI, or of the current track if it is empty, by position.
+func (e *PlayerExtension) ListBookmarks(track string) ([]Bookmark, *dbus.Error) {
+	if track == "" {
+		s := &e.player.status
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.bookmarks, nil
+	}
+	stored, err := e.player.readBookmarks(track)
+	if err != nil {
+		return nil, dbus.MakeFailedError(err)
+	}
+	return exportBookmarks(track, stored), nil
+}
+
+// GoToBookmark plays the track of the bookmark with the given ID, from the bookmark.
+// The track is added after the current one if it is not in the queue.
+func (e *PlayerExtension) GoToBookmark(id string) *dbus.Error {
+	log.Printf("GoToBookmark(%q) requested\n", id)
+	return e.player.goToBookmark(id)
+}
+
+// RemoveBookmark removes the bookmark with the given ID.
+func (e *PlayerExtension) RemoveBookmark(id string) *dbus.Error {
+	log.Printf("RemoveBookmark(%q) requested\n", id)
+	return e.player.removeBookmark(id)
+}
+
+// SaveSnapshot saves the queue, the current track and its position, and the playback options under the given name,
+// replacing the snapshot with the same name, if any.
+func (e *PlayerExtension) SaveSnapshot(name string) *dbus.Error {
+	log.Printf("SaveSnapshot(%q) requested\n", name)
+	if name == "" {
+		return invalidArgs("snapshots need a name")
+	}
+	snap, err := e.player.takeSnapshot(name)
+	if err != nil {
+		return err
+	}
+	if err := e.snapshots.add(snap); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// RestoreSnapshot replaces the queue, the current track and its position, and the playback options with the snapshot's.
+func (e *PlayerExtension) RestoreSnapshot(name string) *dbus.Error {
+	log.Printf("RestoreSnapshot(%q) requested\n", name)
+	snap, err := e.snapshots.get(name)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return e.player.restoreSnapshot(snap)
+}
+
+// RemoveSnapshot removes the snapshot with the given name.
+func (e *PlayerExtension) RemoveSnapshot(name string) *dbus.Error {
+	log.Printf("RemoveSnapshot(%q) requested\n", name)
+	if err := e.snapshots.remove(name); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// ListSnapshots returns the snapshots by name, with when they were taken as "taken" (int64 seconds since the Unix epoch),
+// their number of songs as "length", the position of the current song as "current" (-1 without one),
+// its position as "elapsed" (int64 microseconds) and the playback state as "state".
+func (e *PlayerExtension) ListSnapshots() (map[string]map[string]dbus.Variant, *dbus.Error) {
+	snapshots := make(map[string]map[string]dbus.Variant)
+	for _, s := range e.snapshots.list() {
+		snapshots[s.Name] = s.dbusInfo()
+	}
+	return snapshots, nil
+}
+
+// StartAlbumShuffle replaces the queue with albums in a random order, each one in disc and track order,
+// and plays them. Source is "queue" for the albums of the queue, or "library" for those of the whole library,
+// or for the songs matching filter, given as arguments of MPD's find command (e.g. ["genre", "Jazz"]).
+// Albums are queued one at a time, and are shuffled again when they have all played if LoopStatus is "Playlist".
+func (e *PlayerExtension) StartAlbumShuffle(source string, filter []string) *dbus.Error {
+	log.Printf("StartAlbumShuffle(%q, %q) requested\n", source, filter)
+	return e.player.startAlbumShuffle(source, filter)
+}
+
+// StopAlbumShuffle stops queueing albums, and leaves the queue as it is. Turning Shuffle off does the same.
+func (e *PlayerExtension) StopAlbumShuffle() *dbus.Error {
+	log.Printf("StopAlbumShuffle requested\n")
+	return e.player.stopAlbumShuffle()
+}
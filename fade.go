@@ -0,0 +1,71 @@
+package mpris
+
+import (
+	"log"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd"
+)
+
+// This file implements fades: the volume fades out before pausing or stopping, and fades in when playback resumes.
+// The Volume property keeps the user's volume during fades, and it is the volume restored afterwards.
+
+// canFade returns whether a fade can run. It assumes that the status lock is held.
+func (p *Player) canFade() bool {
+	return p.fade > 0 && p.status.HasVolume
+}
+
+// fadeOut fades the volume out, then runs the commands queued by cmds (pausing or stopping playback)
+// and restores the volume. It assumes that the status lock is held, and returns once the fade has started.
+// If the volume is already fading out, the fade goes on, but runs cmds at the end instead.
+func (p *Player) fadeOut(b *batch, cmds func(cl *mpd.CommandList)) *dbus.Error {
+	s := &p.status
+	if s.fadingOut() {
+		s.ramp.then = p.afterFadeOut(cmds)
+		return nil
+	}
+	if !p.canFade() || s.PlaybackStatus != PlaybackStatusPlaying {
+		return s.updateWith(p, b, cmds)
+	}
+	from := s.mpdVolume
+	if s.ramp != nil {
+		from = s.ramp.current
+	}
+	p.rampVolume(from, 0, p.fade, p.afterFadeOut(cmds))
+	return nil
+}
+
+// afterFadeOut returns the end of a fade-out, which runs cmds and restores the volume.
+func (p *Player) afterFadeOut(cmds func(cl *mpd.CommandList)) func() {
+	return func() {
+		s := &p.status
+		b := &batch{}
+		defer p.Instance.props.apply(b)
+		// The user's volume, which may have changed during the fade.
+		restore := s.mpdVolume
+		if err := s.updateWith(p, b, func(cl *mpd.CommandList) {
+			cmds(cl)
+			cl.SetVolume(restore)
+		}); err != nil {
+			log.Printf("Cannot finish fading out: %v\n", err)
+		}
+	}
+}
+
+// fadeIn runs the commands queued by cmds (starting or resuming playback) while the volume fades in from zero,
+// or from where a fade-out was. It assumes that the status lock is held.
+func (p *Player) fadeIn(b *batch, cmds func(cl *mpd.CommandList)) *dbus.Error {
+	s := &p.status
+	if !p.canFade() || (s.PlaybackStatus == PlaybackStatusPlaying && !s.fadingOut()) {
+		return s.updateWith(p, b, cmds)
+	}
+	from, to := 0, s.mpdVolume
+	if s.ramp != nil {
+		from = s.ramp.current
+	}
+	if limit := p.maxMPDVolume(); to > limit {
+		to = limit
+	}
+	p.rampVolume(from, to, p.fade, nil)
+	return s.updateWith(p, b, cmds)
+}
@@ -0,0 +1,132 @@
+package mpris
+
+import (
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+// newFadingPlayer is a test player fading over 200ms, playing at volume 60.
+func newFadingPlayer(t *testing.T) (*Player, *mpdtest.Server) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Play(0)
+		st.Volume = 60
+	}, Fade(200*time.Millisecond))
+	drain(p)
+	return p, s
+}
+
+// waitForPlayback waits until MPD's playback state is playback, then checks its volume.
+func waitForPlayback(t *testing.T, s *mpdtest.Server, playback string, volume int) {
+	t.Helper()
+	var got string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, got, _ = current(s); got == playback {
+			waitForVolume(t, s, volume)
+			return
+		}
+	}
+	t.Fatalf("playback = %s, want %s", got, playback)
+}
+
+func TestPlayerFadeOut(t *testing.T) {
+	p, s := newFadingPlayer(t)
+
+	mustOK(t, p.Pause())
+	if _, playback, _ := current(s); playback != "play" {
+		t.Errorf("playback = %s, should go on during the fade", playback)
+	}
+	time.Sleep(100 * time.Millisecond)
+	s.Inspect(func(st *mpdtest.State) {
+		if st.Volume >= 60 || st.Volume == 0 {
+			t.Errorf("volume = %d half-way through the fade", st.Volume)
+		}
+	})
+	waitForPlayback(t, s, "pause", 60)
+	b := drain(p)
+	if v, _ := b.changed("PlaybackStatus"); v != PlaybackStatusPaused {
+		t.Errorf("PlaybackStatus changed to %v, want Paused", v)
+	}
+	if v, ok := b.changed("Volume"); ok {
+		t.Errorf("Volume changed to %v during the fade", v)
+	}
+
+	mustOK(t, p.Play())
+	s.Inspect(func(st *mpdtest.State) {
+		if st.Volume != 0 {
+			t.Errorf("volume = %d when resuming, want 0", st.Volume)
+		}
+	})
+	waitForPlayback(t, s, "play", 60)
+
+	mustOK(t, p.Stop())
+	waitForPlayback(t, s, "stop", 60)
+}
+
+func TestPlayerFadeCancel(t *testing.T) {
+	p, s := newFadingPlayer(t)
+
+	// Another command cancels the fade, at the right volume.
+	mustOK(t, p.Pause())
+	time.Sleep(100 * time.Millisecond)
+	mustOK(t, p.Next())
+	time.Sleep(300 * time.Millisecond)
+	if pos, playback, _ := current(s); pos != 1 || playback != "play" {
+		t.Errorf("pos %d, %s, want pos 1 playing", pos, playback)
+	}
+	waitForVolume(t, s, 60)
+
+	// Toggling again during the fade resumes.
+	mustOK(t, p.PlayPause())
+	time.Sleep(100 * time.Millisecond)
+	mustOK(t, p.PlayPause())
+	time.Sleep(300 * time.Millisecond)
+	waitForPlayback(t, s, "play", 60)
+
+	// Stopping during a fade to pause stops.
+	mustOK(t, p.Pause())
+	mustOK(t, p.Stop())
+	waitForPlayback(t, s, "stop", 60)
+}
+
+func TestPlayerFadeVolumeChange(t *testing.T) {
+	p, s := newFadingPlayer(t)
+
+	mustOK(t, p.Pause())
+	time.Sleep(100 * time.Millisecond)
+	mustOK(t, p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "Volume", dbus.MakeVariant(0.3)))
+	if _, playback, _ := current(s); playback != "play" {
+		t.Errorf("playback = %s, should go on during the fade", playback)
+	}
+	// The new volume is restored.
+	waitForPlayback(t, s, "pause", 30)
+	if v := getProp(t, p, "Volume"); v != 0.3 {
+		t.Errorf("Volume = %v, want 0.3", v)
+	}
+
+	// So is a volume set by another client.
+	mustOK(t, p.Play())
+	waitForVolume(t, s, 30)
+	mustOK(t, p.Pause())
+	s.Modify(func(st *mpdtest.State) { st.Volume = 50 }, "mixer")
+	for _, h := range p.handlers["mixer"] {
+		if err := h(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	waitForPlayback(t, s, "pause", 50)
+}
+
+func TestPlayerFadeNoMixer(t *testing.T) {
+	p, s := newFadingPlayer(t)
+	s.Modify(func(st *mpdtest.State) { st.Volume = -1 })
+	mustOK(t, p.status.UpdateVolume(p))
+
+	mustOK(t, p.Pause())
+	if _, playback, _ := current(s); playback != "pause" {
+		t.Errorf("playback = %s, should pause at once without a mixer", playback)
+	}
+}
@@ -0,0 +1,150 @@
+package mpris
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd"
+)
+
+// This file implements Previous: it restarts the current song past a threshold, or goes back through the history
+// of played songs while the queue is shuffled. MPD's own previous goes to the song before the current one in the queue,
+// which in random mode is any song.
+
+// The number of songs kept in the history.
+const historySize = 100
+
+// A playedSong is a song of the history: its ID in the queue, and its URI, to add it again once it is gone from the queue.
+type playedSong struct {
+	id  int
+	uri string
+}
+
+// history is a bounded stack of played songs, the last played on top.
+// It is guarded by the status lock.
+type history struct {
+	songs []playedSong
+}
+
+// push adds the song on top of the history, forgetting the oldest song if the history is full.
+func (h *history) push(song playedSong) {
+	if len(h.songs) == historySize {
+		h.songs = append(h.songs[:0], h.songs[1:]...)
+	}
+	h.songs = append(h.songs, song)
+}
+
+// pop removes the song on top of the history, and returns it.
+func (h *history) pop() (playedSong, bool) {
+	if len(h.songs) == 0 {
+		return playedSong{}, false
+	}
+	song := h.songs[len(h.songs)-1]
+	h.songs = h.songs[:len(h.songs)-1]
+	return song, true
+}
+
+// songPlayed records the current song into the history, as another song becomes current.
+// It assumes that the status lock is held.
+func (s *Status) songPlayed() {
+	if s.CurrentSong.ID == -1 || s.goingBack {
+		return
+	}
+	s.history.push(playedSong{id: s.CurrentSong.ID, uri: s.CurrentSong.Path()})
+}
+
+// usesHistory returns whether Previous goes back through the history: with MPD's random mode, queue shuffle or
+// album shuffle, unless NativePrevious is set. In queue order, the song before is the one MPD goes back to.
+// It assumes that the status lock is held.
+func (p *Player) usesHistory(status mpd.Status) bool {
+	return !p.nativePrevious && (status.Random || p.status.queueOrder != nil || p.status.albums != nil)
+}
+
+// atQueueStart returns whether there is nothing to go back to: the current song is the first of the queue, without
+// repeat or random, and the history is empty or not used. It assumes that the status lock is held.
+func (p *Player) atQueueStart(status mpd.Status) bool {
+	return status.Attrs["song"] == "0" && !status.Repeat && !status.Random && (!p.usesHistory(status) || len(p.status.history.songs) == 0)
+}
+
+// canGoPrevious returns whether Previous does anything: going back, or restarting the current song with SmartPrevious.
+// Only a playing or paused song restarts. It assumes that the status lock is held.
+func (p *Player) canGoPrevious(status mpd.Status) bool {
+	if status.Song == -1 {
+		return false
+	}
+	return (p.previousRestart > 0 && status.State != "stop") || !p.atQueueStart(status)
+}
+
+// previous restarts the current song if it has played for longer than the threshold of SmartPrevious,
+// or if there is nothing to go back to.
+// Otherwise, while the queue is shuffled, it plays the last played song from the history, adding it again before
+// the current song if it is gone from the queue. In queue order, or if the history is empty, it goes to MPD's previous song.
+func (p *Player) previous() *dbus.Error {
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	status, _, err := s.fetch(p, nil, false)
+	if err != nil {
+		return err
+	}
+	if p.previousRestart > 0 && status.State != "stop" && status.Seekable && (status.Seek > p.previousRestart || p.atQueueStart(status)) {
+		b.seek(0)
+		return s.updateWith(p, b, func(cl *mpd.CommandList) { cl.SeekID(status.Song, 0) })
+	}
+	if !p.usesHistory(status) {
+		return s.updateWith(p, b, func(cl *mpd.CommandList) { cl.Previous() })
+	}
+	id, herr := p.historySong()
+	if herr != nil {
+		return p.transformErr(herr)
+	}
+	if id == -1 {
+		return s.updateWith(p, b, func(cl *mpd.CommandList) { cl.Previous() })
+	}
+	// Going back does not make history.
+	s.goingBack = true
+	defer func() { s.goingBack = false }()
+	return s.updateWith(p, b, func(cl *mpd.CommandList) { cl.PlayID(id) })
+}
+
+// historySong returns the ID in the queue of the last played song that can be played again, or -1 if there is none.
+// Songs that cannot be added back to the queue are dropped from the history. It assumes that the status lock is held.
+func (p *Player) historySong() (int, error) {
+	s := &p.status
+	if len(s.history.songs) == 0 {
+		return -1, nil
+	}
+	queue, err := p.mpd.PlaylistInfo(-1, -1)
+	if err != nil {
+		return -1, err
+	}
+	queued := make(map[int]string, len(queue))
+	current := -1 // The position of the current song
+	for pos, f := range queue {
+		if id, err := strconv.Atoi(f.Attrs["Id"]); err == nil {
+			queued[id] = f.Path()
+			if id == s.CurrentSong.ID {
+				current = pos
+			}
+		}
+	}
+	for {
+		song, ok := s.history.pop()
+		if !ok {
+			return -1, nil
+		}
+		if uri, ok := queued[song.id]; ok && uri == song.uri {
+			return song.id, nil
+		}
+		// Consumed, or removed: it goes back before the current song.
+		id, err := p.mpd.AddID(song.uri, current)
+		if err != nil {
+			log.Printf("Cannot add %s back to the queue: %v\n", song.uri, err)
+			continue
+		}
+		return id, nil
+	}
+}
@@ -0,0 +1,139 @@
+package mpris
+
+import (
+	"testing"
+	"time"
+
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func TestHistoryBounded(t *testing.T) {
+	var h history
+	for id := 0; id < historySize+10; id++ {
+		h.push(playedSong{id: id})
+	}
+	if len(h.songs) != historySize || h.songs[0].id != 10 {
+		t.Errorf("history of %d songs from %d, want %d from 10", len(h.songs), h.songs[0].id, historySize)
+	}
+	if song, ok := h.pop(); !ok || song.id != historySize+9 {
+		t.Errorf("pop() = %v, %v", song, ok)
+	}
+}
+
+func TestPlayerPreviousHistory(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		for _, q := range st.Queue {
+			st.Library = append(st.Library, q.Song)
+		}
+		st.Play(0)
+		st.Random = true
+	})
+	playerEvent := func() {
+		for _, h := range p.handlers["player"] {
+			if err := h(); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+	playing := func() (file string) {
+		s.Inspect(func(st *mpdtest.State) { file = st.Queue[st.Current].Song["file"] })
+		return
+	}
+
+	// a, then b, then c, as random mode would jump.
+	mustOK(t, p.Next())
+	s.Modify(func(st *mpdtest.State) { st.Play(2) })
+	playerEvent()
+
+	mustOK(t, p.Previous())
+	if file := playing(); file != "b.flac" {
+		t.Errorf("playing %s after Previous, want b.flac", file)
+	}
+	// Consumed songs are added back before the current song.
+	s.Modify(func(st *mpdtest.State) { st.Queue = st.Queue[1:]; st.Current = 0 })
+	mustOK(t, p.Previous())
+	s.Inspect(func(st *mpdtest.State) {
+		if len(st.Queue) != 3 || st.Current != 0 || st.Queue[0].Song["file"] != "a.flac" {
+			t.Errorf("playing %d of %v, want a.flac added back before b.flac", st.Current, st.Queue)
+		}
+	})
+
+	// Without history, or with NativePrevious, Previous is MPD's.
+	mustOK(t, p.Next())
+	mustOK(t, p.Next())
+	p.nativePrevious = true
+	mustOK(t, p.Previous())
+	if file := playing(); file != "b.flac" {
+		t.Errorf("playing %s after MPD's previous, want b.flac", file)
+	}
+
+	// In queue order, Previous is MPD's too, even after a jump.
+	p.nativePrevious = false
+	s.Modify(func(st *mpdtest.State) { st.Random = false })
+	mustOK(t, p.status.UpdateOptions(p))
+	mustOK(t, p.Previous())
+	s.Modify(func(st *mpdtest.State) { st.Play(2) })
+	playerEvent()
+	mustOK(t, p.Previous())
+	if file := playing(); file != "b.flac" {
+		t.Errorf("playing %s after Previous in queue order, want b.flac", file)
+	}
+}
+
+func TestPlayerSmartPrevious(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Play(1)
+		st.Elapsed = 10 * time.Second
+	}, SmartPrevious(3*time.Second))
+	drain(p)
+
+	// Past the threshold, the song restarts.
+	mustOK(t, p.Previous())
+	if pos, _, elapsed := current(s); pos != 1 || elapsed != 0 {
+		t.Errorf("playing %d at %v, want b.flac restarted", pos, elapsed)
+	}
+	if b := drain(p); b.seeked == nil || *b.seeked != 0 {
+		t.Errorf("Seeked = %v, want 0", b.seeked)
+	}
+
+	// Before it, Previous goes back.
+	s.Modify(func(st *mpdtest.State) { st.Elapsed = 2 * time.Second })
+	mustOK(t, p.Previous())
+	if pos, _, _ := current(s); pos != 0 {
+		t.Errorf("playing %d, want a.flac", pos)
+	}
+
+	// At the start of the queue, with nothing played before, the song restarts.
+	p.status.history = history{}
+	mustOK(t, p.status.UpdateQueue(p))
+	if v := getProp(t, p, "CanGoPrevious"); v != true {
+		t.Errorf("CanGoPrevious = %v at the start of the queue", v)
+	}
+	s.Modify(func(st *mpdtest.State) { st.Elapsed = time.Second })
+	mustOK(t, p.Previous())
+	if pos, playback, elapsed := current(s); pos != 0 || playback != "play" || elapsed != 0 {
+		t.Errorf("playing %d (%s) at %v, want a.flac restarted", pos, playback, elapsed)
+	}
+
+	// Without the policy, there is nothing to go back to.
+	p.previousRestart = 0
+	mustOK(t, p.status.UpdateQueue(p))
+	if v := getProp(t, p, "CanGoPrevious"); v != false {
+		t.Errorf("CanGoPrevious = %v at the start of the queue, without restarting", v)
+	}
+	s.Modify(func(st *mpdtest.State) { st.Repeat = true })
+	mustOK(t, p.status.UpdateOptions(p))
+	if v := getProp(t, p, "CanGoPrevious"); v != true {
+		t.Errorf("CanGoPrevious = %v at the start of the queue, with repeat", v)
+	}
+
+	// A stopped song does not restart.
+	p.previousRestart = 3 * time.Second
+	s.Modify(func(st *mpdtest.State) { st.Repeat = false })
+	mustOK(t, p.Stop())
+	if v := getProp(t, p, "CanGoPrevious"); v != false {
+		t.Errorf("CanGoPrevious = %v while stopped at the start of the queue", v)
+	}
+}
@@ -4,11 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
-
-	"github.com/godbus/dbus/v5/introspect"
+	"time"
 
 	"github.com/godbus/dbus/v5"
-	"github.com/godbus/dbus/v5/prop"
 	"github.com/natsukagami/mpd-mpris/mpd"
 	"github.com/pkg/errors"
 )
@@ -16,17 +14,65 @@ import (
 // Instance is an instance of mpd-mpris.
 // It contains a connection to the MPD server and the DBus connection.
 type Instance struct {
-	mpd   *mpd.Client
-	dbus  *dbus.Conn
-	props *prop.Properties
+	mpd     *mpd.Client
+	dbus    *dbus.Conn
+	props   *Properties
+	emitter *emitter
+
+	// Whether the D-Bus connection was opened by the instance, and should be closed with it.
+	ownsDBus bool
+
+	// Property changes within this window of each other are emitted together.
+	coalesceWindow time.Duration
+
+	// How MPRIS volumes map to MPD's, and the size of a volume step.
+	volumeCurve  VolumeCurve
+	volumeStep   float64
+	volumePolicy VolumePolicy
+	// How long the volume fades out before pausing or stopping, and fades in when resuming.
+	fade time.Duration
+
+	// The file keeping the alarms, if any, and the alarms.
+	alarmFile string
+	alarms    *alarmClock
+
+	// What Shuffle does.
+	shuffleMode ShuffleMode
+
+	// Whether Previous is MPD's, rather than going back through the history,
+	// and how long songs play before Previous restarts them instead (0 for never).
+	nativePrevious  bool
+	previousRestart time.Duration
+
+	// The file keeping the queue snapshots, if any, and the snapshots.
+	snapshotFile string
+	snapshots    *snapshotStore
+
+	// Which tracks remember their position, and where.
+	resume    ResumePolicy
+	positions positionStore
 
 	// interface implementations
-	root   *MediaPlayer2
-	player *Player
+	root      *MediaPlayer2
+	player    *Player
+	extension *PlayerExtension
+
+	exports []export
 
 	name string
 
 	displayName string
+
+	handlers map[string][]eventHandler
+}
+
+// An eventHandler reacts to changes of a MPD subsystem.
+type eventHandler func() error
+
+// onEvent registers h to be run whenever the given MPD subsystem changes.
+// See https://mpd.readthedocs.io/en/latest/protocol.html#command-idle for the list of subsystems.
+func (ins *Instance) onEvent(subsystem string, h eventHandler) {
+	ins.handlers[subsystem] = append(ins.handlers[subsystem], h)
 }
 
 // Close ends the connection.
@@ -34,8 +80,13 @@ func (ins *Instance) Close() error {
 	if ins.mpd == nil {
 		return nil // already closed
 	}
-	if err := ins.dbus.Close(); err != nil {
-		return errors.WithStack(err)
+	ins.emitter.stop()
+	if ins.ownsDBus {
+		if err := ins.dbus.Close(); err != nil {
+			return errors.WithStack(err)
+		}
+	} else if err := ins.unexport(); err != nil {
+		return err
 	}
 	if err := ins.mpd.Close(); err != nil {
 		return err
@@ -44,64 +95,148 @@ func (ins *Instance) Close() error {
 	return nil
 }
 
+// unexport removes the instance from a connection it does not own, which stays usable by its owner.
+func (ins *Instance) unexport() error {
+	ifaces := []string{"org.freedesktop.DBus.Properties", "org.freedesktop.DBus.Introspectable"}
+	for _, e := range ins.exports {
+		ifaces = append(ifaces, e.name)
+	}
+	for _, iface := range ifaces {
+		if err := ins.dbus.Export(nil, "/org/mpris/MediaPlayer2", iface); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if _, err := ins.dbus.ReleaseName(ins.Name()); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
 // Name returns the name of the instance.
 func (ins *Instance) Name() string {
 	return ins.name
 }
 
 // NewInstance creates a new instance that takes care of the specified mpd.
-func NewInstance(mpd *mpd.Client, opts ...Option) (ins *Instance, err error) {
+func NewInstance(mpd *mpd.Client, opts ...Option) (*Instance, error) {
+	ins, err := newInstance(mpd, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if ins.dbus == nil {
+		if ins.dbus, err = dbus.SessionBus(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		ins.ownsDBus = true
+		ins.emitter.conn = ins.dbus
+	}
+	go ins.emitter.run()
+
+	if err := ins.dbus.Export(ins.props, "/org/mpris/MediaPlayer2", "org.freedesktop.DBus.Properties"); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return ins, nil
+}
+
+// newInstance creates an instance for mpd with the given options, with its interfaces and their properties,
+// but does not connect to D-Bus: changes are emitted on the connection given with DBusConn, if any, once the emitter runs.
+func newInstance(mpd *mpd.Client, opts ...Option) (ins *Instance, err error) {
 	ins = &Instance{
 		mpd: mpd,
 
 		name: fmt.Sprintf("org.mpris.MediaPlayer2.mpd.instance%d", os.Getpid()),
 
 		displayName: fmt.Sprintf("MPD on %s", mpd.Address),
-	}
-	if ins.dbus, err = dbus.SessionBus(); err != nil {
-		return nil, errors.WithStack(err)
+
+		volumeCurve: LinearVolume,
+		volumeStep:  0.05,
+		shuffleMode: ShuffleRandom,
+
+		handlers: make(map[string][]eventHandler),
 	}
 	// Apply options
 	for _, opt := range opts {
 		opt(ins)
 	}
+	if ins.alarms, err = newAlarmClock(ins.alarmFile); err != nil {
+		return nil, err
+	}
+	if ins.snapshots, err = newSnapshotStore(ins.snapshotFile); err != nil {
+		return nil, err
+	}
+	if ins.resume.enabled() {
+		if ins.positions, err = newPositionStore(mpd, ins.resume); err != nil {
+			return nil, err
+		}
+	}
 
 	ins.root = &MediaPlayer2{Instance: ins}
 	ins.player = &Player{Instance: ins}
+	ins.extension = &PlayerExtension{Instance: ins}
 
 	ins.player.createStatus()
+	ins.player.registerHandlers()
 
-	ins.props, err = prop.Export(ins.dbus, "/org/mpris/MediaPlayer2", map[string]map[string]*prop.Prop{
-		"org.mpris.MediaPlayer2":        ins.root.properties(),
-		"org.mpris.MediaPlayer2.Player": ins.player.props,
+	ins.exportInterface(export{
+		name:  "org.mpris.MediaPlayer2",
+		impl:  ins.root,
+		props: ins.root.properties(),
+	})
+	ins.exportInterface(export{
+		name:    "org.mpris.MediaPlayer2.Player",
+		impl:    ins.player,
+		props:   ins.player.props,
+		signals: ins.player.signals(),
+	})
+	ins.exportInterface(export{
+		name:  "org.mpd.MediaPlayer2.Player",
+		impl:  ins.extension,
+		props: ins.extension.properties(),
 	})
-	return
+
+	ins.emitter = newEmitter(ins.dbus, ins.coalesceWindow)
+	ins.props = newProperties(ins.propertyMap(), ins.emitter)
+	ins.emitter.props = ins.props
+	return ins, nil
 }
 
 // Start starts the instance. Blocking, so you should fire and forget ;)
 func (ins *Instance) Start(ctx context.Context) error {
-	ins.dbus.Export(ins.root, "/org/mpris/MediaPlayer2", "org.mpris.MediaPlayer2")
-	ins.dbus.Export(ins.player, "/org/mpris/MediaPlayer2", "org.mpris.MediaPlayer2.Player")
-	ins.dbus.Export(introspect.NewIntrospectable(ins.IntrospectNode()), "/org/mpris/MediaPlayer2", "org.freedesktop.DBus.Introspectable")
+	for _, e := range ins.exports {
+		if err := ins.dbus.Export(e.impl, "/org/mpris/MediaPlayer2", e.name); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if err := ins.dbus.Export(introspectable{ins}, "/org/mpris/MediaPlayer2", "org.freedesktop.DBus.Introspectable"); err != nil {
+		return errors.WithStack(err)
+	}
 
 	reply, err := ins.dbus.RequestName(ins.Name(), dbus.NameFlagReplaceExisting)
 	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
 		return errors.WithStack(err)
 	}
 
-	// Set up a periodic updaters
-	go ins.mpd.Keepalive(ctx)
+	// Set up a periodic updater
 	go ins.player.pollSeek(ctx)
+	if len(ins.volumePolicy.QuietHours) > 0 {
+		go ins.player.pollQuietHours(ctx)
+	}
+	go ins.player.runAlarms(ctx)
 
 	// Set up a status updater
 	for {
-		if err := ins.mpd.Poll(ctx); errors.Is(err, context.Canceled) {
+		changed, err := ins.mpd.Poll(ctx)
+		if errors.Is(err, context.Canceled) {
 			return nil
 		} else if err != nil {
 			return errors.Wrap(err, "cannot poll mpd")
 		}
-		if err := ins.player.update(); err != nil {
-			return err
+		for _, subsystem := range changed {
+			for _, h := range ins.handlers[subsystem] {
+				if err := h(); err != nil {
+					return errors.Wrapf(err, "handling %s event", subsystem)
+				}
+			}
 		}
 	}
 }
@@ -1,289 +1,90 @@
 package mpris
 
 import (
+	"sort"
+
+	"github.com/godbus/dbus/v5"
 	introspect "github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
 )
 
+// export is a D-Bus interface exported by the instance on "/org/mpris/MediaPlayer2".
+type export struct {
+	name string
+	// The object implementing the interface's methods.
+	// Every exported method returning a *dbus.Error is a D-Bus method.
+	impl interface{}
+	// The interface's properties.
+	props map[string]*prop.Prop
+	// The signals emitted on the interface, which cannot be found by reflection.
+	signals []introspect.Signal
+}
+
+// exportInterface registers a D-Bus interface to be exported (and introspected) by the instance.
+func (ins *Instance) exportInterface(e export) {
+	ins.exports = append(ins.exports, e)
+}
+
+// propertyMap returns the properties of all exported interfaces.
+func (ins *Instance) propertyMap() prop.Map {
+	m := make(prop.Map, len(ins.exports))
+	for _, e := range ins.exports {
+		if e.props != nil {
+			m[e.name] = e.props
+		}
+	}
+	return m
+}
+
 // IntrospectNode returns the root node of the library's introspection output.
-func (i *Instance) IntrospectNode() *introspect.Node {
-	return &introspect.Node{
-		Name: i.Name(),
+// It is generated from the exported interfaces: methods from the implementations' method sets,
+// properties (with their types and access) from their definitions.
+func (ins *Instance) IntrospectNode() *introspect.Node {
+	node := &introspect.Node{
+		Name: "/org/mpris/MediaPlayer2",
 		Interfaces: []introspect.Interface{
 			introspect.IntrospectData,
-			introspect.Interface{
-				Name: "org.mpris.MediaPlayer2",
-				Properties: []introspect.Property{
-					introspect.Property{
-						Name:   "CanQuit",
-						Type:   "b",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "CanRaise",
-						Type:   "b",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "HasTrackList",
-						Type:   "b",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "Identity",
-						Type:   "s",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "SupportedUriSchemes",
-						Type:   "as",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "SupportedMimeTypes",
-						Type:   "as",
-						Access: "read",
-					},
-					{
-						Name:   "Fullscreen",
-						Type:   "b",
-						Access: "read",
-					},
-					{
-						Name:   "CanSetFullscreen",
-						Type:   "b",
-						Access: "read",
-					},
-					{
-						Name:   "DesktopEntry",
-						Type:   "s",
-						Access: "read",
-					},
-				},
-				Methods: []introspect.Method{
-					introspect.Method{
-						Name: "Raise",
-					},
-					introspect.Method{
-						Name: "Quit",
-					},
-				},
-			},
-			introspect.Interface{
-				Name: "org.mpris.MediaPlayer2.Player",
-				Properties: []introspect.Property{
-					introspect.Property{
-						Name:   "PlaybackStatus",
-						Type:   "s",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "LoopStatus",
-						Type:   "s",
-						Access: "readwrite",
-					},
-					introspect.Property{
-						Name:   "Rate",
-						Type:   "d",
-						Access: "readwrite",
-					},
-					introspect.Property{
-						Name:   "Shuffle",
-						Type:   "b",
-						Access: "readwrite",
-					},
-					introspect.Property{
-						Name:   "Metadata",
-						Type:   "a{sv}",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "Volume",
-						Type:   "d",
-						Access: "readwrite",
-					},
-					introspect.Property{
-						Name:   "Position",
-						Type:   "x",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "MinimumRate",
-						Type:   "d",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "MaximumRate",
-						Type:   "d",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "CanGoNext",
-						Type:   "b",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "CanGoPrevious",
-						Type:   "b",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "CanPlay",
-						Type:   "b",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "CanSeek",
-						Type:   "b",
-						Access: "read",
-					},
-					introspect.Property{
-						Name:   "CanControl",
-						Type:   "b",
-						Access: "read",
-					},
-					{
-						Name:   "CanPause",
-						Type:   "b",
-						Access: "read",
-					},
-				},
-				Signals: []introspect.Signal{
-					introspect.Signal{
-						Name: "Seeked",
-						Args: []introspect.Arg{
-							introspect.Arg{
-								Name: "Position",
-								Type: "x",
-							},
-						},
-					},
-				},
-				Methods: []introspect.Method{
-					introspect.Method{
-						Name: "Next",
-					},
-					introspect.Method{
-						Name: "Previous",
-					},
-					introspect.Method{
-						Name: "Pause",
-					},
-					introspect.Method{
-						Name: "PlayPause",
-					},
-					introspect.Method{
-						Name: "Stop",
-					},
-					introspect.Method{
-						Name: "Play",
-					},
-					introspect.Method{
-						Name: "Seek",
-						Args: []introspect.Arg{
-							introspect.Arg{
-								Name:      "Offset",
-								Type:      "x",
-								Direction: "in",
-							},
-						},
-					},
-					introspect.Method{
-						Name: "SetPosition",
-						Args: []introspect.Arg{
-							introspect.Arg{
-								Name:      "TrackId",
-								Type:      "o",
-								Direction: "in",
-							},
-							introspect.Arg{
-								Name:      "Position",
-								Type:      "x",
-								Direction: "in",
-							},
-						},
-					},
-				},
-			},
-			introspect.Interface{
-				Name: "org.freedesktop.DBus.Properties",
-				Signals: []introspect.Signal{
-					introspect.Signal{
-						Name: "PropertiesChanged",
-						Args: []introspect.Arg{
-							introspect.Arg{
-								Name: "interface_name",
-								Type: "s",
-							},
-							introspect.Arg{
-								Name: "changed_properties",
-								Type: "a{sv}",
-							},
-						},
-					},
-				},
-				Methods: []introspect.Method{
-					introspect.Method{
-						Name: "Get",
-						Args: []introspect.Arg{
-							introspect.Arg{
-								Name:      "interface_name",
-								Type:      "s",
-								Direction: "in",
-							},
-							introspect.Arg{
-								Name:      "property_name",
-								Type:      "s",
-								Direction: "in",
-							},
-							introspect.Arg{
-								Name:      "value",
-								Type:      "v",
-								Direction: "out",
-							},
-						},
-					},
-					introspect.Method{
-						Name: "GetAll",
-						Args: []introspect.Arg{
-							introspect.Arg{
-								Name:      "interface_name",
-								Type:      "s",
-								Direction: "in",
-							},
-							introspect.Arg{
-								Name:      "properties",
-								Type:      "a{sv}",
-								Direction: "out",
-							},
-						},
-					},
-					introspect.Method{
-						Name: "Set",
-						Args: []introspect.Arg{
-							introspect.Arg{
-								Name:      "interface_name",
-								Type:      "s",
-								Direction: "in",
-							},
-							introspect.Arg{
-								Name:      "property_name",
-								Type:      "s",
-								Direction: "out",
-							},
-							introspect.Arg{
-								Name:      "value",
-								Type:      "v",
-								Direction: "in",
-							},
-						},
-					},
-				},
-			},
-			// TODO: This interface is not fully implemented.
-			// introspect.Interface{
-			// 	Name: "org.mpris.MediaPlayer2.TrackList",
-
-			// },
+			prop.IntrospectData,
 		},
 	}
+	for _, e := range ins.exports {
+		iface := introspect.Interface{
+			Name:    e.name,
+			Methods: introspect.Methods(e.impl),
+			Signals: e.signals,
+		}
+		if e.props != nil {
+			iface.Properties = ins.props.introspection(e.name)
+		}
+		node.Interfaces = append(node.Interfaces, iface)
+	}
+	return node
+}
+
+// introspectable implements `org.freedesktop.DBus.Introspectable`.
+// The data is generated on every call, as the access of properties can change (e.g. Volume without a mixer).
+type introspectable struct {
+	ins *Instance
+}
+
+// Introspect implements org.freedesktop.DBus.Introspectable.Introspect.
+func (i introspectable) Introspect() (string, *dbus.Error) {
+	return string(introspect.NewIntrospectable(i.ins.IntrospectNode())), nil
+}
+
+// introspection returns the introspection data of the properties of iface, sorted by name.
+func (p *Properties) introspection(iface string) []introspect.Property {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	props := make([]introspect.Property, 0, len(p.m[iface]))
+	for name, pr := range p.m[iface] {
+		props = append(props, pr.Introspection(name))
+	}
+	sort.Slice(props, func(i, j int) bool { return props[i].Name < props[j].Name })
+	return props
+}
+
+// signalArg describes an argument of a signal, typed after the Go value v.
+func signalArg(name string, v interface{}) introspect.Arg {
+	return introspect.Arg{Name: name, Type: dbus.SignatureOf(v).String()}
 }
@@ -0,0 +1,135 @@
+package mpris
+
+import (
+	"log"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd"
+	"github.com/pkg/errors"
+)
+
+// This file implements A–B loops, which play a section of the current track over and over.
+
+// Playback this close to B is looped back, rather than waited for: waking up exactly at B is not possible.
+const loopTolerance = 10 * time.Millisecond
+
+// An abLoop seeks back to A whenever playback reaches B, within the song it was set on.
+// Its fields are guarded by the status lock.
+type abLoop struct {
+	songID int
+	a, b   time.Duration
+	wake   chan struct{} // Wakes the loop up when playback changes
+	stop   chan struct{} // Closed when the loop is cleared
+}
+
+// setLoop loops the current song between a and b, starting from a unless playback is already between them.
+func (p *Player) setLoop(a, b time.Duration) *dbus.Error {
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch := &batch{}
+	defer p.Instance.props.apply(batch)
+	if err := s.updateWith(p, batch, nil); err != nil {
+		return err
+	}
+	song := s.CurrentSong
+	if song.ID == -1 {
+		return dbus.MakeFailedError(errors.New("there is no current track to loop"))
+	}
+	if song.Duration > 0 && b > song.Duration {
+		return invalidArgs("the loop ends after the track, at %v", song.Duration)
+	}
+	p.endLoop(batch)
+	l := &abLoop{songID: song.ID, a: a, b: b, wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	s.loop = l
+	batch.set("org.mpd.MediaPlayer2.Player", "LoopPointA", UsFromDuration(a))
+	batch.set("org.mpd.MediaPlayer2.Player", "LoopPointB", UsFromDuration(b))
+	if s.Seek < a || s.Seek >= b {
+		if err := p.setPosition(batch, song.ID, UsFromDuration(a)); err != nil {
+			p.endLoop(batch)
+			return err
+		}
+	}
+	go p.runLoop(l)
+	return nil
+}
+
+// endLoop clears the loop, if any. It assumes that the status lock is held.
+func (p *Player) endLoop(b *batch) {
+	s := &p.status
+	if s.loop == nil {
+		return
+	}
+	close(s.loop.stop)
+	s.loop = nil
+	b.set("org.mpd.MediaPlayer2.Player", "LoopPointA", TimeInUs(-1))
+	b.set("org.mpd.MediaPlayer2.Player", "LoopPointB", TimeInUs(-1))
+}
+
+// wakeLoop makes the loop, if any, look at the playback position again. It assumes that the status lock is held.
+func (s *Status) wakeLoop() {
+	if s.loop == nil {
+		return
+	}
+	select {
+	case s.loop.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runLoop runs the loop l until it is cleared.
+func (p *Player) runLoop(l *abLoop) {
+	for {
+		wait, ok := p.checkLoop(l)
+		if !ok {
+			return
+		}
+		// While paused, only a change of playback wakes the loop up.
+		var timer <-chan time.Time
+		if wait >= 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-l.stop:
+			return
+		case <-l.wake:
+		case <-timer:
+		}
+	}
+}
+
+// checkLoop seeks back to A if playback has reached B.
+// It returns how long until playback reaches B, or -1 while paused, and whether the loop goes on.
+func (p *Player) checkLoop(l *abLoop) (time.Duration, bool) {
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	if s.loop != l {
+		return 0, false
+	}
+	// The position known from events is too coarse: ask MPD.
+	status, _, err := s.fetch(p, nil, false)
+	if err == nil && status.State == "play" && status.Song == l.songID && status.Seek >= l.b-loopTolerance {
+		status, _, err = s.fetch(p, func(cl *mpd.CommandList) { cl.SeekID(l.songID, l.a) }, false)
+		b.seek(UsFromDuration(l.a))
+	}
+	if err != nil {
+		log.Printf("Cannot loop: %v\n", err)
+		return time.Second, true
+	}
+	if err := s.updatePlayback(p, b, status); err != nil {
+		log.Printf("Cannot loop: %v\n", err)
+	}
+	// Our own update does not need another look.
+	select {
+	case <-l.wake:
+	default:
+	}
+	if status.State != "play" {
+		return -1, true
+	}
+	return l.b - status.Seek, true
+}
@@ -0,0 +1,97 @@
+package mpris
+
+import (
+	"testing"
+	"time"
+
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func loopPoints(t *testing.T, p *Player) (a, b time.Duration) {
+	t.Helper()
+	for name, target := range map[string]*time.Duration{"LoopPointA": &a, "LoopPointB": &b} {
+		v, err := p.Instance.props.Get("org.mpd.MediaPlayer2.Player", name)
+		if err != nil {
+			t.Fatalf("Get(%s): %v", name, err)
+		}
+		*target = v.Value().(TimeInUs).Duration()
+	}
+	return
+}
+
+// waitForElapsed waits until MPD's position is elapsed.
+func waitForElapsed(t *testing.T, s *mpdtest.Server, elapsed time.Duration) {
+	t.Helper()
+	var got time.Duration
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, _, got = current(s); got == elapsed {
+			return
+		}
+	}
+	t.Fatalf("elapsed = %v, want %v", got, elapsed)
+}
+
+func TestPlayerLoop(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Play(0)
+		st.Elapsed = 10 * time.Second
+	})
+	playerEvent := func() {
+		for _, h := range p.handlers["player"] {
+			if err := h(); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+
+	// Loops start from A, to the millisecond.
+	mustOK(t, p.extension.SetLoopPoints(UsFromDuration(1250*time.Millisecond), UsFromDuration(2500*time.Millisecond)))
+	if _, _, elapsed := current(s); elapsed != 1250*time.Millisecond {
+		t.Errorf("elapsed = %v, want 1.25s", elapsed)
+	}
+	if a, b := loopPoints(t, p); a != 1250*time.Millisecond || b != 2500*time.Millisecond {
+		t.Errorf("loop points = %v, %v", a, b)
+	}
+
+	// Reaching B seeks back to A.
+	s.Modify(func(st *mpdtest.State) { st.Elapsed = 2500 * time.Millisecond })
+	playerEvent()
+	waitForElapsed(t, s, 1250*time.Millisecond)
+	if b := drain(p); b.seeked == nil || *b.seeked != UsFromDuration(1250*time.Millisecond) {
+		t.Errorf("Seeked = %v, want 1.25s", b.seeked)
+	}
+
+	// Not while paused.
+	mustOK(t, p.Pause())
+	s.Modify(func(st *mpdtest.State) { st.Elapsed = 5 * time.Second })
+	playerEvent()
+	time.Sleep(50 * time.Millisecond)
+	if _, _, elapsed := current(s); elapsed != 5*time.Second {
+		t.Errorf("elapsed = %v while paused, should stay after B", elapsed)
+	}
+	mustOK(t, p.Play())
+	waitForElapsed(t, s, 1250*time.Millisecond)
+
+	// Changing tracks clears the loop.
+	mustOK(t, p.Next())
+	if a, b := loopPoints(t, p); a != -time.Microsecond || b != -time.Microsecond {
+		t.Errorf("loop points = %v, %v after changing tracks", a, b)
+	}
+
+	mustOK(t, p.extension.SetLoopPoints(UsFromDuration(20*time.Second), UsFromDuration(30*time.Second)))
+	mustOK(t, p.extension.ClearLoopPoints())
+	s.Modify(func(st *mpdtest.State) { st.Elapsed = 40 * time.Second })
+	playerEvent()
+	time.Sleep(50 * time.Millisecond)
+	if _, _, elapsed := current(s); elapsed != 40*time.Second {
+		t.Errorf("elapsed = %v after clearing the loop", elapsed)
+	}
+
+	if err := p.extension.SetLoopPoints(UsFromDuration(20*time.Second), UsFromDuration(10*time.Second)); err == nil {
+		t.Error("loops ending before they start should be refused")
+	}
+	if err := p.extension.SetLoopPoints(UsFromDuration(20*time.Second), UsFromDuration(300*time.Second)); err == nil {
+		t.Error("loops ending after the track should be refused")
+	}
+}
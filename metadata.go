@@ -0,0 +1,136 @@
+package mpris
+
+import (
+	"log"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// This file implements a builder for MetadataMaps, that makes sure every entry has the type given by the specification.
+// https://www.freedesktop.org/wiki/Specifications/mpris-spec/metadata/
+
+// The types of the metadata entries.
+// Go types are checked rather than D-Bus signatures, as godbus encodes an `int` like an `int32`, and a `time.Duration` like an `int64`.
+var metadataTypes = map[string]reflect.Type{
+	"mpris:trackid":        reflect.TypeOf(dbus.ObjectPath("")),
+	"mpris:length":         reflect.TypeOf(int64(0)),
+	"mpris:artUrl":         reflect.TypeOf(""),
+	"xesam:album":          reflect.TypeOf(""),
+	"xesam:albumArtist":    reflect.TypeOf([]string{}),
+	"xesam:artist":         reflect.TypeOf([]string{}),
+	"xesam:contentCreated": reflect.TypeOf(""),
+	"xesam:genre":          reflect.TypeOf([]string{}),
+	"xesam:title":          reflect.TypeOf(""),
+	"xesam:trackNumber":    reflect.TypeOf(int32(0)),
+	"xesam:url":            reflect.TypeOf(""),
+}
+
+// The layouts of the dates accepted by `metadataBuilder.date`, from the most precise.
+// Missing parts of the date are filled with their first value.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// metadataBuilder builds a MetadataMap.
+// Values that are empty are left out, and values that are invalid are left out with a warning.
+type metadataBuilder struct {
+	m MetadataMap
+}
+
+func newMetadataBuilder() *metadataBuilder {
+	return &metadataBuilder{m: MetadataMap{}}
+}
+
+// set sets the entry, if value has the entry's type.
+func (b *metadataBuilder) set(field string, value interface{}) {
+	if typ, ok := metadataTypes[field]; ok && reflect.TypeOf(value) != typ {
+		log.Printf("Dropping metadata %s = %#v: expected type %s, got %T\n", field, value, typ, value)
+		return
+	}
+	b.m[field] = value
+}
+
+func (b *metadataBuilder) trackID(id dbus.ObjectPath) {
+	if !id.IsValid() {
+		log.Printf("Dropping metadata mpris:trackid = %q: not a valid object path\n", id)
+		return
+	}
+	b.set("mpris:trackid", id)
+}
+
+// length sets the length of the track, if it is known.
+func (b *metadataBuilder) length(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	b.set("mpris:length", int64(d/time.Microsecond))
+}
+
+// number sets a 32-bit integer entry, if n is positive.
+func (b *metadataBuilder) number(field string, n int) {
+	if n <= 0 {
+		return
+	}
+	if n > math.MaxInt32 {
+		log.Printf("Dropping metadata %s = %d: out of range\n", field, n)
+		return
+	}
+	b.set(field, int32(n))
+}
+
+func (b *metadataBuilder) string(field, value string) {
+	if value != "" {
+		b.set(field, value)
+	}
+}
+
+// strings sets a list of strings entry, without the empty values.
+func (b *metadataBuilder) strings(field string, values ...string) {
+	toAdd := []string{}
+	for _, v := range values {
+		if v != "" {
+			toAdd = append(toAdd, v)
+		}
+	}
+	if len(toAdd) > 0 {
+		b.set(field, toAdd)
+	}
+}
+
+// date sets a date entry, normalised to an ISO 8601 date-time.
+func (b *metadataBuilder) date(field, value string) {
+	if value == "" {
+		return
+	}
+	t, ok := parseDate(value)
+	if !ok {
+		log.Printf("Dropping metadata %s = %q: not a date\n", field, value)
+		return
+	}
+	b.set(field, t.Format(time.RFC3339))
+}
+
+// parseDate parses MPD's free-form dates, as long as they start with the year.
+func parseDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (b *metadataBuilder) build() MetadataMap {
+	return b.m
+}
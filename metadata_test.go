@@ -0,0 +1,97 @@
+package mpris
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd"
+)
+
+func TestMapFromSong(t *testing.T) {
+	song := mpd.Song{
+		ID: 3,
+		File: mpd.File{
+			Title:       "A",
+			Artist:      "B",
+			Album:       "C",
+			AlbumArtist: "",
+			Date:        "2004-05",
+			Track:       7,
+			Duration:    200500 * time.Millisecond,
+		},
+	}
+	got := MapFromSong("/org/mpd/MediaPlayer2/Track/1/3", song)
+	want := MetadataMap{
+		"mpris:trackid":        dbus.ObjectPath("/org/mpd/MediaPlayer2/Track/1/3"),
+		"mpris:length":         int64(200500000),
+		"xesam:title":          "A",
+		"xesam:artist":         []string{"B"},
+		"xesam:album":          "C",
+		"xesam:contentCreated": "2004-05-01T00:00:00Z",
+		"xesam:trackNumber":    int32(7),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MapFromSong = %#v, want %#v", got, want)
+	}
+	for field, value := range got {
+		if typ, ok := metadataTypes[field]; ok && reflect.TypeOf(value) != typ {
+			t.Errorf("%s has type %T, want %s", field, value, typ)
+		}
+	}
+
+	// Streams have no length, and invalid values are dropped.
+	song.Duration = 0
+	song.Date = "sometime in 2004"
+	got = MapFromSong("/org/mpd/MediaPlayer2/Track/1/3", song)
+	for _, field := range []string{"mpris:length", "xesam:contentCreated"} {
+		if v, ok := got[field]; ok {
+			t.Errorf("%s = %#v, should be left out", field, v)
+		}
+	}
+
+	got = MapFromSong(NoTrack, mpd.Song{ID: -1})
+	if want := (MetadataMap{"mpris:trackid": NoTrack}); !reflect.DeepEqual(got, want) {
+		t.Errorf("MapFromSong(no song) = %#v, want %#v", got, want)
+	}
+}
+
+func TestMetadataDate(t *testing.T) {
+	tests := []struct {
+		date string
+		want string // Empty if dropped
+	}{
+		{"2004", "2004-01-01T00:00:00Z"},
+		{"2004-05", "2004-05-01T00:00:00Z"},
+		{"2004-05-17", "2004-05-17T00:00:00Z"},
+		{" 2004-05-17 ", "2004-05-17T00:00:00Z"},
+		{"2004-05-17T13:14", "2004-05-17T13:14:00Z"},
+		{"2004-05-17T13:14:15", "2004-05-17T13:14:15Z"},
+		{"2004-05-17 13:14:15", "2004-05-17T13:14:15Z"},
+		{"2004-05-17T13:14:15+02:00", "2004-05-17T13:14:15+02:00"},
+		{"2004-05-17T13:14:15.5Z", "2004-05-17T13:14:15Z"},
+		{"2004-13", ""},
+		{"May 2004", ""},
+		{"04", ""},
+	}
+	for _, test := range tests {
+		b := newMetadataBuilder()
+		b.date("xesam:contentCreated", test.date)
+		got, _ := b.build()["xesam:contentCreated"].(string)
+		if got != test.want {
+			t.Errorf("date(%q) = %q, want %q", test.date, got, test.want)
+		}
+	}
+}
+
+func TestMetadataTypes(t *testing.T) {
+	b := newMetadataBuilder()
+	b.set("xesam:trackNumber", 3) // A Go int
+	b.set("mpris:length", time.Second)
+	b.number("xesam:trackNumber", 1<<40)
+	b.trackID("not/a/path")
+	if m := b.build(); len(m) != 0 {
+		t.Errorf("invalid entries should be dropped, got %#v", m)
+	}
+}
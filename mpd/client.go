@@ -1,41 +1,42 @@
 package mpd
 
 import (
-	"context"
-	"log"
-	"os"
-	"strconv"
+	"net"
+	"net/textproto"
 	"sync"
-	"time"
 
 	"github.com/fhs/gompd/v2/mpd"
 	"github.com/pkg/errors"
 )
 
 // Client represents a MPD client.
-// Some of the methods are overriden from the `mpd.Client` struct to provide typings safety.
+// All commands and events share a single connection, driven by the `Engine`.
 type Client struct {
-	*mpd.Client
+	*Engine
 	*Watcher
 	Address        string
 	MusicDirectory string
+	// Whether MPD has a sticker database, without which no song has stickers.
+	HasStickers bool
 
 	lastSongMu sync.Mutex
 	lastSong   *Song
 }
 
-// KeepaliveTimeout is the time between pings to keep the connection alive.
-// As MPD recommends a 30 second timeout for connection (https://mpd.readthedocs.io/en/latest/client.html#environment-variables),
-// we keep something similar here.
-// However, if we detect `MPD_TIMEOUT`, we set the corresponding timeout.
-const KeepaliveTimeoutDefault = 25 * time.Second
-
 func (c *Client) init() error {
 	// Find the music directory
 	conf, err := c.Command("config").Attrs()
 	if err == nil {
 		c.MusicDirectory = conf["music_directory"]
 	}
+	// MPD only has the sticker command with a sticker database. If we cannot tell, we try it anyway.
+	commands, err := c.Command("commands").Strings("command")
+	c.HasStickers = err != nil
+	for _, cmd := range commands {
+		if cmd == "sticker" {
+			c.HasStickers = true
+		}
+	}
 	return nil
 }
 
@@ -47,16 +48,29 @@ func Dial(network, addr string) (*Client, error) {
 // DialAuthenticated connects to MPD listening on address addr (e.g. "127.0.0.1:6600") on network network (e.g. "tcp").
 // It then authenticates with MPD using the plaintext password password if it's not empty.
 func DialAuthenticated(network, addr, password string) (*Client, error) {
-	c, err := mpd.DialAuthenticated(network, addr, password)
+	e, err := DialEngine(network, addr, password, eventsToSubscribe...)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
-	w, err := NewWatcher(network, addr, password)
+	return newClient(e, addr)
+}
+
+// NewClient creates a client from an established connection to MPD at address addr,
+// that has not yet received MPD's greeting.
+// It then authenticates with MPD using the plaintext password password if it's not empty.
+func NewClient(conn net.Conn, addr, password string) (*Client, error) {
+	e, err := NewEngine(textproto.NewConn(conn), password, eventsToSubscribe...)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
-	client := &Client{Client: c, Watcher: w, Address: addr}
+	return newClient(e, addr)
+}
+
+func newClient(e *Engine, addr string) (*Client, error) {
+	client := &Client{Engine: e, Watcher: e.watcher, Address: addr}
 	if err := client.init(); err != nil {
+		e.Close()
 		return nil, err
 	}
 	return client, nil
@@ -64,10 +78,15 @@ func DialAuthenticated(network, addr, password string) (*Client, error) {
 
 // CurrentSong returns information about the current song in the playlist.
 func (c *Client) CurrentSong() (Song, error) {
-	a, e := c.Client.CurrentSong()
+	a, e := c.Command("currentsong").Attrs()
 	if e != nil {
 		return Song{}, errors.WithStack(e)
 	}
+	return c.currentSongFromAttrs(a)
+}
+
+// currentSongFromAttrs returns the current song from the response of `currentsong`.
+func (c *Client) currentSongFromAttrs(a mpd.Attrs) (Song, error) {
 	c.lastSongMu.Lock()
 	defer c.lastSongMu.Unlock()
 
@@ -93,7 +112,7 @@ func (c *Client) CurrentSong() (Song, error) {
 //
 // Searches are case sensitive. Use Search for case insensitive search.
 func (c *Client) Find(args ...string) ([]File, error) {
-	a, err := c.Client.Find(args...)
+	a, err := c.Command("find %s", mpd.Quoted(quoteArgs(args))).AttrsList("file")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -109,7 +128,7 @@ func (c *Client) Find(args ...string) ([]File, error) {
 // ListAllInfo returns attributes for songs in the library. Information about any song that is either inside or matches the passed in uri is returned.
 // To get information about every song in the library, pass in "/".
 func (c *Client) ListAllInfo(uri string) ([]Item, error) {
-	a, err := c.Client.ListAllInfo(uri)
+	a, err := c.Command("listallinfo %s", uri).AttrsList("file", "directory")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -124,7 +143,7 @@ func (c *Client) ListAllInfo(uri string) ([]Item, error) {
 
 // ListInfo lists the contents of the directory URI using MPD's lsinfo command.
 func (c *Client) ListInfo(uri string) ([]Item, error) {
-	a, err := c.Client.ListInfo(uri)
+	a, err := c.Command("lsinfo %s", uri).AttrsList("file", "directory", "playlist")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -139,7 +158,7 @@ func (c *Client) ListInfo(uri string) ([]Item, error) {
 
 // ListPlaylists lists all stored playlists.
 func (c *Client) ListPlaylists() ([]PlaylistFile, error) {
-	a, err := c.Client.ListPlaylists()
+	a, err := c.Command("listplaylists").AttrsList("playlist")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -152,7 +171,7 @@ func (c *Client) ListPlaylists() ([]PlaylistFile, error) {
 
 // PlaylistContents returns a list of attributes for songs in the specified stored playlist.
 func (c *Client) PlaylistContents(name string) ([]File, error) {
-	a, err := c.Client.PlaylistContents(name)
+	a, err := c.Command("listplaylistinfo %s", name).AttrsList("file")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -170,7 +189,18 @@ func (c *Client) PlaylistContents(name string) ([]File, error) {
 // If end is negative but start is positive, it does it for the song at position start.
 // If both start and end are positive, it does it for positions in range [start, end).
 func (c *Client) PlaylistInfo(start, end int) ([]File, error) {
-	a, err := c.Client.PlaylistInfo(start, end)
+	var cmd *Command
+	switch {
+	case start < 0 && end < 0:
+		cmd = c.Command("playlistinfo")
+	case start >= 0 && end >= 0:
+		cmd = c.Command("playlistinfo %d:%d", start, end)
+	case start >= 0 && end < 0:
+		cmd = c.Command("playlistinfo %d", start)
+	default:
+		return nil, errors.New("negative start index")
+	}
+	a, err := cmd.AttrsList("file")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -185,7 +215,7 @@ func (c *Client) PlaylistInfo(start, end int) ([]File, error) {
 
 // Stats displays statistics (number of artists, songs, playtime, etc)
 func (c *Client) Stats() (Stats, error) {
-	a, e := c.Client.Stats()
+	a, e := c.Command("stats").Attrs()
 	if e != nil {
 		return Stats{}, errors.WithStack(e)
 	}
@@ -194,45 +224,14 @@ func (c *Client) Stats() (Stats, error) {
 
 // Status returns information about the current status of MPD.
 func (c *Client) Status() (Status, error) {
-	a, e := c.Client.Status()
+	a, e := c.Command("status").Attrs()
 	if e != nil {
 		return Status{}, errors.WithStack(e)
 	}
 	return StatusFromAttrs(a)
 }
 
-// Keepalive keeps the client alive with pings until `ctx` is done.
-func (c *Client) Keepalive(ctx context.Context) {
-	// get the timeout
-	timeout := KeepaliveTimeoutDefault
-	if tStr, ok := os.LookupEnv("MPD_TIMEOUT"); ok {
-		if t, err := strconv.Atoi(tStr); err == nil {
-			timeout = time.Duration(t) * time.Second
-			log.Printf("Using MPD_TIMEOUT's keepalive clock of %v", timeout)
-		}
-	}
-
-	ticker := time.NewTicker(timeout)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			if err := c.Ping(); err != nil {
-				log.Fatalf("Connection to mpd is severed: %+v\nEnsure MPD's \"connection_timeout\" setting is greater than %v seconds.", errors.WithStack(err), timeout.Seconds())
-			}
-		}
-	}
-}
-
 // Close closes the client.
 func (c *Client) Close() error {
-	if err := c.Client.Close(); err != nil {
-		return errors.WithStack(err)
-	}
-	if err := c.Watcher.Close(); err != nil {
-		return errors.WithStack(err)
-	}
-	return nil
+	return c.Engine.Close()
 }
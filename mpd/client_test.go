@@ -0,0 +1,65 @@
+package mpd
+
+import (
+	"testing"
+
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+// newTestClient starts a fake MPD server with the state prepared by setup, and connects to it.
+func newTestClient(t *testing.T, setup func(st *mpdtest.State)) (*Client, *mpdtest.Server) {
+	t.Helper()
+	s, err := mpdtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if setup != nil {
+		s.Modify(setup)
+	}
+	c, err := Dial(s.Network(), s.Addr())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, s
+}
+
+func TestDialAuthenticated(t *testing.T) {
+	s, err := mpdtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Password = "secret"
+	s.Modify(func(st *mpdtest.State) { st.Config["music_directory"] = "/music" })
+
+	if c, err := DialAuthenticated(s.Network(), s.Addr(), "wrong"); err == nil {
+		c.Close()
+		t.Fatal("expected an error with a wrong password")
+	}
+	c, err := DialAuthenticated(s.Network(), s.Addr(), "secret")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer c.Close()
+	if c.MusicDirectory != "/music" {
+		t.Errorf("MusicDirectory = %q, want %q", c.MusicDirectory, "/music")
+	}
+}
+
+func TestFind(t *testing.T) {
+	c, _ := newTestClient(t, func(st *mpdtest.State) {
+		st.Library = []mpdtest.Song{
+			{"file": "hits/1.flac", "Album": "100% Hits"},
+			{"file": "other/1.flac", "Album": "100 Hits"},
+		}
+	})
+	files, err := c.Find("album", "100% Hits")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(files) != 1 || files[0].Path() != "hits/1.flac" {
+		t.Errorf("Find(album, 100%% Hits) = %v, want hits/1.flac", files)
+	}
+}
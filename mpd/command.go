@@ -0,0 +1,335 @@
+package mpd
+
+import (
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/fhs/gompd/v2/mpd"
+	"github.com/pkg/errors"
+)
+
+// quote quotes a string value in the format understood by MPD.
+// See https://mpd.readthedocs.io/en/latest/protocol.html#escaping-string-values
+func quote(s string) string {
+	var q strings.Builder
+	q.Grow(2 + 2*len(s))
+	q.WriteByte('"')
+	for _, c := range []byte(s) {
+		switch c {
+		case '"', '\\', '\'':
+			q.WriteByte('\\')
+		}
+		q.WriteByte(c)
+	}
+	q.WriteByte('"')
+	return q.String()
+}
+
+// quoteArgs quotes each of args and joins them with spaces.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Command is a single MPD command, that is sent when one of its response-reading methods is called.
+type Command struct {
+	engine *Engine
+	cmd    string
+}
+
+// Command returns a command that can be sent to MPD.
+//
+// Strings in args are automatically quoted so that spaces are preserved.
+// Pass strings as `mpd.Quoted` if this is not desired.
+func (e *Engine) Command(format string, args ...interface{}) *Command {
+	return &Command{engine: e, cmd: formatCommand(format, args...)}
+}
+
+func formatCommand(format string, args ...interface{}) string {
+	for i := range args {
+		switch s := args[i].(type) {
+		case mpd.Quoted: // ignore
+		case string:
+			args[i] = quote(s)
+		}
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// String returns the encoded command.
+func (cmd *Command) String() string {
+	return cmd.cmd
+}
+
+// send sends the command and reads the response with read.
+func (cmd *Command) send(read func() error) error {
+	return cmd.engine.do(func() error {
+		if err := cmd.engine.writeLine(cmd.cmd); err != nil {
+			return err
+		}
+		return read()
+	})
+}
+
+// OK sends the command and checks for errors.
+func (cmd *Command) OK() error {
+	return cmd.send(cmd.engine.readOK)
+}
+
+// Attrs sends the command and reads the attributes returned in response.
+func (cmd *Command) Attrs() (attrs mpd.Attrs, err error) {
+	err = cmd.send(func() (err error) {
+		attrs, err = cmd.engine.readAttrs()
+		return
+	})
+	return
+}
+
+// AttrsList sends the command and reads a list of attributes returned in response.
+// Each attribute group starts with one of the keys in startKeys.
+func (cmd *Command) AttrsList(startKeys ...string) (attrs []mpd.Attrs, err error) {
+	err = cmd.send(func() (err error) {
+		attrs, err = cmd.engine.readAttrsList(startKeys...)
+		return
+	})
+	return
+}
+
+// Strings sends the command and reads a list of strings with the given key returned in response.
+func (cmd *Command) Strings(key string) (list []string, err error) {
+	err = cmd.send(func() (err error) {
+		list, err = cmd.engine.readList(key)
+		return
+	})
+	return
+}
+
+// Binary sends the command and reads its binary response, returning the data and its total size
+// (which can be greater than the returned chunk).
+func (cmd *Command) Binary() (data []byte, size int, err error) {
+	err = cmd.send(func() (err error) {
+		data, size, err = cmd.engine.readBinary()
+		return
+	})
+	return
+}
+
+// ============================================================================
+// Low-level protocol handling.
+// These must only be called with exclusive access to the connection.
+
+// writeLine writes a command line. MPD commands are terminated by '\n', not CR-LF.
+func (e *Engine) writeLine(line string) error {
+	if _, err := e.text.W.WriteString(line); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := e.text.W.WriteByte('\n'); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(e.text.W.Flush())
+}
+
+// readLine reads a line of the response, turning `ACK` lines into `mpd.Error`s.
+func (e *Engine) readLine() (string, error) {
+	line, err := e.text.ReadLine()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if strings.HasPrefix(line, "ACK ") {
+		return "", parseAck(line)
+	}
+	return line, nil
+}
+
+// parseAck parses an `ACK [error@command_listNum] {current_command} message_text` line.
+func parseAck(line string) error {
+	cur := strings.TrimPrefix(line, "ACK ")
+	var mpdErr mpd.Error
+	if strings.HasPrefix(cur, "[") {
+		sep := strings.Index(cur, "@")
+		end := strings.Index(cur, "] ")
+		if sep > 0 && end > sep {
+			code, err := strconv.Atoi(cur[1:sep])
+			if err != nil {
+				return errors.WithStack(textproto.ProtocolError("can't parse ack: " + line))
+			}
+			idx, err := strconv.Atoi(cur[sep+1 : end])
+			if err != nil {
+				return errors.WithStack(textproto.ProtocolError("can't parse ack: " + line))
+			}
+			mpdErr.Code = mpd.ErrorCode(code)
+			mpdErr.CommandListIndex = idx
+			cur = cur[end+2:]
+		}
+	}
+	if strings.HasPrefix(cur, "{") {
+		if end := strings.Index(cur, "} "); end > 0 {
+			mpdErr.CommandName = cur[1:end]
+			cur = cur[end+2:]
+		}
+	}
+	mpdErr.Message = strings.TrimSpace(cur)
+	return errors.WithStack(mpdErr)
+}
+
+// splitAttr splits a "key: value" line.
+func splitAttr(line string) (key, value string, err error) {
+	i := strings.Index(line, ": ")
+	if i < 0 {
+		return "", "", errors.WithStack(textproto.ProtocolError("can't parse line: " + line))
+	}
+	return line[:i], line[i+2:], nil
+}
+
+// readOK reads a response that is expected to be empty.
+func (e *Engine) readOK() error {
+	return e.readOKLine("OK")
+}
+
+func (e *Engine) readOKLine(terminator string) error {
+	line, err := e.readLine()
+	if err != nil {
+		return err
+	}
+	if line != terminator {
+		return errors.WithStack(textproto.ProtocolError("unexpected response: " + line))
+	}
+	return nil
+}
+
+// readAttrs reads a response of "key: value" lines.
+func (e *Engine) readAttrs() (mpd.Attrs, error) {
+	return e.readAttrsUntil("OK")
+}
+
+func (e *Engine) readAttrsUntil(terminator string) (mpd.Attrs, error) {
+	attrs := make(mpd.Attrs)
+	for {
+		line, err := e.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == terminator {
+			return attrs, nil
+		}
+		key, value, err := splitAttr(line)
+		if err != nil {
+			return nil, err
+		}
+		attrs[key] = value
+	}
+}
+
+// readAttrsList reads a response containing a list of attribute groups, each starting with one of startKeys.
+func (e *Engine) readAttrsList(startKeys ...string) ([]mpd.Attrs, error) {
+	return e.readAttrsListUntil("OK", startKeys...)
+}
+
+func (e *Engine) readAttrsListUntil(terminator string, startKeys ...string) ([]mpd.Attrs, error) {
+	attrs := []mpd.Attrs{}
+	for {
+		line, err := e.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == terminator {
+			return attrs, nil
+		}
+		key, value, err := splitAttr(line)
+		if err != nil {
+			return nil, err
+		}
+		for _, startKey := range startKeys {
+			if key == startKey { // new entry begins
+				attrs = append(attrs, mpd.Attrs{})
+				break
+			}
+		}
+		if len(attrs) == 0 {
+			return nil, errors.WithStack(textproto.ProtocolError("unexpected: " + line))
+		}
+		attrs[len(attrs)-1][key] = value
+	}
+}
+
+// readList reads a response of "key: value" lines that all have the same key.
+func (e *Engine) readList(key string) ([]string, error) {
+	return e.readListUntil("OK", key)
+}
+
+func (e *Engine) readListUntil(terminator string, key string) ([]string, error) {
+	list := []string{}
+	for {
+		line, err := e.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == terminator {
+			return list, nil
+		}
+		k, value, err := splitAttr(line)
+		if err != nil {
+			return nil, err
+		}
+		if k != key {
+			return nil, errors.WithStack(textproto.ProtocolError("unexpected: " + line))
+		}
+		list = append(list, value)
+	}
+}
+
+// readBinary reads a response containing a binary chunk.
+func (e *Engine) readBinary() ([]byte, int, error) {
+	return e.readBinaryUntil("OK")
+}
+
+func (e *Engine) readBinaryUntil(terminator string) ([]byte, int, error) {
+	size := -1
+	for {
+		line, err := e.readLine()
+		if err != nil {
+			return nil, 0, err
+		}
+		if line == terminator {
+			return nil, 0, errors.WithStack(textproto.ProtocolError("no binary data found in response"))
+		}
+		key, value, err := splitAttr(line)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch key {
+		case "size":
+			if size, err = strconv.Atoi(value); err != nil {
+				return nil, 0, errors.WithStack(textproto.ProtocolError("failed to parse size: " + err.Error()))
+			}
+		case "binary":
+			length, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, 0, errors.WithStack(textproto.ProtocolError("failed to parse binary: " + err.Error()))
+			}
+			// If no size is given, assume it's equal to the provided data's length
+			if size < 0 {
+				size = length
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(e.text.R, data); err != nil {
+				return nil, 0, errors.WithStack(err)
+			}
+			if b, err := e.text.R.ReadByte(); err != nil {
+				return nil, 0, errors.WithStack(err)
+			} else if b != '\n' {
+				return nil, 0, errors.WithStack(textproto.ProtocolError(fmt.Sprintf("wrong binary data terminator: want 0x0a, got %x", b)))
+			}
+			if err := e.readOKLine(terminator); err != nil {
+				return nil, 0, err
+			}
+			return data, size, nil
+		}
+	}
+}
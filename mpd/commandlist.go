@@ -0,0 +1,242 @@
+package mpd
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fhs/gompd/v2/mpd"
+	"github.com/pkg/errors"
+)
+
+// ErrNotExecuted is the result of commands in a command list that follow a failed command.
+var ErrNotExecuted = errors.New("command not executed: a previous command in the list failed")
+
+// CommandList batches several commands into a single round trip, using `command_list_ok_begin`.
+// Each queued command returns a Promise, which holds its typed result once End returns.
+//
+// See https://mpd.readthedocs.io/en/latest/protocol.html#command-lists
+type CommandList struct {
+	client *Client
+	cmds   []*listCommand
+}
+
+type listCommand struct {
+	cmd    string
+	read   func() error // Reads the response, with exclusive access to the connection.
+	finish func(error)  // Resolves the promise, after the whole list is read.
+}
+
+// Promise is the result of a command in a CommandList.
+type Promise[T any] struct {
+	value T
+	err   error
+	done  bool
+}
+
+// Value returns the result of the command.
+// Returns an error if CommandList.End has not yet been called.
+func (p *Promise[T]) Value() (T, error) {
+	if !p.done {
+		var zero T
+		return zero, errors.New("value has not been computed yet")
+	}
+	return p.value, p.err
+}
+
+// BeginCommandList starts a new, empty command list.
+func (c *Client) BeginCommandList() *CommandList {
+	return &CommandList{client: c}
+}
+
+// enqueue adds a command to the list.
+// The response is read by read (given the response terminator), and then converted into the promised value by convert.
+func enqueue[R, T any](cl *CommandList, read func(terminator string) (R, error), convert func(R) (T, error), format string, args ...interface{}) *Promise[T] {
+	p := &Promise[T]{}
+	var raw R
+	cl.cmds = append(cl.cmds, &listCommand{
+		cmd: formatCommand(format, args...),
+		read: func() (err error) {
+			raw, err = read("list_OK")
+			return
+		},
+		finish: func(err error) {
+			p.done = true
+			if err != nil {
+				p.err = err
+				return
+			}
+			p.value, p.err = convert(raw)
+		},
+	})
+	return p
+}
+
+func identity[T any](v T) (T, error) { return v, nil }
+
+// OK queues a command without any response.
+func (cl *CommandList) OK(format string, args ...interface{}) *Promise[struct{}] {
+	e := cl.client.Engine
+	return enqueue(cl, func(t string) (struct{}, error) { return struct{}{}, e.readOKLine(t) }, identity[struct{}], format, args...)
+}
+
+// Attrs queues a command that returns a set of attributes.
+func (cl *CommandList) Attrs(format string, args ...interface{}) *Promise[mpd.Attrs] {
+	return enqueue(cl, cl.client.readAttrsUntil, identity[mpd.Attrs], format, args...)
+}
+
+// Status queues a `status` command.
+func (cl *CommandList) Status() *Promise[Status] {
+	return enqueue(cl, cl.client.readAttrsUntil, StatusFromAttrs, "status")
+}
+
+// CurrentSong queues a `currentsong` command.
+func (cl *CommandList) CurrentSong() *Promise[Song] {
+	return enqueue(cl, cl.client.readAttrsUntil, cl.client.currentSongFromAttrs, "currentsong")
+}
+
+// Next queues a `next` command.
+func (cl *CommandList) Next() *Promise[struct{}] {
+	return cl.OK("next")
+}
+
+// Previous queues a `previous` command.
+func (cl *CommandList) Previous() *Promise[struct{}] {
+	return cl.OK("previous")
+}
+
+// Pause queues a command that pauses playback if pause is true, or resumes it otherwise.
+func (cl *CommandList) Pause(pause bool) *Promise[struct{}] {
+	return cl.OK("pause %d", boolArg(pause))
+}
+
+// Play queues a command that plays the song at queue position pos.
+// If pos is negative, plays at the current position in the queue.
+func (cl *CommandList) Play(pos int) *Promise[struct{}] {
+	if pos < 0 {
+		return cl.OK("play")
+	}
+	return cl.OK("play %d", pos)
+}
+
+// PlayID queues a command that plays the song identified by id.
+// If id is negative, plays at the current position in the queue.
+func (cl *CommandList) PlayID(id int) *Promise[struct{}] {
+	if id < 0 {
+		return cl.OK("playid")
+	}
+	return cl.OK("playid %d", id)
+}
+
+// Stop queues a `stop` command.
+func (cl *CommandList) Stop() *Promise[struct{}] {
+	return cl.OK("stop")
+}
+
+// SeekID queues a command that seeks to the position pos of the song identified by id, to the millisecond.
+func (cl *CommandList) SeekID(id int, pos time.Duration) *Promise[struct{}] {
+	return cl.OK("seekid %d %.3f", id, pos.Seconds())
+}
+
+// SetVolume queues a command that sets the volume to volume. The range of volume is 0-100.
+func (cl *CommandList) SetVolume(volume int) *Promise[struct{}] {
+	return cl.OK("setvol %d", volume)
+}
+
+// Random queues a command that enables random playback if random is true, or disables it otherwise.
+func (cl *CommandList) Random(random bool) *Promise[struct{}] {
+	return cl.OK("random %d", boolArg(random))
+}
+
+// Repeat queues a command that enables repeat mode if repeat is true, or disables it otherwise.
+func (cl *CommandList) Repeat(repeat bool) *Promise[struct{}] {
+	return cl.OK("repeat %d", boolArg(repeat))
+}
+
+// Single queues a command that enables single song mode if single is true, or disables it otherwise.
+func (cl *CommandList) Single(single bool) *Promise[struct{}] {
+	return cl.OK("single %d", boolArg(single))
+}
+
+// SingleMode queues a command that sets single mode to mode, one of SingleOff, SingleOn and SingleOneshot.
+func (cl *CommandList) SingleMode(mode string) *Promise[struct{}] {
+	return cl.OK("single %s", mode)
+}
+
+// Consume queues a command that enables consume mode if consume is true, or disables it otherwise.
+func (cl *CommandList) Consume(consume bool) *Promise[struct{}] {
+	return cl.OK("consume %d", boolArg(consume))
+}
+
+// MoveID queues a command that moves the song with the given ID to position pos in the queue.
+func (cl *CommandList) MoveID(id, pos int) *Promise[struct{}] {
+	return cl.OK("moveid %d %d", id, pos)
+}
+
+// Shuffle queues a command that shuffles the songs at positions [start, end) of the queue.
+// If end is negative, the songs from start to the end of the queue are shuffled.
+func (cl *CommandList) Shuffle(start, end int) *Promise[struct{}] {
+	if end < 0 {
+		return cl.OK("shuffle %d:", start)
+	}
+	return cl.OK("shuffle %d:%d", start, end)
+}
+
+// Clear queues a command that removes all songs from the queue.
+func (cl *CommandList) Clear() *Promise[struct{}] {
+	return cl.OK("clear")
+}
+
+// Load queues a command that adds the stored playlist name to the queue.
+func (cl *CommandList) Load(name string) *Promise[struct{}] {
+	return cl.OK("load %s", name)
+}
+
+// End sends all queued commands and reads their responses, resolving every promise.
+// It returns the error that interrupted the list, if any.
+// Errors converting a response into its typed value are only reported by the corresponding promise.
+func (cl *CommandList) End() error {
+	if len(cl.cmds) == 0 {
+		return nil
+	}
+	e := cl.client.Engine
+
+	var block strings.Builder
+	block.WriteString("command_list_ok_begin\n")
+	for _, c := range cl.cmds {
+		block.WriteString(c.cmd)
+		block.WriteByte('\n')
+	}
+	block.WriteString("command_list_end")
+
+	read := 0 // The number of commands whose responses were read successfully.
+	err := e.do(func() error {
+		if err := e.writeLine(block.String()); err != nil {
+			return err
+		}
+		for _, c := range cl.cmds {
+			if err := c.read(); err != nil {
+				return err
+			}
+			read++
+		}
+		return e.readOK()
+	})
+
+	// Resolve the promises
+	var mpdErr mpd.Error
+	isAck := errors.As(err, &mpdErr)
+	for i, c := range cl.cmds {
+		switch {
+		case i < read:
+			c.finish(nil)
+		case i == read && isAck:
+			c.finish(err)
+		case isAck:
+			c.finish(ErrNotExecuted)
+		default:
+			c.finish(err)
+		}
+	}
+	cl.cmds = nil
+	return errors.Wrap(err, "command list")
+}
@@ -0,0 +1,217 @@
+package mpd
+
+import (
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrClosed is returned by commands issued after the engine has stopped.
+var ErrClosed = errors.New("mpd connection is closed")
+
+// Engine drives a single connection to MPD.
+//
+// While no command is pending, the connection sits in `idle`, waiting for changes
+// in the subscribed subsystems. Commands queued from other goroutines interrupt the
+// idle with `noidle`, run with exclusive access to the connection, and the engine
+// goes back to idle afterwards.
+// Since an idling connection is never timed out by MPD, no keepalive is needed.
+//
+// See https://mpd.readthedocs.io/en/latest/protocol.html#command-idle
+type Engine struct {
+	text    *textproto.Conn
+	version string
+
+	subsystems []string
+	watcher    *Watcher
+
+	jobs chan *job
+	quit chan struct{}
+	done chan struct{}
+
+	closeOnce sync.Once
+	err       error // The error that stopped the engine, set before `done` is closed.
+}
+
+// A job is a piece of work that needs exclusive access to the connection.
+type job struct {
+	run    func() error
+	result chan error
+}
+
+// The response to an `idle` command.
+type idleResult struct {
+	changed []string
+	err     error
+}
+
+// DialEngine connects to MPD listening on address addr (e.g. "127.0.0.1:6600") on network network (e.g. "tcp"),
+// authenticates with the plaintext password password if it's not empty, and starts idling on the given subsystems.
+func DialEngine(network, addr, password string, subsystems ...string) (*Engine, error) {
+	text, err := textproto.Dial(network, addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	e, err := NewEngine(text, password, subsystems...)
+	if err != nil {
+		text.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// NewEngine creates an engine from an established connection, that has not yet received MPD's greeting.
+func NewEngine(text *textproto.Conn, password string, subsystems ...string) (*Engine, error) {
+	e := &Engine{
+		text:       text,
+		subsystems: subsystems,
+		jobs:       make(chan *job),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	e.watcher = newWatcher(e)
+
+	line, err := text.ReadLine()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !strings.HasPrefix(line, "OK MPD ") {
+		return nil, errors.WithStack(textproto.ProtocolError("no greeting"))
+	}
+	e.version = strings.TrimPrefix(line, "OK MPD ")
+
+	if password != "" {
+		if err := e.writeLine("password " + quote(password)); err != nil {
+			return nil, err
+		}
+		if err := e.readOK(); err != nil {
+			return nil, errors.Wrap(err, "authenticating")
+		}
+	}
+
+	go e.loop()
+	return e, nil
+}
+
+// Version returns the protocol version used as provided during the handshake.
+func (e *Engine) Version() string {
+	return e.version
+}
+
+// Err returns the error that stopped the engine, or nil if it is still running.
+func (e *Engine) Err() error {
+	select {
+	case <-e.done:
+		return e.err
+	default:
+		return nil
+	}
+}
+
+// The main loop of the engine. Alternates between idling and running queued jobs.
+func (e *Engine) loop() {
+	defer close(e.done)
+	for {
+		if err := e.writeLine("idle " + strings.Join(e.subsystems, " ")); err != nil {
+			e.err = err
+			return
+		}
+		idle := make(chan idleResult, 1)
+		go func() {
+			changed, err := e.readList("changed")
+			idle <- idleResult{changed: changed, err: err}
+		}()
+
+		var (
+			res     idleResult
+			pending *job
+			quit    bool
+		)
+		select {
+		case res = <-idle:
+		case pending = <-e.jobs:
+		case <-e.quit:
+			quit = true
+		}
+		if pending != nil || quit {
+			// Interrupt the idle. MPD answers with whatever changed in the meantime.
+			if err := e.writeLine("noidle"); err != nil {
+				e.err = err
+				if pending != nil {
+					pending.result <- err
+				}
+				return
+			}
+			res = <-idle
+		}
+		if res.err != nil {
+			e.err = errors.Wrap(res.err, "idling")
+			if pending != nil {
+				pending.result <- e.err
+			}
+			return
+		}
+		e.watcher.push(res.changed)
+
+		if quit {
+			e.writeLine("close")
+			e.err = ErrClosed
+			return
+		}
+		// Run every job that is waiting before going back to idle.
+		for pending != nil {
+			pending.result <- pending.run()
+			select {
+			case pending = <-e.jobs:
+			default:
+				pending = nil
+			}
+		}
+	}
+}
+
+// do runs f with exclusive access to the connection.
+func (e *Engine) do(f func() error) error {
+	j := &job{run: f, result: make(chan error, 1)}
+	select {
+	case e.jobs <- j:
+	case <-e.done:
+		return e.closedErr()
+	}
+	select {
+	case err := <-j.result:
+		return err
+	case <-e.done:
+		// The job might still have been run right before the engine stopped.
+		select {
+		case err := <-j.result:
+			return err
+		default:
+			return e.closedErr()
+		}
+	}
+}
+
+func (e *Engine) closedErr() error {
+	if e.err == nil || errors.Is(e.err, ErrClosed) {
+		return ErrClosed
+	}
+	return errors.Wrap(e.err, "mpd connection is severed")
+}
+
+// Ping sends a no-op message to MPD. It's useful for checking that the connection is still alive.
+func (e *Engine) Ping() error {
+	return e.Command("ping").OK()
+}
+
+// Close ends the idle and closes the connection to MPD.
+func (e *Engine) Close() (err error) {
+	e.closeOnce.Do(func() {
+		close(e.quit)
+		<-e.done
+		err = errors.WithStack(e.text.Close())
+	})
+	return
+}
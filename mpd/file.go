@@ -3,6 +3,7 @@ package mpd
 import (
 	"net/url"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/fhs/gompd/v2/mpd"
@@ -83,6 +84,20 @@ func (c *Client) FileFromAttrs(attr mpd.Attrs) (s File, err error) {
 	return
 }
 
+// PathFromURI turns a file:// URI pointing inside the music directory into a path relative to the library's root,
+// which is how MPD refers to songs. Other URIs are returned as-is.
+func (c *Client) PathFromURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" || c.MusicDirectory == "" {
+		return uri
+	}
+	rel, err := filepath.Rel(c.MusicDirectory, u.Path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return uri
+	}
+	return filepath.ToSlash(rel)
+}
+
 // Directory represents a directory in the library.
 type Directory struct {
 	Attrs mpd.Attrs
@@ -0,0 +1,80 @@
+package mpd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/v2/mpd"
+)
+
+func TestFileFromAttrs(t *testing.T) {
+	tests := []struct {
+		name     string
+		musicDir string
+		attrs    mpd.Attrs
+		want     File
+	}{
+		{
+			name:     "tagged",
+			musicDir: "/music",
+			attrs: mpd.Attrs{
+				"file": "Artist/Album/01 Song.flac", "Title": "Song", "Artist": "Artist", "Genre": "Rock",
+				"Date": "2001-02", "Album": "Album", "AlbumArtist": "Various", "Track": "1", "duration": "61.250",
+			},
+			want: File{
+				Title: "Song", Artist: "Artist", Genre: "Rock", Date: "2001-02", Album: "Album", AlbumArtist: "Various",
+				Track: 1, Duration: 61250 * time.Millisecond, Filepath: "file:///music/Artist/Album/01%20Song.flac",
+			},
+		},
+		{
+			name:  "untagged",
+			attrs: mpd.Attrs{"file": "song.mp3"},
+			want:  File{Title: "song.mp3", Artist: "unknown artist"},
+		},
+		{
+			name:  "stream",
+			attrs: mpd.Attrs{"file": "http://radio.example/stream", "Name": "Radio"},
+			want:  File{Title: "Radio", Artist: "unknown artist"},
+		},
+		{
+			name:  "no file",
+			attrs: mpd.Attrs{},
+			want:  File{Title: "unknown title", Artist: "unknown artist"},
+		},
+		{
+			name:  "invalid numbers",
+			attrs: mpd.Attrs{"file": "a.ogg", "Title": "A", "Artist": "B", "Track": "1/12", "duration": "?"},
+			want:  File{Title: "A", Artist: "B"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{MusicDirectory: tt.musicDir}
+			got, err := c.FileFromAttrs(tt.attrs)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			tt.want.Attrs = tt.attrs
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FileFromAttrs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathFromURI(t *testing.T) {
+	c := &Client{MusicDirectory: "/music"}
+	tests := map[string]string{
+		"file:///music/a/b.flac":      "a/b.flac",
+		"file:///music/a%20b/c.flac":  "a b/c.flac",
+		"file:///elsewhere/b.flac":    "file:///elsewhere/b.flac",
+		"http://radio.example/stream": "http://radio.example/stream",
+		"a/b.flac":                    "a/b.flac",
+	}
+	for uri, want := range tests {
+		if got := c.PathFromURI(uri); got != want {
+			t.Errorf("PathFromURI(%q) = %q, want %q", uri, got, want)
+		}
+	}
+}
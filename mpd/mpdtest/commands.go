@@ -0,0 +1,948 @@
+package mpdtest
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fhs/gompd/v2/mpd"
+)
+
+// A command handler. s.mu is held while it runs.
+type handler func(s *Server, args []string, r *response) *Ack
+
+var commands map[string]handler
+
+func init() {
+	commands = map[string]handler{
+		"ping":        noop,
+		"config":      cmdConfig,
+		"stats":       cmdStats,
+		"status":      cmdStatus,
+		"currentsong": cmdCurrentSong,
+		"urlhandlers": cmdURLHandlers,
+		"commands":    cmdCommands,
+
+		"play":     cmdPlay,
+		"playid":   cmdPlayID,
+		"pause":    cmdPause,
+		"stop":     cmdStop,
+		"next":     cmdNext,
+		"previous": cmdPrevious,
+		"seek":     cmdSeek,
+		"seekid":   cmdSeekID,
+		"seekcur":  cmdSeekCur,
+		"setvol":   cmdSetVol,
+		"volume":   cmdVolume,
+		"getvol":   cmdGetVol,
+		"random":   option(func(st *State) *bool { return &st.Random }),
+		"repeat":   option(func(st *State) *bool { return &st.Repeat }),
+		"single":   cmdSingle,
+		"consume":  option(func(st *State) *bool { return &st.Consume }),
+
+		"playlistinfo": cmdPlaylistInfo,
+		"playlistid":   cmdPlaylistID,
+		"add":          cmdAdd,
+		"addid":        cmdAddID,
+		"delete":       cmdDelete,
+		"deleteid":     cmdDeleteID,
+		"move":         cmdMove,
+		"moveid":       cmdMoveID,
+		"clear":        cmdClear,
+		"shuffle":      cmdShuffle,
+		"find":         cmdFind,
+		"listallinfo":  cmdListAllInfo,
+
+		"listplaylists":    cmdListPlaylists,
+		"listplaylistinfo": cmdListPlaylistInfo,
+		"load":             cmdLoad,
+		"save":             cmdSave,
+		"rm":               cmdRm,
+
+		"sticker": cmdSticker,
+
+		"readpicture": artwork(func(st *State) map[string][]byte { return st.Pictures }),
+		"albumart":    artwork(func(st *State) map[string][]byte { return st.AlbumArt }),
+	}
+}
+
+func noop(s *Server, args []string, r *response) *Ack { return nil }
+
+// ============================================================================
+// Argument parsing
+
+func argCount(args []string, min, max int) *Ack {
+	if len(args) < min || len(args) > max {
+		return ackf(mpd.ErrorArg, "wrong number of arguments")
+	}
+	return nil
+}
+
+func intArg(arg string) (int, *Ack) {
+	v, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, ackf(mpd.ErrorArg, "Integer expected: %s", arg)
+	}
+	return v, nil
+}
+
+func boolArg(arg string) (bool, *Ack) {
+	switch arg {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	return false, ackf(mpd.ErrorArg, "Boolean (0/1) expected: %s", arg)
+}
+
+func timeArg(arg string) (time.Duration, *Ack) {
+	v, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, ackf(mpd.ErrorArg, "Number expected: %s", arg)
+	}
+	return time.Duration(v * float64(time.Second)), nil
+}
+
+// rangeArg parses "START:END" or "POS" into [start, end), within a queue of length n.
+func rangeArg(arg string, n int) (int, int, *Ack) {
+	if i := strings.Index(arg, ":"); i >= 0 {
+		start, err := intArg(arg[:i])
+		if err != nil {
+			return 0, 0, err
+		}
+		end := n
+		if arg[i+1:] != "" {
+			if end, err = intArg(arg[i+1:]); err != nil {
+				return 0, 0, err
+			}
+		}
+		if start < 0 || end > n || start > end {
+			return 0, 0, ackf(mpd.ErrorArg, "Bad song index")
+		}
+		return start, end, nil
+	}
+	pos, err := intArg(arg)
+	if err != nil {
+		return 0, 0, err
+	}
+	if pos < 0 || pos >= n {
+		return 0, 0, ackf(mpd.ErrorArg, "Bad song index")
+	}
+	return pos, pos + 1, nil
+}
+
+func (s *Server) idArg(arg string) (int, *Ack) {
+	id, err := intArg(arg)
+	if err != nil {
+		return -1, err
+	}
+	pos := s.state.position(id)
+	if pos < 0 {
+		return -1, ackf(mpd.ErrorNoExist, "No such song")
+	}
+	return pos, nil
+}
+
+// ============================================================================
+// Status
+
+func cmdCommands(s *Server, args []string, r *response) *Ack {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		if name != "sticker" || !s.state.NoStickers {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r.attr("command", name)
+	}
+	return nil
+}
+
+func cmdConfig(s *Server, args []string, r *response) *Ack {
+	for k, v := range s.state.Config {
+		r.attr(k, v)
+	}
+	return nil
+}
+
+func cmdStats(s *Server, args []string, r *response) *Ack {
+	r.attr("artists", 0)
+	r.attr("albums", 0)
+	r.attr("songs", len(s.state.Library))
+	r.attr("uptime", 0)
+	r.attr("playtime", 0)
+	return nil
+}
+
+func cmdStatus(s *Server, args []string, r *response) *Ack {
+	st := s.state
+	r.attr("volume", st.Volume)
+	r.attr("repeat", b2i(st.Repeat))
+	r.attr("random", b2i(st.Random))
+	if st.Single && st.Oneshot {
+		r.attr("single", "oneshot")
+	} else {
+		r.attr("single", b2i(st.Single))
+	}
+	r.attr("consume", b2i(st.Consume))
+	r.attr("playlist", st.QueueVersion)
+	r.attr("playlistlength", len(st.Queue))
+	r.attr("state", st.Playback)
+	if cur, ok := st.CurrentSong(); ok {
+		r.attr("song", st.Current)
+		r.attr("songid", cur.ID)
+		if st.Playback != "stop" {
+			r.attr("elapsed", strconv.FormatFloat(st.Elapsed.Seconds(), 'f', 3, 64))
+			if d, ok := duration(cur.Song); ok {
+				r.attr("duration", strconv.FormatFloat(d.Seconds(), 'f', 3, 64))
+			}
+		}
+		if next := st.next(); next >= 0 {
+			r.attr("nextsong", next)
+			r.attr("nextsongid", st.Queue[next].ID)
+		}
+	}
+	return nil
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func cmdCurrentSong(s *Server, args []string, r *response) *Ack {
+	if cur, ok := s.state.CurrentSong(); ok {
+		writeQueued(r, cur, s.state.Current)
+	}
+	return nil
+}
+
+func cmdURLHandlers(s *Server, args []string, r *response) *Ack {
+	r.attr("handler", "http://")
+	r.attr("handler", "https://")
+	return nil
+}
+
+// writeSong writes the attributes of a song, "file" first.
+func writeSong(r *response, song Song) {
+	r.attr("file", song["file"])
+	keys := make([]string, 0, len(song))
+	for k := range song {
+		if k != "file" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		r.attr(k, song[k])
+	}
+}
+
+func writeQueued(r *response, q QueuedSong, pos int) {
+	writeSong(r, q.Song)
+	r.attr("Pos", pos)
+	r.attr("Id", q.ID)
+}
+
+// ============================================================================
+// Playback
+
+func cmdPlay(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 0, 1); err != nil {
+		return err
+	}
+	pos := s.state.Current
+	if len(args) == 1 {
+		var err *Ack
+		if pos, err = intArg(args[0]); err != nil {
+			return err
+		}
+	}
+	return s.play(pos)
+}
+
+func cmdPlayID(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 0, 1); err != nil {
+		return err
+	}
+	pos := s.state.Current
+	if len(args) == 1 {
+		var err *Ack
+		if pos, err = s.idArg(args[0]); err != nil {
+			return err
+		}
+	}
+	return s.play(pos)
+}
+
+func (s *Server) play(pos int) *Ack {
+	st := s.state
+	if pos < 0 {
+		if len(st.Queue) == 0 {
+			return nil
+		}
+		pos = 0
+	}
+	if pos >= len(st.Queue) {
+		return ackf(mpd.ErrorArg, "Bad song index")
+	}
+	if pos == st.Current && st.Playback == "pause" {
+		st.Playback = "play"
+	} else {
+		st.Play(pos)
+	}
+	s.notify("player")
+	return nil
+}
+
+func cmdPause(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 0, 1); err != nil {
+		return err
+	}
+	st := s.state
+	if st.Playback == "stop" {
+		return nil
+	}
+	pause := st.Playback == "play"
+	if len(args) == 1 {
+		var err *Ack
+		if pause, err = boolArg(args[0]); err != nil {
+			return err
+		}
+	}
+	if pause {
+		st.Playback = "pause"
+	} else {
+		st.Playback = "play"
+	}
+	s.notify("player")
+	return nil
+}
+
+func cmdStop(s *Server, args []string, r *response) *Ack {
+	s.state.Playback = "stop"
+	s.state.Elapsed = 0
+	s.notify("player")
+	return nil
+}
+
+func cmdNext(s *Server, args []string, r *response) *Ack {
+	st := s.state
+	if st.Playback == "stop" {
+		return nil
+	}
+	st.advance(st.next())
+	s.notify("player")
+	if st.Consume {
+		s.notify("playlist")
+	}
+	return nil
+}
+
+func cmdPrevious(s *Server, args []string, r *response) *Ack {
+	st := s.state
+	if st.Playback == "stop" {
+		return nil
+	}
+	switch {
+	case st.Current > 0:
+		st.Play(st.Current - 1)
+	case st.Repeat && len(st.Queue) > 0:
+		st.Play(len(st.Queue) - 1)
+	default:
+		st.Play(st.Current)
+	}
+	s.notify("player")
+	return nil
+}
+
+func (s *Server) seek(pos int, t time.Duration) *Ack {
+	st := s.state
+	if pos < 0 || pos >= len(st.Queue) {
+		return ackf(mpd.ErrorArg, "Bad song index")
+	}
+	if d, ok := duration(st.Queue[pos].Song); !ok || d == 0 {
+		return ackf(mpd.ErrorSystem, "Not seekable")
+	} else if t > d {
+		return ackf(mpd.ErrorArg, "Bad time")
+	}
+	if t < 0 {
+		t = 0
+	}
+	if pos != st.Current || st.Playback == "stop" {
+		st.Play(pos)
+	}
+	st.Elapsed = t
+	s.notify("player")
+	return nil
+}
+
+func cmdSeek(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 2, 2); err != nil {
+		return err
+	}
+	pos, err := intArg(args[0])
+	if err != nil {
+		return err
+	}
+	t, err := timeArg(args[1])
+	if err != nil {
+		return err
+	}
+	return s.seek(pos, t)
+}
+
+func cmdSeekID(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 2, 2); err != nil {
+		return err
+	}
+	pos, err := s.idArg(args[0])
+	if err != nil {
+		return err
+	}
+	t, err := timeArg(args[1])
+	if err != nil {
+		return err
+	}
+	return s.seek(pos, t)
+}
+
+func cmdSeekCur(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 1, 1); err != nil {
+		return err
+	}
+	if s.state.Playback == "stop" {
+		return ackf(mpd.ErrorPlayerSync, "Not playing")
+	}
+	t, err := timeArg(args[0])
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-") {
+		t += s.state.Elapsed
+	}
+	return s.seek(s.state.Current, t)
+}
+
+func (s *Server) setVolume(v int) *Ack {
+	if s.state.Volume < 0 {
+		return ackf(mpd.ErrorSystem, "problems setting volume")
+	}
+	if v < 0 || v > 100 {
+		return ackf(mpd.ErrorArg, "Invalid volume value")
+	}
+	s.state.Volume = v
+	s.notify("mixer")
+	return nil
+}
+
+func cmdSetVol(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 1, 1); err != nil {
+		return err
+	}
+	v, err := intArg(args[0])
+	if err != nil {
+		return err
+	}
+	return s.setVolume(v)
+}
+
+func cmdVolume(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 1, 1); err != nil {
+		return err
+	}
+	d, err := intArg(args[0])
+	if err != nil {
+		return err
+	}
+	v := s.state.Volume + d
+	if v < 0 {
+		v = 0
+	} else if v > 100 {
+		v = 100
+	}
+	return s.setVolume(v)
+}
+
+func cmdGetVol(s *Server, args []string, r *response) *Ack {
+	if s.state.Volume >= 0 {
+		r.attr("volume", s.state.Volume)
+	}
+	return nil
+}
+
+// option handles a boolean playback option.
+func option(field func(st *State) *bool) handler {
+	return func(s *Server, args []string, r *response) *Ack {
+		if err := argCount(args, 1, 1); err != nil {
+			return err
+		}
+		v, err := boolArg(args[0])
+		if err != nil {
+			return err
+		}
+		*field(s.state) = v
+		s.notify("options")
+		return nil
+	}
+}
+
+// cmdSingle handles single mode, which is a boolean option, or "oneshot".
+func cmdSingle(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 1, 1); err != nil {
+		return err
+	}
+	st := s.state
+	if args[0] == "oneshot" {
+		st.Single, st.Oneshot = true, true
+	} else {
+		v, err := boolArg(args[0])
+		if err != nil {
+			return err
+		}
+		st.Single, st.Oneshot = v, false
+	}
+	s.notify("options")
+	return nil
+}
+
+// ============================================================================
+// Queue
+
+func cmdPlaylistInfo(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 0, 1); err != nil {
+		return err
+	}
+	start, end := 0, len(s.state.Queue)
+	if len(args) == 1 {
+		var err *Ack
+		if start, end, err = rangeArg(args[0], len(s.state.Queue)); err != nil {
+			return err
+		}
+	}
+	for pos := start; pos < end; pos++ {
+		writeQueued(r, s.state.Queue[pos], pos)
+	}
+	return nil
+}
+
+func cmdPlaylistID(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 0, 1); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return cmdPlaylistInfo(s, nil, r)
+	}
+	pos, err := s.idArg(args[0])
+	if err != nil {
+		return err
+	}
+	writeQueued(r, s.state.Queue[pos], pos)
+	return nil
+}
+
+// lookup finds a song in the library. Songs that are not in the library (e.g. streams) are made up.
+func (s *Server) lookup(uri string) (Song, *Ack) {
+	for _, song := range s.state.Library {
+		if song["file"] == uri {
+			return song, nil
+		}
+	}
+	if strings.Contains(uri, "://") {
+		return Song{"file": uri}, nil
+	}
+	return nil, ackf(mpd.ErrorNoExist, "No such song")
+}
+
+func cmdAdd(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 1, 1); err != nil {
+		return err
+	}
+	song, err := s.lookup(args[0])
+	if err != nil {
+		return err
+	}
+	s.state.Enqueue(song)
+	s.notify("playlist")
+	return nil
+}
+
+func cmdAddID(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 1, 2); err != nil {
+		return err
+	}
+	song, err := s.lookup(args[0])
+	if err != nil {
+		return err
+	}
+	pos := len(s.state.Queue)
+	if len(args) == 2 {
+		if pos, err = intArg(args[1]); err != nil {
+			return err
+		}
+		if pos < 0 || pos > len(s.state.Queue) {
+			return ackf(mpd.ErrorArg, "Bad song index")
+		}
+	}
+	r.attr("Id", s.state.insert(song, pos))
+	s.notify("playlist")
+	return nil
+}
+
+func cmdDelete(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 1, 1); err != nil {
+		return err
+	}
+	start, end, err := rangeArg(args[0], len(s.state.Queue))
+	if err != nil {
+		return err
+	}
+	for pos := end - 1; pos >= start; pos-- {
+		s.state.remove(pos)
+	}
+	s.notify("playlist")
+	return nil
+}
+
+func cmdDeleteID(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 1, 1); err != nil {
+		return err
+	}
+	pos, err := s.idArg(args[0])
+	if err != nil {
+		return err
+	}
+	s.state.remove(pos)
+	s.notify("playlist")
+	return nil
+}
+
+func (s *Server) move(from, to int) *Ack {
+	st := s.state
+	if to < 0 || to >= len(st.Queue) {
+		return ackf(mpd.ErrorArg, "Bad song index")
+	}
+	q := st.Queue[from]
+	current := -1
+	if cur, ok := st.CurrentSong(); ok {
+		current = cur.ID
+	}
+	st.Queue = append(st.Queue[:from], st.Queue[from+1:]...)
+	st.Queue = append(st.Queue, QueuedSong{})
+	copy(st.Queue[to+1:], st.Queue[to:])
+	st.Queue[to] = q
+	if current >= 0 {
+		st.Current = st.position(current)
+	}
+	st.QueueVersion++
+	s.notify("playlist")
+	return nil
+}
+
+func cmdMove(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 2, 2); err != nil {
+		return err
+	}
+	from, _, err := rangeArg(args[0], len(s.state.Queue))
+	if err != nil {
+		return err
+	}
+	to, err := intArg(args[1])
+	if err != nil {
+		return err
+	}
+	return s.move(from, to)
+}
+
+func cmdMoveID(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 2, 2); err != nil {
+		return err
+	}
+	from, err := s.idArg(args[0])
+	if err != nil {
+		return err
+	}
+	to, err := intArg(args[1])
+	if err != nil {
+		return err
+	}
+	return s.move(from, to)
+}
+
+func cmdClear(s *Server, args []string, r *response) *Ack {
+	s.state.SetQueue()
+	s.state.QueueVersion++
+	s.notify("playlist", "player")
+	return nil
+}
+
+func cmdShuffle(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 0, 1); err != nil {
+		return err
+	}
+	st := s.state
+	start, end := 0, len(st.Queue)
+	if len(args) == 1 {
+		var err *Ack
+		if start, end, err = rangeArg(args[0], len(st.Queue)); err != nil {
+			return err
+		}
+	}
+	current := -1
+	if cur, ok := st.CurrentSong(); ok {
+		current = cur.ID
+	}
+	part := st.Queue[start:end]
+	rand.Shuffle(len(part), func(i, j int) { part[i], part[j] = part[j], part[i] })
+	if current >= 0 {
+		st.Current = st.position(current)
+	}
+	st.QueueVersion++
+	s.notify("playlist")
+	return nil
+}
+
+// cmdListAllInfo lists the songs of the library under a directory. Directories themselves are left out.
+func cmdListAllInfo(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 0, 1); err != nil {
+		return err
+	}
+	dir := ""
+	if len(args) == 1 {
+		dir = strings.Trim(args[0], "/")
+	}
+	for _, song := range s.state.Library {
+		if dir == "" || song["file"] == dir || strings.HasPrefix(song["file"], dir+"/") {
+			writeSong(r, song)
+		}
+	}
+	return nil
+}
+
+// cmdFind implements exact matching on "TAG VALUE" pairs. Filter expressions are not supported.
+func cmdFind(s *Server, args []string, r *response) *Ack {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return ackf(mpd.ErrorArg, "Incorrect number of filter arguments")
+	}
+	for _, song := range s.state.Library {
+		match := true
+		for i := 0; i < len(args); i += 2 {
+			tag := args[i]
+			if strings.EqualFold(tag, "any") {
+				found := false
+				for _, v := range song {
+					found = found || v == args[i+1]
+				}
+				match = match && found
+				continue
+			}
+			value, ok := "", false
+			for k, v := range song {
+				if strings.EqualFold(k, tag) {
+					value, ok = v, true
+				}
+			}
+			match = match && ok && value == args[i+1]
+		}
+		if match {
+			writeSong(r, song)
+		}
+	}
+	return nil
+}
+
+// ============================================================================
+// Stored playlists
+
+func cmdListPlaylists(s *Server, args []string, r *response) *Ack {
+	names := make([]string, 0, len(s.state.Playlists))
+	for name := range s.state.Playlists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r.attr("playlist", name)
+	}
+	return nil
+}
+
+func (s *Server) playlist(name string) ([]string, *Ack) {
+	uris, ok := s.state.Playlists[name]
+	if !ok {
+		return nil, ackf(mpd.ErrorNoExist, "No such playlist")
+	}
+	return uris, nil
+}
+
+func cmdListPlaylistInfo(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 1, 1); err != nil {
+		return err
+	}
+	uris, err := s.playlist(args[0])
+	if err != nil {
+		return err
+	}
+	for _, uri := range uris {
+		song, err := s.lookup(uri)
+		if err != nil {
+			song = Song{"file": uri}
+		}
+		writeSong(r, song)
+	}
+	return nil
+}
+
+func cmdLoad(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 1, 1); err != nil {
+		return err
+	}
+	uris, err := s.playlist(args[0])
+	if err != nil {
+		return err
+	}
+	for _, uri := range uris {
+		song, err := s.lookup(uri)
+		if err != nil {
+			return err
+		}
+		s.state.Enqueue(song)
+	}
+	s.notify("playlist")
+	return nil
+}
+
+func cmdSave(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 1, 1); err != nil {
+		return err
+	}
+	if _, ok := s.state.Playlists[args[0]]; ok {
+		return ackf(mpd.ErrorExist, "Playlist already exists")
+	}
+	uris := make([]string, len(s.state.Queue))
+	for i, q := range s.state.Queue {
+		uris[i] = q.Song["file"]
+	}
+	s.state.Playlists[args[0]] = uris
+	s.notify("stored_playlist")
+	return nil
+}
+
+func cmdRm(s *Server, args []string, r *response) *Ack {
+	if err := argCount(args, 1, 1); err != nil {
+		return err
+	}
+	if _, err := s.playlist(args[0]); err != nil {
+		return err
+	}
+	delete(s.state.Playlists, args[0])
+	s.notify("stored_playlist")
+	return nil
+}
+
+// ============================================================================
+// Stickers
+
+func cmdSticker(s *Server, args []string, r *response) *Ack {
+	if len(args) < 3 || args[1] != "song" {
+		return ackf(mpd.ErrorArg, "bad request")
+	}
+	op, uri := args[0], args[2]
+	stickers := s.state.Stickers
+	switch op {
+	case "get":
+		if len(args) != 4 {
+			return ackf(mpd.ErrorArg, "bad request")
+		}
+		v, ok := stickers[uri][args[3]]
+		if !ok {
+			return ackf(mpd.ErrorNoExist, "no such sticker")
+		}
+		r.attr("sticker", args[3]+"="+v)
+	case "set":
+		if len(args) != 5 {
+			return ackf(mpd.ErrorArg, "bad request")
+		}
+		if _, err := s.lookup(uri); err != nil {
+			return err
+		}
+		if stickers[uri] == nil {
+			stickers[uri] = make(map[string]string)
+		}
+		stickers[uri][args[3]] = args[4]
+		s.notify("sticker")
+	case "delete":
+		if len(args) == 3 {
+			delete(stickers, uri)
+		} else if _, ok := stickers[uri][args[3]]; ok {
+			delete(stickers[uri], args[3])
+		} else {
+			return ackf(mpd.ErrorNoExist, "no such sticker")
+		}
+		s.notify("sticker")
+	case "list":
+		names := make([]string, 0, len(stickers[uri]))
+		for name := range stickers[uri] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			r.attr("sticker", name+"="+stickers[uri][name])
+		}
+	case "find":
+		if len(args) != 4 {
+			return ackf(mpd.ErrorArg, "bad request")
+		}
+		uris := make([]string, 0, len(stickers))
+		for file := range stickers {
+			uris = append(uris, file)
+		}
+		sort.Strings(uris)
+		for _, file := range uris {
+			if v, ok := stickers[file][args[3]]; ok && (uri == "" || strings.HasPrefix(file, uri)) {
+				r.attr("file", file)
+				r.attr("sticker", args[3]+"="+v)
+			}
+		}
+	default:
+		return ackf(mpd.ErrorArg, "bad request")
+	}
+	return nil
+}
+
+// ============================================================================
+// Artwork
+
+// artworkChunk is the size of the binary chunks sent by the server, small enough to exercise chunked reads.
+const artworkChunk = 64
+
+func artwork(images func(st *State) map[string][]byte) handler {
+	return func(s *Server, args []string, r *response) *Ack {
+		if err := argCount(args, 2, 2); err != nil {
+			return err
+		}
+		offset, err := intArg(args[1])
+		if err != nil {
+			return err
+		}
+		data, ok := images(s.state)[args[0]]
+		if !ok {
+			return ackf(mpd.ErrorNoExist, "No file exists")
+		}
+		if offset > len(data) {
+			return ackf(mpd.ErrorArg, "Bad file offset")
+		}
+		end := offset + artworkChunk
+		if end > len(data) {
+			end = len(data)
+		}
+		r.binary(len(data), data[offset:end])
+		return nil
+	}
+}
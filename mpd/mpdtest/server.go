@@ -0,0 +1,442 @@
+// Package mpdtest implements an in-memory MPD server, for testing MPD clients.
+//
+// The server speaks enough of the MPD protocol to drive the `mpd` package:
+// status and queue queries, playback control, idle/noidle, command lists,
+// binary responses (readpicture/albumart), authentication, stickers and stored playlists.
+// Its state can be changed by scripts (which notify idling clients), and errors can be injected.
+//
+// Time does not pass on the server: the elapsed time only changes when seeking or through scripts.
+package mpdtest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/fhs/gompd/v2/mpd"
+)
+
+// Version is the protocol version announced by the server.
+const Version = "0.23.5"
+
+// Server is an in-memory MPD server listening on a local TCP port.
+type Server struct {
+	// Password, if not empty, must be sent by clients before any other command.
+	Password string
+
+	l net.Listener
+
+	mu       sync.Mutex
+	state    *State
+	conns    map[*conn]struct{}
+	failures map[string][]*Ack
+	log      []string
+
+	wg sync.WaitGroup
+}
+
+// NewServer starts a new server with an empty queue and library.
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		l:        l,
+		state:    newState(),
+		conns:    make(map[*conn]struct{}),
+		failures: make(map[string][]*Ack),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Network returns the network the server listens on, as expected by `net.Dial`.
+func (s *Server) Network() string {
+	return "tcp"
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Close stops the server and disconnects all clients.
+func (s *Server) Close() error {
+	err := s.l.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.nc.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// Disconnect drops all current client connections, simulating a server restart or a network failure.
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.nc.Close()
+	}
+}
+
+// Modify changes the server's state with f, then notifies idling clients that the given subsystems changed.
+func (s *Server) Modify(f func(st *State), subsystems ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s.state)
+	s.notify(subsystems...)
+}
+
+// Inspect runs f with the server's state, which must not be modified.
+func (s *Server) Inspect(f func(st *State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s.state)
+}
+
+// Fail makes the next execution of the command fail with the given error.
+// Failures for the same command are queued.
+func (s *Server) Fail(command string, code mpd.ErrorCode, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[command] = append(s.failures[command], &Ack{Code: code, Message: message})
+}
+
+// Commands returns the command lines received so far, excluding idle and noidle.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.log...)
+}
+
+// ResetCommands clears the log of received commands.
+func (s *Server) ResetCommands() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = nil
+}
+
+// notify records changes of the given subsystems for all clients. s.mu must be held.
+func (s *Server) notify(subsystems ...string) {
+	if len(subsystems) == 0 {
+		return
+	}
+	for c := range s.conns {
+		for _, name := range subsystems {
+			c.pending[name] = struct{}{}
+		}
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{
+			s:       s,
+			nc:      nc,
+			w:       bufio.NewWriter(nc),
+			pending: make(map[string]struct{}),
+			wake:    make(chan struct{}, 1),
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+			nc.Close()
+		}()
+	}
+}
+
+// Ack is an error returned to the client.
+type Ack struct {
+	Code    mpd.ErrorCode
+	Message string
+
+	index   int
+	command string
+}
+
+func (a *Ack) Error() string {
+	return fmt.Sprintf("ACK [%d@%d] {%s} %s", a.Code, a.index, a.command, a.Message)
+}
+
+// ackf creates an error.
+func ackf(code mpd.ErrorCode, format string, args ...interface{}) *Ack {
+	return &Ack{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// A client connection.
+type conn struct {
+	s  *Server
+	nc net.Conn
+	w  *bufio.Writer
+
+	authenticated bool
+
+	pending map[string]struct{} // Changed subsystems not yet reported. Guarded by s.mu.
+	wake    chan struct{}
+}
+
+func (c *conn) serve() {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		r := bufio.NewReader(c.nc)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- strings.TrimSuffix(line, "\n")
+		}
+	}()
+
+	c.w.WriteString("OK MPD " + Version + "\n")
+	c.w.Flush()
+	for line := range lines {
+		name, args, err := tokenize(line)
+		switch {
+		case err != nil:
+			c.w.WriteString(err.Error() + "\n")
+		case name == "close":
+			return
+		case name == "idle":
+			if !c.idle(args, lines) {
+				return
+			}
+		case name == "noidle":
+			// Not idling: ignored.
+		case name == "command_list_begin" || name == "command_list_ok_begin":
+			if !c.commandList(name == "command_list_ok_begin", lines) {
+				return
+			}
+		default:
+			var out strings.Builder
+			if err := c.run(line, &out, 0); err != nil {
+				c.w.WriteString(out.String())
+				c.w.WriteString(err.Error() + "\n")
+			} else {
+				c.w.WriteString(out.String() + "OK\n")
+			}
+		}
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// idle waits for changes in the given subsystems (or any subsystem), or a noidle.
+// Returns false if the connection is closed.
+func (c *conn) idle(subsystems []string, lines <-chan string) bool {
+	for {
+		if changed := c.takePending(subsystems); len(changed) > 0 {
+			c.writeChanged(changed)
+			return true
+		}
+		select {
+		case <-c.wake:
+		case line, ok := <-lines:
+			if !ok {
+				return false
+			}
+			if line != "noidle" {
+				// MPD disconnects clients that send anything else while idling.
+				return false
+			}
+			c.writeChanged(c.takePending(subsystems))
+			return true
+		}
+	}
+}
+
+func (c *conn) writeChanged(changed []string) {
+	for _, name := range changed {
+		c.w.WriteString("changed: " + name + "\n")
+	}
+	c.w.WriteString("OK\n")
+}
+
+// takePending returns and clears the pending changes among subsystems (or all, if empty).
+func (c *conn) takePending(subsystems []string) []string {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	var changed []string
+	for _, name := range allSubsystems {
+		if _, ok := c.pending[name]; !ok {
+			continue
+		}
+		if len(subsystems) > 0 && !contains(subsystems, name) {
+			continue
+		}
+		delete(c.pending, name)
+		changed = append(changed, name)
+	}
+	return changed
+}
+
+var allSubsystems = []string{
+	"database", "update", "stored_playlist", "playlist", "player", "mixer",
+	"output", "options", "partition", "sticker", "subscription", "message",
+	"neighbor", "mount",
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// commandList reads commands until command_list_end, then runs them in order.
+// Returns false if the connection is closed.
+func (c *conn) commandList(ok bool, lines <-chan string) bool {
+	var cmds []string
+	for line := range lines {
+		if line == "command_list_end" {
+			var out strings.Builder
+			for i, cmd := range cmds {
+				if err := c.run(cmd, &out, i); err != nil {
+					c.w.WriteString(out.String())
+					c.w.WriteString(err.Error() + "\n")
+					return true
+				}
+				if ok {
+					out.WriteString("list_OK\n")
+				}
+			}
+			c.w.WriteString(out.String() + "OK\n")
+			return true
+		}
+		cmds = append(cmds, line)
+	}
+	return false
+}
+
+// run executes a single command line, writing its response (without the final OK) to out.
+func (c *conn) run(line string, out *strings.Builder, index int) *Ack {
+	name, args, err := tokenize(line)
+	if err != nil {
+		err.index = index
+		return err
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = append(s.log, line)
+
+	fail := func(err *Ack) *Ack {
+		err.index = index
+		err.command = name
+		return err
+	}
+	if failures := s.failures[name]; len(failures) > 0 {
+		s.failures[name] = failures[1:]
+		return fail(failures[0])
+	}
+	if s.Password != "" && !c.authenticated && name != "password" && name != "ping" {
+		return fail(ackf(mpd.ErrorPermission, "you don't have permission for \"%s\"", name))
+	}
+	if name == "password" {
+		if len(args) != 1 || args[0] != s.Password {
+			return fail(ackf(mpd.ErrorPassword, "incorrect password"))
+		}
+		c.authenticated = true
+		return nil
+	}
+	h, ok := commands[name]
+	if !ok || (name == "sticker" && s.state.NoStickers) {
+		return fail(ackf(mpd.ErrorUnknown, "unknown command \"%s\"", name))
+	}
+	r := &response{}
+	if err := h(s, args, r); err != nil {
+		return fail(err)
+	}
+	out.WriteString(r.String())
+	return nil
+}
+
+// tokenize splits a command line into the command name and its arguments, handling quotes.
+func tokenize(line string) (string, []string, *Ack) {
+	var (
+		tokens []string
+		cur    strings.Builder
+		inTok  bool
+		quoted bool
+	)
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case quoted && ch == '\\' && i+1 < len(line):
+			i++
+			cur.WriteByte(line[i])
+		case quoted && ch == '"':
+			quoted = false
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+			inTok = false
+		case quoted:
+			cur.WriteByte(ch)
+		case ch == '"' && !inTok:
+			quoted, inTok = true, true
+		case ch == ' ' || ch == '\t':
+			if inTok {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+				inTok = false
+			}
+		default:
+			inTok = true
+			cur.WriteByte(ch)
+		}
+	}
+	if quoted {
+		return "", nil, &Ack{Code: mpd.ErrorArg, Message: "Missing closing '\"'"}
+	}
+	if inTok {
+		tokens = append(tokens, cur.String())
+	}
+	if len(tokens) == 0 {
+		return "", nil, &Ack{Code: mpd.ErrorUnknown, Message: "No command given"}
+	}
+	return tokens[0], tokens[1:], nil
+}
+
+// response is the body of a response.
+type response struct {
+	strings.Builder
+}
+
+// attr writes a "key: value" line.
+func (r *response) attr(key string, value interface{}) {
+	fmt.Fprintf(r, "%s: %v\n", key, value)
+}
+
+// binary writes a binary chunk of data, that is part of a total of size bytes.
+func (r *response) binary(size int, data []byte) {
+	r.attr("size", size)
+	r.attr("binary", len(data))
+	r.Write(data)
+	r.WriteByte('\n')
+}
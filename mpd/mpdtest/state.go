@@ -0,0 +1,190 @@
+package mpdtest
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/fhs/gompd/v2/mpd"
+)
+
+// Song is a song in the library or the queue, as the attributes MPD reports for it.
+// It must have a "file" attribute. A "duration" attribute (in seconds) makes it seekable.
+type Song = mpd.Attrs
+
+// QueuedSong is a song in the queue.
+type QueuedSong struct {
+	ID   int
+	Song Song
+}
+
+// State is the state of the fake server.
+type State struct {
+	Library []Song
+	Queue   []QueuedSong
+	// The position of the current song in the queue, -1 if there is none.
+	Current int
+	// "play", "pause" or "stop".
+	Playback string
+	Elapsed  time.Duration
+	// The volume, -1 if there is no mixer.
+	Volume                          int
+	Repeat, Random, Single, Consume bool
+	// Whether single mode is "oneshot": it turns off once the current song ends.
+	Oneshot bool
+	// The queue version, increased on every change of the queue.
+	QueueVersion int
+
+	Config    mpd.Attrs
+	Stickers  map[string]map[string]string // URI -> name -> value
+	Playlists map[string][]string          // Stored playlists, name -> URIs
+	// Artwork returned by readpicture (embedded pictures) and albumart (cover files), by song URI.
+	Pictures map[string][]byte
+	AlbumArt map[string][]byte
+	// Whether MPD has no sticker database, and so no sticker command.
+	NoStickers bool
+
+	nextID int
+}
+
+func newState() *State {
+	return &State{
+		Current:      -1,
+		Playback:     "stop",
+		Volume:       100,
+		QueueVersion: 1,
+		Config:       mpd.Attrs{},
+		Stickers:     make(map[string]map[string]string),
+		Playlists:    make(map[string][]string),
+		Pictures:     make(map[string][]byte),
+		AlbumArt:     make(map[string][]byte),
+	}
+}
+
+// SetQueue replaces the queue with songs. Playback is stopped.
+func (st *State) SetQueue(songs ...Song) {
+	st.Queue = nil
+	st.Current = -1
+	st.Playback = "stop"
+	st.Elapsed = 0
+	for _, song := range songs {
+		st.Enqueue(song)
+	}
+}
+
+// Enqueue adds song at the end of the queue, and returns its ID.
+func (st *State) Enqueue(song Song) int {
+	return st.insert(song, len(st.Queue))
+}
+
+func (st *State) insert(song Song, pos int) int {
+	st.nextID++
+	q := QueuedSong{ID: st.nextID, Song: song}
+	st.Queue = append(st.Queue, QueuedSong{})
+	copy(st.Queue[pos+1:], st.Queue[pos:])
+	st.Queue[pos] = q
+	if st.Current >= pos {
+		st.Current++
+	}
+	st.QueueVersion++
+	return q.ID
+}
+
+// Play starts playing the song at queue position pos, from the start.
+func (st *State) Play(pos int) {
+	st.Current = pos
+	st.Playback = "play"
+	st.Elapsed = 0
+}
+
+// Finish ends the current song, as if it had played to its end. The next song plays,
+// unless single mode stops playback (or repeats the song, with repeat on).
+func (st *State) Finish() {
+	if st.Playback == "stop" {
+		return
+	}
+	next := st.next()
+	if st.Single && !st.Repeat {
+		next = -1
+	}
+	if st.Oneshot {
+		st.Single, st.Oneshot = false, false
+	}
+	st.advance(next)
+}
+
+// advance moves on from the current song to the one at position next, or stops playback if next is -1.
+// In consume mode, the current song is removed.
+func (st *State) advance(next int) {
+	if st.Consume {
+		st.remove(st.Current)
+		if next > st.Current {
+			next--
+		}
+	}
+	if next < 0 {
+		st.Playback = "stop"
+		st.Elapsed = 0
+	} else {
+		st.Play(next)
+	}
+}
+
+// CurrentSong returns the current song, if any.
+func (st *State) CurrentSong() (QueuedSong, bool) {
+	if st.Current < 0 || st.Current >= len(st.Queue) {
+		return QueuedSong{}, false
+	}
+	return st.Queue[st.Current], true
+}
+
+// position returns the queue position of the song with the given ID, or -1.
+func (st *State) position(id int) int {
+	for pos, q := range st.Queue {
+		if q.ID == id {
+			return pos
+		}
+	}
+	return -1
+}
+
+// next returns the position of the song after the current one, or -1.
+// Random is not taken into account: the fake server always plays in queue order.
+func (st *State) next() int {
+	if st.Current < 0 {
+		return -1
+	}
+	switch {
+	case st.Single && st.Repeat:
+		return st.Current
+	case st.Current+1 < len(st.Queue):
+		return st.Current + 1
+	case st.Repeat && len(st.Queue) > 0:
+		return 0
+	}
+	return -1
+}
+
+// remove deletes the song at queue position pos.
+func (st *State) remove(pos int) {
+	st.Queue = append(st.Queue[:pos], st.Queue[pos+1:]...)
+	switch {
+	case st.Current == pos:
+		if pos >= len(st.Queue) {
+			st.Current = -1
+			st.Playback = "stop"
+		}
+		st.Elapsed = 0
+	case st.Current > pos:
+		st.Current--
+	}
+	st.QueueVersion++
+}
+
+// duration returns the duration of a song, as reported in its "duration" attribute.
+func duration(song Song) (time.Duration, bool) {
+	d, err := strconv.ParseFloat(song["duration"], 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(d * float64(time.Second)), true
+}
@@ -0,0 +1,134 @@
+package mpd
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements the playback-related commands that we use.
+// See https://mpd.readthedocs.io/en/latest/protocol.html#controlling-playback
+// and https://mpd.readthedocs.io/en/latest/protocol.html#playback-options
+
+// boolArg formats a boolean as MPD expects it.
+func boolArg(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Next plays the next song in the queue.
+func (c *Client) Next() error {
+	return c.Command("next").OK()
+}
+
+// Previous plays the previous song in the queue.
+func (c *Client) Previous() error {
+	return c.Command("previous").OK()
+}
+
+// Pause pauses playback if pause is true; resumes playback otherwise.
+func (c *Client) Pause(pause bool) error {
+	return c.Command("pause %d", boolArg(pause)).OK()
+}
+
+// Play starts playing the song at queue position pos.
+// If pos is negative, starts playing at the current position in the queue.
+func (c *Client) Play(pos int) error {
+	if pos < 0 {
+		return c.Command("play").OK()
+	}
+	return c.Command("play %d", pos).OK()
+}
+
+// PlayID plays the song identified by id.
+// If id is negative, starts playing at the current position in the queue.
+func (c *Client) PlayID(id int) error {
+	if id < 0 {
+		return c.Command("playid").OK()
+	}
+	return c.Command("playid %d", id).OK()
+}
+
+// Stop stops playback.
+func (c *Client) Stop() error {
+	return c.Command("stop").OK()
+}
+
+// SeekID seeks to the position pos of the song identified by id, to the millisecond.
+func (c *Client) SeekID(id int, pos time.Duration) error {
+	return c.Command("seekid %d %.3f", id, pos.Seconds()).OK()
+}
+
+// SetVolume sets the volume to volume. The range of volume is 0-100.
+func (c *Client) SetVolume(volume int) error {
+	return c.Command("setvol %d", volume).OK()
+}
+
+// Random enables random playback, if random is true, disables it otherwise.
+func (c *Client) Random(random bool) error {
+	return c.Command("random %d", boolArg(random)).OK()
+}
+
+// Repeat enables repeat mode, if repeat is true, disables it otherwise.
+func (c *Client) Repeat(repeat bool) error {
+	return c.Command("repeat %d", boolArg(repeat)).OK()
+}
+
+// Single enables single song mode, if single is true, disables it otherwise.
+func (c *Client) Single(single bool) error {
+	return c.Command("single %d", boolArg(single)).OK()
+}
+
+// Consume enables consume mode, if consume is true, disables it otherwise.
+func (c *Client) Consume(consume bool) error {
+	return c.Command("consume %d", boolArg(consume)).OK()
+}
+
+// AddID adds the song at uri to the queue, at position pos (or at the end, if pos is negative),
+// and returns its song ID.
+func (c *Client) AddID(uri string, pos int) (int, error) {
+	var cmd *Command
+	if pos < 0 {
+		cmd = c.Command("addid %s", uri)
+	} else {
+		cmd = c.Command("addid %s %d", uri, pos)
+	}
+	attrs, err := cmd.Attrs()
+	if err != nil {
+		return -1, err
+	}
+	id, err := strconv.Atoi(attrs["Id"])
+	if err != nil {
+		return -1, errors.Wrap(err, "parsing song ID")
+	}
+	return id, nil
+}
+
+// Delete removes the songs from queue position start (included) to end (excluded).
+func (c *Client) Delete(start, end int) error {
+	return c.Command("delete %d:%d", start, end).OK()
+}
+
+// AlbumArt retrieves an album artwork image for a song with the given URI using MPD's albumart command.
+func (c *Client) AlbumArt(uri string) ([]byte, error) {
+	offset := 0
+	var data []byte
+	for {
+		// Read the data in chunks
+		chunk, size, err := c.Command("albumart %s %d", uri, offset).Binary()
+		if err != nil {
+			return nil, err
+		}
+
+		// Accumulate the data
+		data = append(data, chunk...)
+		offset = len(data)
+		if offset >= size {
+			break
+		}
+	}
+	return data, nil
+}
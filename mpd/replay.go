@@ -0,0 +1,212 @@
+package mpd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ReplayServer serves a recorded transcript as a fake MPD server, on a local TCP port.
+//
+// Every connection replays the transcript from the start. A request is answered with the response
+// recorded for the next occurrence of the same request; requests that do not occur again are answered
+// with their last recorded response, so that the final state of the transcript stays observable.
+// Once the transcript's events are all played, `idle` waits until it is cancelled.
+type ReplayServer struct {
+	greeting  string
+	exchanges []exchange
+
+	l  net.Listener
+	wg sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// An exchange is a request (a single command, or a whole command list), and the recorded response.
+type exchange struct {
+	request  string
+	response string
+}
+
+// NewReplayServer reads the transcript from r, and starts serving it.
+func NewReplayServer(r io.Reader) (*ReplayServer, error) {
+	entries, err := readTranscript(r)
+	if err != nil {
+		return nil, err
+	}
+	s := &ReplayServer{conns: make(map[net.Conn]struct{})}
+	s.greeting, s.exchanges = exchangesFromTranscript(entries)
+	if !strings.HasPrefix(s.greeting, "OK MPD ") {
+		return nil, errors.New("transcript does not start with MPD's greeting")
+	}
+
+	if s.l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// exchangesFromTranscript splits the transcript into the greeting and the exchanges that follow it.
+func exchangesFromTranscript(entries []transcriptEntry) (greeting string, exchanges []exchange) {
+	var (
+		request  []string
+		response strings.Builder
+		inList   bool
+	)
+	flush := func() {
+		if request == nil {
+			greeting = response.String()
+		} else {
+			exchanges = append(exchanges, exchange{request: strings.Join(request, "\n"), response: response.String()})
+		}
+		response.Reset()
+	}
+	for _, e := range entries {
+		switch e.dir {
+		case transcriptSent:
+			if inList {
+				request = append(request, e.data)
+				inList = e.data != "command_list_end"
+				continue
+			}
+			flush()
+			request = []string{e.data}
+			inList = isListBegin(e.data)
+		case transcriptLine:
+			response.WriteString(e.data + "\n")
+		case transcriptBinary:
+			response.WriteString(e.data + "\n")
+		}
+	}
+	flush()
+	return
+}
+
+func isListBegin(line string) bool {
+	return line == "command_list_begin" || line == "command_list_ok_begin"
+}
+
+// Network returns the network the server listens on, as expected by `Dial`.
+func (s *ReplayServer) Network() string {
+	return "tcp"
+}
+
+// Addr returns the address the server listens on.
+func (s *ReplayServer) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Close stops the server and disconnects all clients.
+func (s *ReplayServer) Close() error {
+	err := s.l.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return errors.WithStack(err)
+}
+
+func (s *ReplayServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.replay(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// replay serves the transcript on a connection.
+func (s *ReplayServer) replay(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	w.WriteString(s.greeting)
+	cursor := 0
+	for {
+		if err := w.Flush(); err != nil {
+			return
+		}
+		request, err := readRequest(r)
+		if err != nil || request == "close" {
+			return
+		}
+		if i := s.find(request, cursor); i >= 0 {
+			cursor = i + 1
+			w.WriteString(s.exchanges[i].response)
+			continue
+		}
+		switch {
+		case strings.HasPrefix(request, "idle"):
+			// Nothing more happens: wait for noidle.
+		case request == "noidle":
+			w.WriteString("OK\n")
+		case s.findLast(request, cursor) >= 0:
+			w.WriteString(s.exchanges[s.findLast(request, cursor)].response)
+		default:
+			name := strings.SplitN(strings.SplitN(request, "\n", 2)[0], " ", 2)[0]
+			fmt.Fprintf(w, "ACK [5@0] {%s} not in the transcript\n", name)
+		}
+	}
+}
+
+// find returns the index of the next exchange with the given request, starting from from.
+func (s *ReplayServer) find(request string, from int) int {
+	for i := from; i < len(s.exchanges); i++ {
+		if s.exchanges[i].request == request {
+			return i
+		}
+	}
+	return -1
+}
+
+// findLast returns the index of the last exchange with the given request, before before.
+// Events are never replayed twice.
+func (s *ReplayServer) findLast(request string, before int) int {
+	if strings.HasPrefix(request, "idle") || request == "noidle" {
+		return -1
+	}
+	for i := before - 1; i >= 0; i-- {
+		if s.exchanges[i].request == request {
+			return i
+		}
+	}
+	return -1
+}
+
+// readRequest reads a command, or a whole command list, as it is recorded in transcripts.
+func readRequest(r *bufio.Reader) (string, error) {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = redact(strings.TrimSuffix(line, "\n"))
+		lines = append(lines, line)
+		if !isListBegin(lines[0]) || line == "command_list_end" {
+			return strings.Join(lines, "\n"), nil
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package mpd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/fhs/gompd/v2/mpd"
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func TestSongFromAttrsArt(t *testing.T) {
+	// Larger than the server's chunks, so that it is read in several parts.
+	picture := bytes.Repeat([]byte("picture!"), 50)
+	cover := []byte("cover")
+
+	tests := []struct {
+		name     string
+		pictures map[string][]byte
+		albumArt map[string][]byte
+		want     []byte
+		commands []string
+	}{
+		{
+			name:     "embedded picture",
+			pictures: map[string][]byte{"a.flac": picture},
+			albumArt: map[string][]byte{"a.flac": cover},
+			want:     picture,
+			commands: []string{"readpicture", "readpicture", "readpicture", "readpicture", "readpicture", "readpicture", "readpicture"},
+		},
+		{
+			name:     "cover file",
+			albumArt: map[string][]byte{"a.flac": cover},
+			want:     cover,
+			commands: []string{"readpicture", "albumart"},
+		},
+		{
+			name:     "no art",
+			commands: []string{"readpicture", "albumart"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, s := newTestClient(t, func(st *mpdtest.State) {
+				for k, v := range tt.pictures {
+					st.Pictures[k] = v
+				}
+				for k, v := range tt.albumArt {
+					st.AlbumArt[k] = v
+				}
+			})
+			s.ResetCommands()
+
+			song, err := c.SongFromAttrs(mpd.Attrs{"file": "a.flac", "Id": "3", "Title": "A"})
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if song.ID != 3 || song.Title != "A" {
+				t.Errorf("SongFromAttrs() = %+v", song)
+			}
+			var commands []string
+			for _, cmd := range s.Commands() {
+				commands = append(commands, strings.Fields(cmd)[0])
+			}
+			if strings.Join(commands, " ") != strings.Join(tt.commands, " ") {
+				t.Errorf("commands = %v, want %v", commands, tt.commands)
+			}
+
+			uri, ok := song.AlbumArtURI()
+			if ok != (tt.want != nil) {
+				t.Fatalf("AlbumArtURI() = %q, %v", uri, ok)
+			}
+			if !ok {
+				return
+			}
+			data, err := ioutil.ReadFile(strings.TrimPrefix(uri, "file://"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, tt.want) {
+				t.Errorf("album art = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestSongFromAttrsNoID(t *testing.T) {
+	c, s := newTestClient(t, nil)
+	s.ResetCommands()
+
+	song, err := c.SongFromAttrs(mpd.Attrs{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if song.ID != -1 {
+		t.Errorf("ID = %d, want -1", song.ID)
+	}
+	if _, ok := song.AlbumArtURI(); ok {
+		t.Error("a missing song should not have album art")
+	}
+	if cmds := s.Commands(); len(cmds) != 0 {
+		t.Errorf("unexpected commands %v", cmds)
+	}
+}
+
+func TestCurrentSong(t *testing.T) {
+	c, _ := newTestClient(t, func(st *mpdtest.State) {
+		st.SetQueue(mpdtest.Song{"file": "a.flac", "Title": "A"}, mpdtest.Song{"file": "b.flac", "Title": "B"})
+		st.Play(1)
+	})
+	song, err := c.CurrentSong()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if song.ID != 2 || song.Title != "B" || song.Path() != "b.flac" {
+		t.Errorf("CurrentSong() = %+v", song)
+	}
+}
@@ -6,18 +6,31 @@ import (
 	"github.com/fhs/gompd/v2/mpd"
 )
 
+// NoVolume is the volume reported by MPD when it has no mixer, e.g. with some outputs, or while they are closed.
+const NoVolume = -1
+
+// Single modes, as reported in Status.SingleMode and set with CommandList.SingleMode.
+// In "oneshot" mode, playback stops after the current song (or repeats it once with repeat on), then single mode turns off.
+const (
+	SingleOff     = "0"
+	SingleOn      = "1"
+	SingleOneshot = "oneshot"
+)
+
 // Status represents mpd's current status.
 type Status struct {
-	Volume         int
+	Volume         int // 0-100, or NoVolume
 	Repeat         bool
 	Random         bool
-	Single         bool
+	Single         bool   // Whether single mode is on, including "oneshot"
+	SingleMode     string // Single mode as MPD reports it: SingleOff, SingleOn or SingleOneshot
 	Consume        bool
 	PlaylistLength time.Duration
 	State          string
 	Song           int
 	Seek           time.Duration
 	NextSong       int
+	QueueVersion   int // Changes whenever the queue changes
 	Attrs          mpd.Attrs
 
 	Seekable bool // Whether we can seek the current song
@@ -27,9 +40,16 @@ type Status struct {
 func StatusFromAttrs(attr mpd.Attrs) (s Status, err error) {
 	p := &parseMap{m: attr}
 
-	p.Int("volume", &s.Volume, true)
+	// Newer versions of MPD leave out the volume when there is no mixer.
+	if !p.Int("volume", &s.Volume, true) || s.Volume < 0 {
+		s.Volume = NoVolume
+	}
 	p.Bool("repeat", &s.Repeat, true)
-	p.Bool("single", &s.Single, true)
+	// Single mode is not a boolean, since it may be "oneshot".
+	if !p.String("single", &s.SingleMode, true) {
+		s.SingleMode = SingleOff
+	}
+	s.Single = s.SingleMode != SingleOff
 	p.Bool("random", &s.Random, true)
 	p.Bool("consume", &s.Consume, true)
 
@@ -49,6 +69,7 @@ func StatusFromAttrs(attr mpd.Attrs) (s Status, err error) {
 		s.PlaylistLength = time.Duration(x) * time.Second
 	}
 
+	p.Int("playlist", &s.QueueVersion, true)
 	p.String("state", &s.State, true)
 	if !p.Int("songid", &s.Song, true) {
 		s.Song = -1
@@ -61,3 +82,8 @@ func StatusFromAttrs(attr mpd.Attrs) (s Status, err error) {
 	s.Attrs = attr
 	return s, nil
 }
+
+// HasVolume returns whether the volume is available, i.e. whether MPD has a mixer.
+func (s Status) HasVolume() bool {
+	return s.Volume != NoVolume
+}
@@ -0,0 +1,96 @@
+package mpd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/v2/mpd"
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func TestStatusFromAttrs(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs mpd.Attrs
+		want  Status
+	}{
+		{
+			name:  "empty",
+			attrs: mpd.Attrs{},
+			want:  Status{Volume: NoVolume, SingleMode: SingleOff, Song: -1, NextSong: -1},
+		},
+		{
+			name: "stopped",
+			attrs: mpd.Attrs{
+				"volume": "40", "repeat": "1", "random": "0", "single": "1", "consume": "0",
+				"playlistlength": "3", "state": "stop",
+			},
+			want: Status{Volume: 40, Repeat: true, Single: true, SingleMode: SingleOn, PlaylistLength: 3 * time.Second, State: "stop", Song: -1, NextSong: -1},
+		},
+		{
+			name: "playing",
+			attrs: mpd.Attrs{
+				"volume": "100", "repeat": "0", "random": "1", "single": "0", "consume": "1",
+				"playlistlength": "2", "state": "play", "song": "0", "songid": "12", "nextsong": "1", "nextsongid": "13",
+				"playlist": "7", "elapsed": "12.500", "duration": "200.000",
+			},
+			want: Status{
+				Volume: 100, Random: true, SingleMode: SingleOff, Consume: true, PlaylistLength: 2 * time.Second, State: "play",
+				Song: 12, NextSong: 13, Seek: 12500 * time.Millisecond, QueueVersion: 7, Seekable: true,
+			},
+		},
+		{
+			name:  "stream",
+			attrs: mpd.Attrs{"state": "play", "songid": "1", "elapsed": "3.000", "duration": "0.000"},
+			want:  Status{Volume: NoVolume, SingleMode: SingleOff, State: "play", Song: 1, NextSong: -1, Seek: 3 * time.Second},
+		},
+		{
+			name:  "single oneshot",
+			attrs: mpd.Attrs{"volume": "50", "single": "oneshot", "state": "play", "songid": "4", "elapsed": "2.000"},
+			want:  Status{Volume: 50, Single: true, SingleMode: SingleOneshot, State: "play", Song: 4, NextSong: -1, Seek: 2 * time.Second},
+		},
+		{
+			name:  "no mixer",
+			attrs: mpd.Attrs{"volume": "-1", "state": "pause", "songid": "1", "elapsed": "1.000"},
+			want:  Status{Volume: NoVolume, SingleMode: SingleOff, State: "pause", Song: 1, NextSong: -1, Seek: time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StatusFromAttrs(tt.attrs)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if !statusEqual(got, tt.want) {
+				t.Errorf("StatusFromAttrs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func statusEqual(a, b Status) bool {
+	a.Attrs, b.Attrs = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+func TestClientStatus(t *testing.T) {
+	c, _ := newTestClient(t, func(st *mpdtest.State) {
+		st.SetQueue(mpdtest.Song{"file": "a.flac", "duration": "100.5"}, mpdtest.Song{"file": "b.flac"})
+		st.Play(0)
+		st.Elapsed = 4 * time.Second
+		st.Volume = 30
+		st.Repeat = true
+	})
+	status, err := c.Status()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want := Status{
+		Volume: 30, Repeat: true, SingleMode: SingleOff, PlaylistLength: 2 * time.Second, State: "play",
+		Song: 1, NextSong: 2, Seek: 4 * time.Second, QueueVersion: 3, Seekable: true,
+	}
+	if !statusEqual(status, want) {
+		t.Errorf("Status() = %+v, want %+v", status, want)
+	}
+}
@@ -0,0 +1,52 @@
+package mpd
+
+import (
+	"strings"
+
+	"github.com/fhs/gompd/v2/mpd"
+	"github.com/pkg/errors"
+)
+
+// This file implements the sticker commands, which attach values to songs of MPD's database.
+// See https://mpd.readthedocs.io/en/latest/protocol.html#stickers
+
+// isMPDError returns whether err is MPD's error with the given code.
+func isMPDError(err error, code mpd.ErrorCode) bool {
+	var mpdErr mpd.Error
+	return errors.As(err, &mpdErr) && mpdErr.Code == code
+}
+
+// isNotExist returns whether err is MPD's error for something that does not exist.
+func isNotExist(err error) bool {
+	return isMPDError(err, mpd.ErrorNoExist)
+}
+
+// Sticker returns the value of the sticker name of the song at uri, and whether the song has it.
+// Without a sticker database, MPD does not know the command, and no song has stickers.
+func (c *Client) Sticker(uri, name string) (string, bool, error) {
+	attrs, err := c.Command("sticker get song %s %s", uri, name).Attrs()
+	if isNotExist(err) || isMPDError(err, mpd.ErrorUnknown) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	// The value comes as "name=value".
+	value := attrs["sticker"]
+	if !strings.HasPrefix(value, name+"=") {
+		return "", false, errors.Errorf("unexpected sticker %q", value)
+	}
+	return strings.TrimPrefix(value, name+"="), true, nil
+}
+
+// SetSticker sets the sticker name of the song at uri to value.
+func (c *Client) SetSticker(uri, name, value string) error {
+	return c.Command("sticker set song %s %s %s", uri, name, value).OK()
+}
+
+// DeleteSticker removes the sticker name from the song at uri. Removing a missing sticker is not an error.
+func (c *Client) DeleteSticker(uri, name string) error {
+	if err := c.Command("sticker delete song %s %s", uri, name).OK(); err != nil && !isNotExist(err) {
+		return err
+	}
+	return nil
+}
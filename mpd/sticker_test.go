@@ -0,0 +1,40 @@
+package mpd
+
+import (
+	"testing"
+
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func TestStickers(t *testing.T) {
+	c, s := newTestClient(t, func(st *mpdtest.State) {
+		st.Library = append(st.Library, mpdtest.Song{"file": "book.m4b"})
+	})
+
+	if _, ok, err := c.Sticker("book.m4b", "position"); err != nil || ok {
+		t.Errorf("Sticker() = %v, %v before setting it", ok, err)
+	}
+	if err := c.SetSticker("book.m4b", "position", "12.5 s"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if value, ok, err := c.Sticker("book.m4b", "position"); err != nil || !ok || value != "12.5 s" {
+		t.Errorf("Sticker() = %q, %v, %v", value, ok, err)
+	}
+	s.Inspect(func(st *mpdtest.State) {
+		if value := st.Stickers["book.m4b"]["position"]; value != "12.5 s" {
+			t.Errorf("sticker set to %q", value)
+		}
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := c.DeleteSticker("book.m4b", "position"); err != nil {
+			t.Errorf("DeleteSticker(): %+v", err)
+		}
+	}
+	if _, ok, _ := c.Sticker("book.m4b", "position"); ok {
+		t.Error("the sticker should be deleted")
+	}
+	if err := c.SetSticker("missing.m4b", "position", "1"); err == nil {
+		t.Error("setting stickers on missing songs should fail")
+	}
+}
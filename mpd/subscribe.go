@@ -2,45 +2,77 @@ package mpd
 
 import (
 	"context"
+	"sort"
+	"sync"
 
-	"github.com/fhs/gompd/v2/mpd"
 	"github.com/pkg/errors"
 )
 
-// Watcher is our implementation of the watcher.
-// It automatically subscribes to MPRIS-related events and
+// Watcher collects the events reported by the engine's idle.
 // `Poll` can be used to wait for any event.
 type Watcher struct {
-	*mpd.Watcher
+	engine *Engine
+
+	mu      sync.Mutex
+	changed map[string]struct{}
+	notify  chan struct{}
 }
 
 var (
 	// See https://mpd.readthedocs.io/en/latest/protocol.html#command-idle
 	eventsToSubscribe = []string{
-		"playlist", // the queue (i.e. the current playlist) has been modified
-		"player",   // the player has been started, stopped or seeked or tags of the currently playing song have changed (e.g. received from stream)
-		"mixer",    // the volume has been changed
-		"options",  // options like repeat, random, crossfade, replay gain
+		"playlist",        // the queue (i.e. the current playlist) has been modified
+		"player",          // the player has been started, stopped or seeked or tags of the currently playing song have changed (e.g. received from stream)
+		"mixer",           // the volume has been changed
+		"options",         // options like repeat, random, crossfade, replay gain
+		"output",          // an audio output has been added, removed or modified (e.g. renamed, enabled or disabled)
+		"sticker",         // the sticker database has been modified
+		"stored_playlist", // a stored playlist has been modified, renamed, created or deleted
 	}
 )
 
-// NewWatcher creates a new watcher with the given parameters.
-func NewWatcher(net, addr, passwd string) (*Watcher, error) {
-	w, err := mpd.NewWatcher(net, addr, passwd, eventsToSubscribe...)
-	if err != nil {
-		return nil, errors.WithStack(err)
+func newWatcher(e *Engine) *Watcher {
+	return &Watcher{
+		engine:  e,
+		changed: make(map[string]struct{}),
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// push records the changed subsystems, without blocking the engine.
+// Changes that are not yet polled are merged together.
+func (w *Watcher) push(changed []string) {
+	if len(changed) == 0 {
+		return
+	}
+	w.mu.Lock()
+	for _, name := range changed {
+		w.changed[name] = struct{}{}
+	}
+	w.mu.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
 	}
-	return &Watcher{w}, nil
 }
 
-// Poll waits for the next event, or errors out.
-func (w *Watcher) Poll(ctx context.Context) error {
+// Poll waits for the next events, or errors out.
+// It returns the sorted list of subsystems that changed since the last call.
+func (w *Watcher) Poll(ctx context.Context) ([]string, error) {
 	select {
-	case <-w.Event:
-		return nil
-	case err := <-w.Error:
-		return errors.Wrap(err, "polling for events")
+	case <-w.notify:
+		w.mu.Lock()
+		changed := make([]string, 0, len(w.changed))
+		for name := range w.changed {
+			changed = append(changed, name)
+		}
+		w.changed = make(map[string]struct{})
+		w.mu.Unlock()
+		sort.Strings(changed)
+		return changed, nil
+	case <-w.engine.done:
+		return nil, errors.Wrap(w.engine.closedErr(), "polling for events")
 	case <-ctx.Done():
-		return context.Canceled
+		return nil, context.Canceled
 	}
 }
@@ -0,0 +1,82 @@
+package mpd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func pollTimeout(t *testing.T, w *Watcher) ([]string, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return w.Poll(ctx)
+}
+
+func TestWatcherPoll(t *testing.T) {
+	c, s := newTestClient(t, nil)
+
+	s.Modify(func(st *mpdtest.State) { st.Volume = 20 }, "mixer")
+	changed, err := pollTimeout(t, c.Watcher)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if want := []string{"mixer"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("Poll() = %v, want %v", changed, want)
+	}
+
+	// Commands sent by the client are reported too.
+	if err := c.Random(true); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	changed, err = pollTimeout(t, c.Watcher)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if want := []string{"options"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("Poll() = %v, want %v", changed, want)
+	}
+}
+
+func TestWatcherPollMerges(t *testing.T) {
+	c, s := newTestClient(t, nil)
+
+	s.Modify(func(st *mpdtest.State) {}, "player", "playlist")
+	s.Modify(func(st *mpdtest.State) {}, "mixer", "player")
+	// Not subscribed to.
+	s.Modify(func(st *mpdtest.State) {}, "database")
+	// Give the engine time to receive all events.
+	if err := c.Ping(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	changed, err := pollTimeout(t, c.Watcher)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if want := []string{"mixer", "player", "playlist"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("Poll() = %v, want %v", changed, want)
+	}
+}
+
+func TestWatcherPollCanceled(t *testing.T) {
+	c, _ := newTestClient(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Poll(ctx); err != context.Canceled {
+		t.Errorf("Poll() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestWatcherPollDisconnected(t *testing.T) {
+	c, s := newTestClient(t, nil)
+
+	s.Disconnect()
+	if _, err := pollTimeout(t, c.Watcher); err == nil {
+		t.Error("Poll() should fail once the connection is lost")
+	}
+}
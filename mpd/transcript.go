@@ -0,0 +1,224 @@
+package mpd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements protocol transcripts: a log of everything sent and received on a connection to MPD,
+// that can be replayed by a `ReplayServer`.
+//
+// A transcript is a text file with one entry per line:
+//
+//	2006-01-02T15:04:05.000000Z > status
+//	2006-01-02T15:04:05.000300Z < volume: 100
+//	2006-01-02T15:04:05.000600Z = aGVsbG8=
+//
+// ">" lines are sent to MPD, "<" lines are received from MPD, and "=" entries are binary data received from MPD
+// (the payload of a "binary: N" response), in base64. Lines starting with "#" are comments.
+// Passwords are never recorded.
+
+const transcriptTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// The direction of a transcript entry.
+const (
+	transcriptSent   = '>'
+	transcriptLine   = '<'
+	transcriptBinary = '='
+)
+
+// redactedPassword replaces the argument of the `password` command in transcripts.
+const redactedPassword = `password "[redacted]"`
+
+// redact removes secrets from a command line.
+func redact(line string) string {
+	if line == "password" || strings.HasPrefix(line, "password ") {
+		return redactedPassword
+	}
+	return line
+}
+
+// Recorder writes the transcript of connections to MPD.
+// Connections are recorded by wrapping them with `Wrap`; a recorder is meant to record a single connection at a time.
+type Recorder struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewRecorder creates a recorder that writes the transcript to w.
+func NewRecorder(w io.Writer) *Recorder {
+	r := &Recorder{w: w}
+	r.comment("mpd-mpris protocol transcript")
+	return r
+}
+
+// Err returns the first error that happened while writing the transcript.
+// Recording errors do not affect the recorded connection.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) comment(text string) {
+	r.write("# " + text + "\n")
+}
+
+func (r *Recorder) record(dir byte, payload string) {
+	r.write(fmt.Sprintf("%s %c %s\n", time.Now().UTC().Format(transcriptTimeFormat), dir, payload))
+}
+
+func (r *Recorder) write(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if _, err := io.WriteString(r.w, s); err != nil {
+		r.err = errors.Wrap(err, "writing transcript")
+	}
+}
+
+// Wrap returns a connection that records everything going through conn.
+func (r *Recorder) Wrap(conn net.Conn) net.Conn {
+	r.comment("connected to " + conn.RemoteAddr().String())
+	return &recordedConn{Conn: conn, r: r}
+}
+
+// recordedConn is a connection to MPD that records its traffic.
+// Reads and writes may happen concurrently.
+type recordedConn struct {
+	net.Conn
+	r *Recorder
+
+	// The response being parsed. Only used by Read.
+	line        []byte
+	binary      []byte
+	binaryLeft  int  // The number of bytes of binary data yet to be read.
+	skipNewline bool // Whether the newline that ends binary data is yet to be read.
+}
+
+func (c *recordedConn) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		c.r.record(transcriptSent, redact(line))
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *recordedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.parse(p[:n])
+	return n, err
+}
+
+// parse records the received data, splitting it into lines and binary chunks.
+func (c *recordedConn) parse(data []byte) {
+	for len(data) > 0 {
+		switch {
+		case c.binaryLeft > 0:
+			n := c.binaryLeft
+			if n > len(data) {
+				n = len(data)
+			}
+			c.binary = append(c.binary, data[:n]...)
+			c.binaryLeft -= n
+			data = data[n:]
+			if c.binaryLeft == 0 {
+				c.endBinary()
+			}
+		case c.skipNewline:
+			if data[0] == '\n' {
+				data = data[1:]
+			}
+			c.skipNewline = false
+		default:
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				c.line = append(c.line, data...)
+				return
+			}
+			line := string(append(c.line, data[:i]...))
+			c.line = c.line[:0]
+			data = data[i+1:]
+			c.r.record(transcriptLine, line)
+			if size, ok := binarySize(line); ok {
+				c.binaryLeft = size
+				if size == 0 {
+					c.endBinary()
+				}
+			}
+		}
+	}
+}
+
+func (c *recordedConn) endBinary() {
+	c.r.record(transcriptBinary, base64.StdEncoding.EncodeToString(c.binary))
+	c.binary = c.binary[:0]
+	c.skipNewline = true
+}
+
+// binarySize parses a "binary: N" line.
+func binarySize(line string) (int, bool) {
+	if !strings.HasPrefix(line, "binary: ") {
+		return 0, false
+	}
+	size, err := strconv.Atoi(strings.TrimPrefix(line, "binary: "))
+	return size, err == nil && size >= 0
+}
+
+// transcriptEntry is an entry of a transcript.
+type transcriptEntry struct {
+	time time.Time
+	dir  byte
+	data string // The line, or the decoded binary data.
+}
+
+// readTranscript parses a transcript.
+func readTranscript(r io.Reader) ([]transcriptEntry, error) {
+	var entries []transcriptEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024) // Binary entries can be large.
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// TIME DIR PAYLOAD; the payload may be empty.
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) < 2 || len(parts[1]) != 1 {
+			return nil, errors.Errorf("transcript line %d: malformed entry", lineNo)
+		}
+		t, err := time.Parse(transcriptTimeFormat, parts[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "transcript line %d", lineNo)
+		}
+		e := transcriptEntry{time: t, dir: parts[1][0]}
+		if len(parts) == 3 {
+			e.data = parts[2]
+		}
+		switch e.dir {
+		case transcriptSent, transcriptLine:
+		case transcriptBinary:
+			data, err := base64.StdEncoding.DecodeString(e.data)
+			if err != nil {
+				return nil, errors.Wrapf(err, "transcript line %d", lineNo)
+			}
+			e.data = string(data)
+		default:
+			return nil, errors.Errorf("transcript line %d: unknown direction %q", lineNo, e.dir)
+		}
+		entries = append(entries, e)
+	}
+	return entries, errors.Wrap(scanner.Err(), "reading transcript")
+}
@@ -0,0 +1,136 @@
+package mpd
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+// session runs a few commands, and returns what the client saw.
+func session(t *testing.T, c *Client, s *mpdtest.Server) []interface{} {
+	t.Helper()
+	var seen []interface{}
+	status, err := c.Status()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	seen = append(seen, status.State, status.Song)
+
+	if s != nil {
+		s.Modify(func(st *mpdtest.State) { st.Play(1) }, "player")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changed, err := c.Poll(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	seen = append(seen, changed)
+
+	song, err := c.CurrentSong()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	seen = append(seen, song.ID, song.Title)
+	art, err := c.readPicture(song.Path())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	seen = append(seen, string(art))
+
+	cl := c.BeginCommandList()
+	statusP := cl.Status()
+	cl.Next()
+	if err := cl.End(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	status, _ = statusP.Value()
+	seen = append(seen, status.Song)
+	return seen
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	s, err := mpdtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Password = "secret"
+	s.Modify(func(st *mpdtest.State) {
+		st.SetQueue(mpdtest.Song{"file": "a.flac", "Title": "A"}, mpdtest.Song{"file": "b.flac", "Title": "B"})
+		st.Pictures["b.flac"] = bytes.Repeat([]byte{0, '\n', 'x'}, 100)
+	})
+
+	var transcript bytes.Buffer
+	rec := NewRecorder(&transcript)
+	conn, err := net.Dial(s.Network(), s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(rec.Wrap(conn), s.Addr(), "secret")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	recorded := session(t, c, s)
+	c.Close()
+	if err := rec.Err(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if strings.Contains(transcript.String(), "secret") {
+		t.Errorf("the password was recorded:\n%s", transcript.String())
+	}
+
+	replay, err := NewReplayServer(bytes.NewReader(transcript.Bytes()))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer replay.Close()
+	c, err = DialAuthenticated(replay.Network(), replay.Addr(), "another password")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer c.Close()
+	replayed := session(t, c, nil)
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Errorf("replayed session = %v, recorded %v", replayed, recorded)
+	}
+
+	// The last state is kept once the transcript is exhausted.
+	song, err := c.CurrentSong()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if song.Title != "B" {
+		t.Errorf("CurrentSong() = %q, want B", song.Title)
+	}
+	if err := c.Command("stats").OK(); err == nil {
+		t.Error("commands missing from the transcript should fail")
+	}
+}
+
+func TestReadTranscript(t *testing.T) {
+	entries, err := readTranscript(strings.NewReader(`# comment
+2024-01-02T03:04:05.000000Z < OK MPD 0.23.5
+2024-01-02T03:04:05.000001Z > status
+2024-01-02T03:04:05.000002Z < 
+2024-01-02T03:04:05.000003Z = aGk=
+`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(entries) != 4 || entries[2].data != "" || entries[3].data != "hi" {
+		t.Errorf("readTranscript() = %+v", entries)
+	}
+
+	for _, bad := range []string{"nonsense", "2024-01-02T03:04:05.000000Z ? x", "yesterday > status", "2024-01-02T03:04:05.000000Z = !!"} {
+		if _, err := readTranscript(strings.NewReader(bad)); err == nil {
+			t.Errorf("readTranscript(%q) should fail", bad)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package mpris
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
 
 // Option represents a togglable option.
 type Option func(*Instance)
@@ -26,3 +31,94 @@ func IsLocal(isLocal bool) Option {
 		}
 	}
 }
+
+// CoalesceWindow merges property changes that happen within d of each other into a single `PropertiesChanged` signal.
+// This smooths out rapid changes, like a stream's title flapping.
+func CoalesceWindow(d time.Duration) Option {
+	return func(ins *Instance) {
+		ins.coalesceWindow = d
+	}
+}
+
+// DBusConn exports the instance on the given bus connection, instead of the session bus.
+// The connection is not closed with the instance.
+func DBusConn(conn *dbus.Conn) Option {
+	return func(ins *Instance) {
+		ins.dbus = conn
+	}
+}
+
+// UseVolumeCurve sets how MPRIS volumes map to MPD's. The default is LinearVolume.
+func UseVolumeCurve(c VolumeCurve) Option {
+	return func(ins *Instance) {
+		ins.volumeCurve = c
+	}
+}
+
+// VolumeStep sets the size of a volume step, taken by the `StepVolume` extension method, as an MPRIS volume.
+func VolumeStep(step float64) Option {
+	return func(ins *Instance) {
+		ins.volumeStep = step
+	}
+}
+
+// LimitVolume applies the volume policy to all volume changes, including those made by other MPD clients.
+func LimitVolume(policy VolumePolicy) Option {
+	return func(ins *Instance) {
+		ins.volumePolicy = policy
+	}
+}
+
+// Fade fades the volume out over d before pausing or stopping, and fades it in over d when playback resumes.
+// Fades are skipped when MPD has no mixer.
+func Fade(d time.Duration) Option {
+	return func(ins *Instance) {
+		ins.fade = d
+	}
+}
+
+// AlarmFile reads alarms from the JSON file at path, which also keeps the alarms added over D-Bus.
+// The file does not need to exist. Without it, alarms added over D-Bus are lost when mpd-mpris stops.
+func AlarmFile(path string) Option {
+	return func(ins *Instance) {
+		ins.alarmFile = path
+	}
+}
+
+// UseShuffleMode sets what Shuffle does. The default is ShuffleRandom.
+func UseShuffleMode(m ShuffleMode) Option {
+	return func(ins *Instance) {
+		ins.shuffleMode = m
+	}
+}
+
+// NativePrevious makes Previous go to the song before the current one in the queue, as MPD does, even while the
+// queue is shuffled, rather than to the song played before it. In random mode, MPD's previous song is any song.
+func NativePrevious() Option {
+	return func(ins *Instance) {
+		ins.nativePrevious = true
+	}
+}
+
+// SmartPrevious makes Previous restart the current song once it has played for longer than after,
+// and when there is nothing to go back to, e.g. at the start of the queue without repeat.
+func SmartPrevious(after time.Duration) Option {
+	return func(ins *Instance) {
+		ins.previousRestart = after
+	}
+}
+
+// SnapshotFile keeps queue snapshots in the JSON file at path. The file does not need to exist.
+// Without it, snapshots are lost when mpd-mpris stops.
+func SnapshotFile(path string) Option {
+	return func(ins *Instance) {
+		ins.snapshotFile = path
+	}
+}
+
+// ResumePositions makes the tracks chosen by the policy remember their position, and start from it the next time they play.
+func ResumePositions(policy ResumePolicy) Option {
+	return func(ins *Instance) {
+		ins.resume = policy
+	}
+}
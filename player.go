@@ -2,13 +2,12 @@ package mpris
 
 import (
 	"context"
-	"fmt"
 	"log"
-	"math"
 	"sync"
 	"time"
 
 	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
 	"github.com/godbus/dbus/v5/prop"
 	"github.com/natsukagami/mpd-mpris/mpd"
 	"github.com/pkg/errors"
@@ -30,7 +29,7 @@ type Player struct {
 
 // TrackID is the Unique track identifier.
 // https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Simple-Type:Track_Id
-type TrackID string
+type TrackID = dbus.ObjectPath
 
 // PlaybackRate is a playback rate.
 // https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Simple-Type:Playback_Rate
@@ -90,7 +89,24 @@ type Status struct {
 	LoopStatus     LoopStatus
 	Shuffle        bool
 	Volume         float64
+	HasVolume      bool // Whether MPD has a mixer. Volume is read-only otherwise.
+	mpdVolume      int  // MPD's volume, from which Volume is mapped
+	ramp           *volumeRamp
+	sleep          *sleepTimer
+	ringing        *Alarm // The alarm playing, which Snooze silences
+	loop           *abLoop
+	resuming       *resumeState // Follows the position of CurrentSong, if it remembers it
 	CurrentSong    mpd.Song
+	TrackID        TrackID       // The track ID of CurrentSong
+	bookmarks      []Bookmark    // The bookmarks of CurrentSong
+	stickerWritten bool          // Whether the next sticker event is our own resume position's, which changes no bookmark
+	history        history       // The songs played before CurrentSong
+	goingBack      bool          // Whether Previous is going back through the history
+	queueOrder     []int         // The order of the queue before Shuffle shuffled it, by song ID, if it did
+	albums         *albumShuffle // The albums to play, while album shuffle is on
+	Seekable       bool
+	CanGoNext      bool
+	CanGoPrevious  bool
 	// Internal seek
 	Seek time.Duration
 }
@@ -103,7 +119,10 @@ func (s *Status) updateSeek(p *Player) {
 	defer s.mu.Unlock()
 	if s.PlaybackStatus == PlaybackStatusPlaying {
 		s.Seek += time.Second
-		go p.setProp("org.mpris.MediaPlayer2.Player", "Position", dbus.MakeVariant(UsFromDuration(s.Seek)))
+		p.keepResume(s.Seek)
+		b := &batch{}
+		b.set("org.mpris.MediaPlayer2.Player", "Position", UsFromDuration(s.Seek))
+		p.Instance.props.apply(b)
 	}
 }
 
@@ -123,78 +142,306 @@ func (p *Player) pollSeek(ctx context.Context) {
 
 // ============================================================================
 
-func (p *Player) setProp(iface, name string, value dbus.Variant) {
-	if err := p.Instance.props.Set(iface, name, value); err != nil {
-		log.Printf("Setting %s %s failed: %+v\n", iface, name, errors.WithStack(err))
+// Update performs an update on the status.
+func (s *Status) Update(p *Player) *dbus.Error {
+	return s.UpdateWith(p, nil)
+}
+
+// UpdateWith runs the commands queued by cmds, then updates the status, all in a single command list.
+func (s *Status) UpdateWith(p *Player, cmds func(cl *mpd.CommandList)) *dbus.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	return s.updateWith(p, b, cmds)
+}
+
+// updateWith is UpdateWith, but assumes that the lock is held.
+// Changes are recorded into b.
+func (s *Status) updateWith(p *Player, b *batch, cmds func(cl *mpd.CommandList)) *dbus.Error {
+	if cmds != nil && s.fadingOut() {
+		// Another command cancels the fade-out, and the volume is restored along with it.
+		restore := s.mpdVolume
+		s.stopRamp()
+		queued := cmds
+		cmds = func(cl *mpd.CommandList) {
+			cl.SetVolume(restore)
+			queued(cl)
+		}
+	}
+	status, song, err := s.fetch(p, cmds, true)
+	if err != nil {
+		return err
 	}
+	// The volume goes first: a change of playback may start a volume ramp, after which status is out of date.
+	s.updateVolume(p, b, status)
+	if err := s.updatePlayback(p, b, status); err != nil {
+		return err
+	}
+	s.updateOptions(p, b, status)
+	s.updateSong(p, b, status, song)
+	s.updateQueue(p, b, status)
+	return nil
 }
 
-// Update performs an update on the status.
-func (s *Status) Update(p *Player) *dbus.Error {
+// UpdatePlayer updates the playback state and the current song.
+// Happens on "player" events.
+func (s *Status) UpdatePlayer(p *Player) *dbus.Error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	status, song, err := s.fetch(p, nil, true)
+	if err != nil {
+		return err
+	}
+	if err := s.updatePlayback(p, b, status); err != nil {
+		return err
+	}
+	s.updateSong(p, b, status, song)
+	s.updateQueue(p, b, status)
+	return nil
+}
 
-	status, err := p.mpd.Status()
+// UpdateOptions updates the loop status and shuffle.
+// Happens on "options" events.
+func (s *Status) UpdateOptions(p *Player) *dbus.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	status, _, err := s.fetch(p, nil, false)
 	if err != nil {
-		return p.transformErr(err)
+		return err
+	}
+	s.updateOptions(p, b, status)
+	s.updateQueue(p, b, status) // Repeat changes what comes next.
+	return nil
+}
+
+// UpdateVolume updates the volume.
+// Happens on "mixer" and "output" events.
+func (s *Status) UpdateVolume(p *Player) *dbus.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	status, _, err := s.fetch(p, nil, false)
+	if err != nil {
+		return err
+	}
+	s.updateVolume(p, b, status)
+	return nil
+}
+
+// UpdateQueue updates the queue navigation state.
+// Happens on "playlist" events.
+func (s *Status) UpdateQueue(p *Player) *dbus.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	status, _, err := s.fetch(p, nil, false)
+	if err != nil {
+		return err
+	}
+	s.updateQueue(p, b, status)
+	return nil
+}
+
+// fetch runs the commands queued by cmds, then fetches MPD's status (and the current song, if withSong is set),
+// in a single command list.
+func (s *Status) fetch(p *Player, cmds func(cl *mpd.CommandList), withSong bool) (mpd.Status, mpd.Song, *dbus.Error) {
+	cl := p.mpd.BeginCommandList()
+	if cmds != nil {
+		cmds(cl)
+	}
+	statusP := cl.Status()
+	var songP *mpd.Promise[mpd.Song]
+	if withSong {
+		songP = cl.CurrentSong()
+	}
+	if err := cl.End(); err != nil {
+		return mpd.Status{}, mpd.Song{}, p.transformErr(err)
 	}
 
-	// Playback Status
+	status, err := statusP.Value()
+	if err != nil {
+		return mpd.Status{}, mpd.Song{}, p.transformErr(err)
+	}
+	if !withSong {
+		return status, mpd.Song{}, nil
+	}
+	song, err := songP.Value()
+	if err != nil {
+		return mpd.Status{}, mpd.Song{}, p.transformErr(err)
+	}
+	return status, song, nil
+}
+
+// updatePlayback updates the playback status and position.
+func (s *Status) updatePlayback(p *Player, b *batch, status mpd.Status) *dbus.Error {
 	playbackStatus, err := PlaybackStatusFromMPD(status.State)
 	if err != nil {
 		return p.transformErr(err)
 	}
+	status.Seek = p.followResume(b, status)
 	if s.PlaybackStatus != playbackStatus {
+		if playbackStatus == PlaybackStatusPlaying {
+			p.rampOnPlay()
+		}
+		if playbackStatus == PlaybackStatusStopped {
+			p.endSleepTimer(b) // Its work is done, one way or another.
+		}
+		if playbackStatus != PlaybackStatusPlaying {
+			s.ringing = nil
+		}
 		s.PlaybackStatus = playbackStatus
-		go p.setProp("org.mpris.MediaPlayer2.Player", "PlaybackStatus", dbus.MakeVariant(playbackStatus))
+		b.set("org.mpris.MediaPlayer2.Player", "PlaybackStatus", playbackStatus)
 	}
-	// Loop status
-	var loopStatus LoopStatus
-	switch {
-	case !status.Repeat:
-		loopStatus = LoopStatusNone
-	case !status.Single:
-		loopStatus = LoopStatusPlaylist
-	default:
-		loopStatus = LoopStatusTrack
+
+	if status.Seekable != s.Seekable {
+		s.Seekable = status.Seekable
+		b.set("org.mpris.MediaPlayer2.Player", "CanSeek", status.Seekable)
+	}
+
+	if s.Seek != status.Seek {
+		// Position is never emitted, but its value must be kept up-to-date.
+		b.set("org.mpris.MediaPlayer2.Player", "Position", UsFromDuration(status.Seek))
+		if absDuration(s.Seek-status.Seek) > seekTriggerMinimum {
+			b.seek(UsFromDuration(status.Seek))
+		}
+		s.Seek = status.Seek
 	}
+	s.wakeLoop()
+	return nil
+}
+
+// updateOptions updates the loop status and shuffle.
+func (s *Status) updateOptions(p *Player, b *batch, status mpd.Status) {
+	loopStatus := loopStatusFromMPD(status)
 	if loopStatus != s.LoopStatus {
 		s.LoopStatus = loopStatus
-		go p.setProp("org.mpris.MediaPlayer2.Player", "LoopStatus", dbus.MakeVariant(string(loopStatus)))
+		b.set("org.mpris.MediaPlayer2.Player", "LoopStatus", string(loopStatus))
 	}
 
-	// Shuffle
-	if status.Random != s.Shuffle {
-		s.Shuffle = status.Random
-		go p.setProp("org.mpris.MediaPlayer2.Player", "Shuffle", dbus.MakeVariant(status.Random))
+	if status.Random {
+		// MPD's random mode mixes the albums up.
+		s.endAlbumShuffle(b)
 	}
-
-	// Current song metadata
-	song, err := p.mpd.CurrentSong()
-	if err != nil {
-		return p.transformErr(err)
+	if shuffle := status.Random || s.queueOrder != nil || s.albums != nil; shuffle != s.Shuffle {
+		s.Shuffle = shuffle
+		b.set("org.mpris.MediaPlayer2.Player", "Shuffle", shuffle)
 	}
+}
+
+// updateSong updates the current song's metadata.
+// The track ID is kept as long as the same queue entry is playing, even if its metadata (e.g. a stream's title) changes.
+func (s *Status) updateSong(p *Player, b *batch, status mpd.Status, song mpd.Song) {
 	if !song.SameAs(&s.CurrentSong) {
+		if song.ID != s.CurrentSong.ID || song.Path() != s.CurrentSong.Path() {
+			s.songPlayed()
+			s.TrackID = p.songTrackID(status, song)
+			p.sleepSongChanged(song)
+			p.endLoop(b) // Loops only make sense within a track.
+			p.resumeSong(b, status, song)
+			s.bookmarks = p.songBookmarks(song)
+			p.stockAlbums(status, song)
+		}
 		s.CurrentSong = song
-		go p.setProp("org.mpris.MediaPlayer2.Player", "Metadata", dbus.MakeVariant(MapFromSong(song)))
+		b.set("org.mpris.MediaPlayer2.Player", "Metadata", s.metadata())
 	}
+}
 
-	// Volume
-	newVolume := math.Max(0, float64(status.Volume)/100.0)
-	if math.Abs(newVolume-s.Volume) >= 0.5 {
-		s.Volume = newVolume
-		go p.setProp("org.mpris.MediaPlayer2.Player", "Volume", dbus.MakeVariant(newVolume))
+// metadata returns the metadata of the current song, with its bookmarks.
+func (s *Status) metadata() MetadataMap {
+	m := MapFromSong(s.TrackID, s.CurrentSong)
+	if len(s.bookmarks) > 0 && s.CurrentSong.ID != -1 {
+		m["mpd:bookmarks"] = s.bookmarks
 	}
+	return m
+}
 
-	if s.Seek != status.Seek {
-		if absDuration(s.Seek-status.Seek) > seekTriggerMinimum {
-			go p.Seeked(UsFromDuration(status.Seek))
+// songTrackID returns the track ID of song, which just became current.
+func (p *Player) songTrackID(status mpd.Status, song mpd.Song) TrackID {
+	if song.ID == -1 {
+		return NoTrack
+	}
+	return p.trackID(status.QueueVersion, song.ID)
+}
+
+// updateVolume updates the volume, and whether it can be set.
+// The last volume is kept while MPD has no mixer.
+func (s *Status) updateVolume(p *Player, b *batch, status mpd.Status) {
+	mixerChanged := status.HasVolume() != s.HasVolume
+	if mixerChanged {
+		s.HasVolume = status.HasVolume()
+		p.Instance.props.setWritable("org.mpris.MediaPlayer2.Player", "Volume", s.HasVolume)
+	}
+	if !s.HasVolume {
+		return
+	}
+	if s.ramp != nil {
+		if status.Volume == s.ramp.current {
+			return // Our own step
+		}
+		if s.fadingOut() {
+			// The fade-out goes on from the new volume, and restores it at the end.
+			s.ramp.current = status.Volume
 		} else {
-			go p.setProp("org.mpris.MediaPlayer2.Player", "Position", dbus.MakeVariant(UsFromDuration(status.Seek)))
+			s.stopRamp()
 		}
-		s.Seek = status.Seek
 	}
-	return nil
+	if limit := p.maxMPDVolume(); status.Volume > limit {
+		log.Printf("Volume %d is above the limit, lowering it to %d\n", status.Volume, limit)
+		if err := p.mpd.SetVolume(limit); err != nil {
+			log.Printf("Cannot lower the volume: %v\n", err)
+		} else {
+			status.Volume = limit
+		}
+	}
+	// MPD's volume is compared, rather than ours: it is exact, and is unchanged by our own changes.
+	if mixerChanged || status.Volume != s.mpdVolume {
+		s.mpdVolume = status.Volume
+		s.Volume = p.volumeCurve.FromMPD(status.Volume)
+		b.set("org.mpris.MediaPlayer2.Player", "Volume", s.Volume)
+	}
+}
+
+// updateQueue updates whether we can move around the queue.
+func (s *Status) updateQueue(p *Player, b *batch, status mpd.Status) {
+	if s.queueOrder != nil && status.PlaylistLength == 0 {
+		// There is no order to put back anymore.
+		s.queueOrder = nil
+		s.updateOptions(p, b, status)
+	}
+	if s.albums != nil && status.PlaylistLength == 0 {
+		// Another client cleared the queue.
+		s.endAlbumShuffle(b)
+		s.updateOptions(p, b, status)
+	}
+	canGoNext := status.NextSong != -1
+	if canGoNext != s.CanGoNext {
+		s.CanGoNext = canGoNext
+		b.set("org.mpris.MediaPlayer2.Player", "CanGoNext", canGoNext)
+	}
+	canGoPrevious := p.canGoPrevious(status)
+	if canGoPrevious != s.CanGoPrevious {
+		s.CanGoPrevious = canGoPrevious
+		b.set("org.mpris.MediaPlayer2.Player", "CanGoPrevious", canGoPrevious)
+	}
+}
+
+// loopStatusFromMPD computes the LoopStatus from MPD's repeat and single options.
+func loopStatusFromMPD(status mpd.Status) LoopStatus {
+	switch {
+	case !status.Repeat:
+		return LoopStatusNone
+	case !status.Single:
+		return LoopStatusPlaylist
+	default:
+		return LoopStatusTrack
+	}
 }
 
 // Absolute value of a time.Duration.
@@ -210,61 +457,96 @@ func notImplemented(c *prop.Change) *dbus.Error {
 	return dbus.MakeFailedError(errors.New("Not implemented"))
 }
 
-// OnLoopStatus handles LoopStatus change.
+// onLoopStatus handles LoopStatus change.
 // https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Property:LoopStatus
-func (p *Player) OnLoopStatus(c *prop.Change) *dbus.Error {
+func (p *Player) onLoopStatus(c *prop.Change) *dbus.Error {
 	loop := LoopStatus(c.Value.(string))
 	log.Printf("LoopStatus changed to %v\n", loop)
 	p.status.mu.Lock()
 	defer p.status.mu.Unlock()
 	p.status.LoopStatus = loop
+	var repeat, single bool
 	switch loop {
 	case LoopStatusNone:
-		if err := p.mpd.Single(false); err != nil {
-			return p.transformErr(err)
-		}
-		if err := p.mpd.Repeat(false); err != nil {
-			return p.transformErr(err)
-		}
+		repeat, single = false, false
 	case LoopStatusPlaylist:
-		if err := p.mpd.Single(false); err != nil {
-			return p.transformErr(err)
-		}
-		if err := p.mpd.Repeat(true); err != nil {
-			return p.transformErr(err)
-		}
+		repeat, single = true, false
 	case LoopStatusTrack:
-		if err := p.mpd.Single(true); err != nil {
-			return p.transformErr(err)
-		}
-		if err := p.mpd.Repeat(true); err != nil {
-			return p.transformErr(err)
-		}
+		repeat, single = true, true
 	default:
 		return p.transformErr(errors.New("Invalid loop " + string(loop)))
 	}
-	return nil
+	cl := p.mpd.BeginCommandList()
+	cl.Single(single)
+	cl.Repeat(repeat)
+	return p.transformErr(cl.End())
 }
 
-// OnVolume handles volume changes.
-func (p *Player) OnVolume(c *prop.Change) *dbus.Error {
-	val := int(math.Round(c.Value.(float64) * 100))
+// onVolume handles volume changes.
+// Volumes above the limit of the volume policy are lowered to it.
+func (p *Player) onVolume(c *prop.Change) *dbus.Error {
+	val := p.volumeCurve.ToMPD(c.Value.(float64))
 	log.Printf("Volume changed to %v\n", val)
 	p.status.mu.Lock()
 	defer p.status.mu.Unlock()
-	p.status.Volume = c.Value.(float64)
-	if val < 0 {
-		val = 0
+	if !p.status.HasVolume {
+		return errNoVolume
+	}
+	if limit := p.maxMPDVolume(); val > limit {
+		log.Printf("Volume %d is above the limit, lowering it to %d\n", val, limit)
+		val = limit
+		c.Value = p.volumeCurve.FromMPD(limit)
+	}
+	if p.status.fadingOut() {
+		// The fade-out goes on, and restores the new volume at the end.
+		p.status.Volume, p.status.mpdVolume = c.Value.(float64), val
+		return nil
 	}
-	return p.transformErr(p.mpd.SetVolume(val))
+	p.status.stopRamp()
+	err := p.mpd.SetVolume(val)
+	if err == nil {
+		p.status.Volume = c.Value.(float64)
+		p.status.mpdVolume = val
+	}
+	if err != nil {
+		// The mixer may have gone away before we heard of it.
+		b := &batch{}
+		defer p.Instance.props.apply(b)
+		if status, _, ferr := p.status.fetch(p, nil, false); ferr == nil {
+			p.status.updateVolume(p, b, status)
+			if !p.status.HasVolume {
+				return errNoVolume
+			}
+		}
+	}
+	return p.transformErr(err)
 }
 
-// OnShuffle handles Shuffle change.
+// onShuffle handles Shuffle change.
 // https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Property:Shuffle
-func (p *Player) OnShuffle(c *prop.Change) *dbus.Error {
+// With ShuffleQueue, the queue itself is shuffled, and put back in order.
+// During album shuffle, turning Shuffle off ends it, and turning it on does nothing.
+func (p *Player) onShuffle(c *prop.Change) *dbus.Error {
 	log.Printf("Shuffle changed to %v\n", c.Value.(bool))
 	p.status.mu.Lock()
 	defer p.status.mu.Unlock()
+	if p.status.albums != nil {
+		if c.Value.(bool) {
+			return nil
+		}
+		b := &batch{}
+		defer p.Instance.props.apply(b)
+		p.status.endAlbumShuffle(b)
+		return p.status.updateWith(p, b, nil)
+	}
+	if p.shuffleMode == ShuffleQueue {
+		b := &batch{}
+		defer p.Instance.props.apply(b)
+		if c.Value.(bool) {
+			return p.shuffleQueue(b)
+		}
+		return p.unshuffleQueue(b)
+	}
 	p.status.Shuffle = c.Value.(bool)
 	return p.transformErr(p.mpd.Random(c.Value.(bool)))
 }
@@ -283,48 +565,57 @@ func (p *Player) createStatus() {
 	default:
 		playStatus = PlaybackStatusStopped
 	}
-	var loopStatus LoopStatus
-	switch {
-	case !status.Repeat:
-		loopStatus = LoopStatusNone
-	case !status.Single:
-		loopStatus = LoopStatusPlaylist
-	default:
-		loopStatus = LoopStatusTrack
-	}
+	loopStatus := loopStatusFromMPD(status)
 	song, err := p.mpd.CurrentSong()
 	if err != nil {
 		log.Fatalf("Cannot get current song: %+v", err)
 	}
 
-	volume := math.Max(0, float64(status.Volume)/100.0)
+	var volume float64
+	if status.HasVolume() {
+		volume = p.volumeCurve.FromMPD(status.Volume)
+	}
+	trackID := p.songTrackID(status, song)
 
 	p.status = Status{
 		PlaybackStatus: playStatus,
 		LoopStatus:     loopStatus,
 		Shuffle:        status.Random,
 		Volume:         volume,
+		HasVolume:      status.HasVolume(),
+		mpdVolume:      status.Volume,
 		CurrentSong:    song,
+		TrackID:        trackID,
+		Seekable:       status.Seekable,
+		CanGoNext:      status.NextSong != -1,
 		Seek:           status.Seek,
+		resuming:       p.followSong(status, song),
+		bookmarks:      p.songBookmarks(song),
 	}
+	p.status.CanGoPrevious = p.canGoPrevious(status)
 
 	p.props = map[string]*prop.Prop{
 		"PlaybackStatus": newProp(playStatus, nil),
-		"LoopStatus":     newProp(loopStatus, p.OnLoopStatus),
+		"LoopStatus":     newProp(loopStatus, p.onLoopStatus),
 		"Rate":           newProp(1.0, notImplemented),
-		"Shuffle":        newProp(status.Random, p.OnShuffle),
-		"Metadata":       newProp(MapFromSong(song), nil),
-		"Volume":         newProp(volume, p.OnVolume),
+		"Shuffle":        newProp(status.Random, p.onShuffle),
+		"Metadata":       newProp(p.status.metadata(), nil),
+		"Volume": {
+			Value:    volume,
+			Writable: status.HasVolume(),
+			Emit:     prop.EmitTrue,
+			Callback: p.onVolume,
+		},
 		"Position": {
 			Value:    UsFromDuration(status.Seek),
-			Writable: true,
+			Writable: false,
 			Emit:     prop.EmitFalse,
 			Callback: nil,
 		},
 		"MinimumRate":   newProp(1.0, nil),
 		"MaximumRate":   newProp(1.0, nil),
-		"CanGoNext":     newProp(true, nil),
-		"CanGoPrevious": newProp(true, nil),
+		"CanGoNext":     newProp(p.status.CanGoNext, nil),
+		"CanGoPrevious": newProp(p.status.CanGoPrevious, nil),
 		"CanPlay":       newProp(true, nil),
 		"CanPause":      newProp(true, nil),
 		"CanSeek":       newProp(status.Seekable, nil),
@@ -332,11 +623,32 @@ func (p *Player) createStatus() {
 	}
 }
 
-// update pulls the status of the player, and forwards it to the MPRIS interface.
-func (p *Player) update() error {
-	if err := p.status.Update(p); err != nil {
+// signals returns the signals emitted on the `org.mpris.MediaPlayer2.Player` interface.
+func (p *Player) signals() []introspect.Signal {
+	return []introspect.Signal{
+		{Name: "Seeked", Args: []introspect.Arg{signalArg("Position", TimeInUs(0))}},
+	}
+}
+
+// registerHandlers subscribes the player to the MPD events it cares about.
+func (p *Player) registerHandlers() {
+	p.onEvent("player", func() error { return asError(p.status.UpdatePlayer(p)) })
+	p.onEvent("options", func() error { return asError(p.status.UpdateOptions(p)) })
+	p.onEvent("mixer", func() error { return asError(p.status.UpdateVolume(p)) })
+	p.onEvent("output", func() error { return asError(p.status.UpdateVolume(p)) })
+	p.onEvent("playlist", func() error { return asError(p.status.UpdateQueue(p)) })
+	p.onEvent("sticker", func() error { return asError(p.status.UpdateBookmarks(p)) })
+	p.onEvent("stored_playlist", p.storedPlaylistsChanged)
+}
+
+// storedPlaylistsChanged lists the stored playlists again, after one was modified, renamed, created or deleted.
+// Happens on "stored_playlist" events. Nothing exported depends on them yet, so they are only logged.
+func (p *Player) storedPlaylistsChanged() error {
+	files, err := p.mpd.ListPlaylists()
+	if err != nil {
 		return err
 	}
+	log.Printf("Stored playlists changed, %d now\n", len(files))
 	return nil
 }
 
@@ -346,50 +658,48 @@ func (p *Player) update() error {
 // https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Method:Next
 func (p *Player) Next() *dbus.Error {
 	log.Printf("Next requested\n")
-	if err := p.transformErr(p.Instance.mpd.Next()); err != nil {
-		return err
-	}
-	return p.status.Update(p)
+	return p.status.UpdateWith(p, func(cl *mpd.CommandList) { cl.Next() })
 }
 
-// Previous skips to the previous track in the tracklist.
+// Previous skips to the previous track in the tracklist, or, while the queue is shuffled,
+// to the track played before the current one (unless NativePrevious is set).
 // https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Method:Previous
 func (p *Player) Previous() *dbus.Error {
 	log.Printf("Previous requested\n")
-	if err := p.transformErr(p.Instance.mpd.Previous()); err != nil {
-		return err
-	}
-	return p.status.Update(p)
+	return p.previous()
 }
 
 // Pause pauses playback.
 // https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Method:Pause
 func (p *Player) Pause() *dbus.Error {
 	log.Printf("Pause requested\n")
-	if err := p.transformErr(p.Instance.mpd.Pause(true)); err != nil {
-		return err
-	}
-	return p.status.Update(p)
+	p.status.mu.Lock()
+	defer p.status.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	return p.fadeOut(b, func(cl *mpd.CommandList) { cl.Pause(true) })
 }
 
 // Play starts or resumes playback.
 // https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Method:Play
 func (p *Player) Play() *dbus.Error {
 	log.Printf("Play requested\n")
-	if err := p.transformErr(p.Instance.mpd.Play(-1)); err != nil {
-		return err
-	}
-	return p.status.Update(p)
+	p.status.mu.Lock()
+	defer p.status.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	return p.fadeIn(b, func(cl *mpd.CommandList) { cl.Play(-1) })
 }
 
 // Stop stops playback.
 // https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Method:Stop
 func (p *Player) Stop() *dbus.Error {
 	log.Printf("Stop requested\n")
-	if err := p.transformErr(p.Instance.mpd.Stop()); err != nil {
-		return err
-	}
-	return p.status.Update(p)
+	p.status.mu.Lock()
+	defer p.status.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	return p.fadeOut(b, func(cl *mpd.CommandList) { cl.Stop() })
 }
 
 // PlayPause toggles playback.
@@ -398,71 +708,98 @@ func (p *Player) Stop() *dbus.Error {
 // https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Method:PlayPause
 func (p *Player) PlayPause() *dbus.Error {
 	log.Printf("Play/Pause requested. Switching context...\n")
-	status, err := p.mpd.Status()
-	if err != nil {
-		return p.transformErr(err)
-	}
-	if status.State == "play" {
-		return p.Pause()
-	}
-	return p.Play()
+	p.status.mu.Lock()
+	defer p.status.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	// The status is kept up-to-date by idle events, so we can decide without asking MPD.
+	// Playback that is fading out is as good as paused.
+	if p.status.PlaybackStatus == PlaybackStatusPlaying && !p.status.fadingOut() {
+		return p.fadeOut(b, func(cl *mpd.CommandList) { cl.Pause(true) })
+	}
+	return p.fadeIn(b, func(cl *mpd.CommandList) { cl.Play(-1) })
 }
 
 // Seek seeks forward in the current track by the specified number of microseconds.
 // https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Method:Seek
 func (p *Player) Seek(x TimeInUs) *dbus.Error {
-	status, err := p.mpd.Status()
-	if err != nil {
-		return p.transformErr(err)
+	p.status.mu.Lock()
+	defer p.status.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	// Get a precise position first.
+	if err := p.status.updateWith(p, b, nil); err != nil {
+		return err
 	}
 
-	if !status.Seekable {
+	if !p.status.Seekable {
 		return nil // Quit silently
 	}
 
 	log.Printf("Seek(%v) requested\n", x.Duration())
-	song, err := p.mpd.CurrentSong()
-	if err != nil {
-		return p.transformErr(err)
+	song := p.status.CurrentSong
+	seekTo := p.status.Seek + x.Duration()
+	if seekTo > song.Duration {
+		return p.status.updateWith(p, b, func(cl *mpd.CommandList) { cl.Next() })
 	}
-	if status.Seek+x.Duration() > song.Duration {
-		return p.Next()
-	}
-	seekTo := UsFromDuration(status.Seek + x.Duration())
-	if status.Seek+x.Duration() < 0 {
+	if seekTo < 0 {
 		seekTo = 0
 	}
-	return p.SetPosition(TrackID(fmt.Sprintf(TrackIDFormat, status.Song)), seekTo)
+	return p.setPosition(b, song.ID, UsFromDuration(seekTo))
 }
 
 // SetPosition sets the current track position in microseconds.
 // https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Method:SetPosition
 func (p *Player) SetPosition(o TrackID, x TimeInUs) *dbus.Error {
-	status, err := p.mpd.Status()
-	if err != nil {
-		return p.transformErr(err)
-	}
+	p.status.mu.Lock()
+	defer p.status.mu.Unlock()
 
-	if !status.Seekable {
+	log.Printf("SetPosition(%v, %v) requested\n", o, x.Duration())
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	// The client may have not seen the latest track change yet: check against the current one.
+	if err := p.status.updateWith(p, b, nil); err != nil {
+		return err
+	}
+	if !p.status.Seekable {
 		return nil // Quit silently
 	}
-
-	log.Printf("SetPosition(%v, %v) requested\n", o, x.Duration())
-	var id int
-	if _, err := fmt.Sscanf(string(o), TrackIDFormat, &id); err != nil {
-		return p.transformErr(err)
+	// Stale track IDs are ignored, as well as track IDs of other players, or NoTrack.
+	_, songID, err := p.parseTrackID(o)
+	if err != nil {
+		log.Printf("SetPosition ignored: %v\n", err)
+		return nil
+	}
+	if o != p.status.TrackID {
+		log.Printf("SetPosition ignored: %v is not the current track\n", o)
+		return nil
 	}
-	if err := p.mpd.SeekID(id, int(x.Duration()/time.Second)); err != nil {
+	// Positions outside of the track are ignored too.
+	if length := p.status.CurrentSong.Duration; x < 0 || (length > 0 && x.Duration() > length) {
+		log.Printf("SetPosition ignored: %v is outside of the track\n", x.Duration())
+		return nil
+	}
+	return p.setPosition(b, songID, x)
+}
+
+// OpenUri adds the song at the given URI to the queue, and starts playing it.
+// https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Method:OpenUri
+func (p *Player) OpenUri(uri string) *dbus.Error {
+	log.Printf("OpenUri(%v) requested\n", uri)
+	id, err := p.mpd.AddID(p.mpd.PathFromURI(uri), -1)
+	if err != nil {
 		return p.transformErr(err)
 	}
-	if err := p.status.Update(p); err != nil {
+	return p.status.UpdateWith(p, func(cl *mpd.CommandList) { cl.PlayID(id) })
+}
+
+// setPosition seeks the song with the given ID, assuming that the status lock is held.
+// Changes are recorded into b.
+func (p *Player) setPosition(b *batch, id int, x TimeInUs) *dbus.Error {
+	if err := p.status.updateWith(p, b, func(cl *mpd.CommandList) { cl.SeekID(id, x.Duration()) }); err != nil {
 		return err
 	}
 	// Unnatural seek, create signal
-	return p.Seeked(x)
-}
-
-// Emit the Seeked DBus signal.
-func (p *Player) Seeked(x TimeInUs) *dbus.Error {
-	return p.transformErr(p.dbus.Emit("/org/mpris/MediaPlayer2", "org.mpris.MediaPlayer2.Player.Seeked", x))
+	b.seek(x)
+	return nil
 }
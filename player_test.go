@@ -0,0 +1,466 @@
+package mpris
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd"
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+// newTestPlayer starts a fake MPD server with the state prepared by setup,
+// and creates an instance connected to it with opts, without D-Bus.
+// Emitted changes are kept in the emitter's queue; see `drain`.
+func newTestPlayer(t *testing.T, setup func(st *mpdtest.State), opts ...Option) (*Player, *mpdtest.Server) {
+	t.Helper()
+	s, err := mpdtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if setup != nil {
+		s.Modify(setup)
+	}
+	c, err := mpd.Dial(s.Network(), s.Addr())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	ins, err := newInstance(c, opts...)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return ins.player, s
+}
+
+// drain merges all batches queued for emission.
+func drain(p *Player) *batch {
+	b := &batch{}
+	for {
+		select {
+		case other := <-p.emitter.batches:
+			b.merge(other)
+		default:
+			return b
+		}
+	}
+}
+
+// changed returns the value of a changed property of the Player interface in b.
+func (b *batch) changed(name string) (interface{}, bool) {
+	for _, c := range b.props {
+		if c.iface == "org.mpris.MediaPlayer2.Player" && c.name == name {
+			return c.value, true
+		}
+	}
+	return nil, false
+}
+
+func getProp(t *testing.T, p *Player, name string) interface{} {
+	t.Helper()
+	v, err := p.Instance.props.Get("org.mpris.MediaPlayer2.Player", name)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", name, err)
+	}
+	return v.Value()
+}
+
+func mustOK(t *testing.T, err *dbus.Error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func threeSongs(st *mpdtest.State) {
+	st.SetQueue(
+		mpdtest.Song{"file": "a.flac", "Title": "A", "duration": "100"},
+		mpdtest.Song{"file": "b.flac", "Title": "B", "duration": "200"},
+		mpdtest.Song{"file": "c.flac", "Title": "C", "duration": "300"},
+	)
+}
+
+func current(s *mpdtest.Server) (pos int, playback string, elapsed time.Duration) {
+	s.Inspect(func(st *mpdtest.State) { pos, playback, elapsed = st.Current, st.Playback, st.Elapsed })
+	return
+}
+
+func TestPlayerPlayPauseStop(t *testing.T) {
+	p, s := newTestPlayer(t, threeSongs)
+
+	mustOK(t, p.Play())
+	if pos, playback, _ := current(s); pos != 0 || playback != "play" {
+		t.Fatalf("after Play: pos %d, %s", pos, playback)
+	}
+	b := drain(p)
+	if v, _ := b.changed("PlaybackStatus"); v != PlaybackStatusPlaying {
+		t.Errorf("PlaybackStatus changed to %v, want %v", v, PlaybackStatusPlaying)
+	}
+	if _, ok := b.changed("Metadata"); !ok {
+		t.Error("Metadata should change when a song starts")
+	}
+
+	mustOK(t, p.Pause())
+	if _, playback, _ := current(s); playback != "pause" {
+		t.Fatalf("after Pause: %s", playback)
+	}
+	if v := getProp(t, p, "PlaybackStatus"); v != PlaybackStatusPaused {
+		t.Errorf("PlaybackStatus = %v, want %v", v, PlaybackStatusPaused)
+	}
+
+	mustOK(t, p.PlayPause())
+	if _, playback, _ := current(s); playback != "play" {
+		t.Fatalf("after PlayPause: %s", playback)
+	}
+	mustOK(t, p.PlayPause())
+	if _, playback, _ := current(s); playback != "pause" {
+		t.Fatalf("after second PlayPause: %s", playback)
+	}
+
+	mustOK(t, p.Stop())
+	if _, playback, _ := current(s); playback != "stop" {
+		t.Fatalf("after Stop: %s", playback)
+	}
+	if v := getProp(t, p, "PlaybackStatus"); v != PlaybackStatusStopped {
+		t.Errorf("PlaybackStatus = %v, want %v", v, PlaybackStatusStopped)
+	}
+}
+
+func TestPlayerNextPrevious(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Play(1)
+	})
+	if v := getProp(t, p, "CanGoNext"); v != true {
+		t.Errorf("CanGoNext = %v, want true", v)
+	}
+
+	mustOK(t, p.Next())
+	if pos, _, _ := current(s); pos != 2 {
+		t.Fatalf("after Next: pos %d", pos)
+	}
+	if v := getProp(t, p, "CanGoNext"); v != false {
+		t.Errorf("CanGoNext on the last song = %v, want false", v)
+	}
+	if title := p.status.CurrentSong.Title; title != "C" {
+		t.Errorf("current song = %q, want %q", title, "C")
+	}
+
+	mustOK(t, p.Previous())
+	if pos, _, _ := current(s); pos != 1 {
+		t.Fatalf("after Previous: pos %d", pos)
+	}
+	if v := getProp(t, p, "CanGoNext"); v != true {
+		t.Errorf("CanGoNext = %v, want true", v)
+	}
+}
+
+func TestPlayerSeek(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Play(0)
+		st.Elapsed = 10 * time.Second
+	})
+	drain(p)
+
+	mustOK(t, p.Seek(UsFromDuration(30*time.Second)))
+	if _, _, elapsed := current(s); elapsed != 40*time.Second {
+		t.Errorf("elapsed = %v, want 40s", elapsed)
+	}
+	b := drain(p)
+	if b.seeked == nil || *b.seeked != UsFromDuration(40*time.Second) {
+		t.Errorf("Seeked = %v, want 40s", b.seeked)
+	}
+
+	mustOK(t, p.Seek(UsFromDuration(-time.Minute)))
+	if _, _, elapsed := current(s); elapsed != 0 {
+		t.Errorf("elapsed = %v, want 0", elapsed)
+	}
+
+	// Seeking past the end skips to the next song.
+	mustOK(t, p.Seek(UsFromDuration(time.Hour)))
+	if pos, _, _ := current(s); pos != 1 {
+		t.Errorf("after seeking past the end: pos %d, want 1", pos)
+	}
+}
+
+func TestPlayerSetPosition(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Play(1)
+	})
+	drain(p)
+
+	trackID := p.status.TrackID
+	mustOK(t, p.SetPosition(trackID, UsFromDuration(50*time.Second)))
+	if _, _, elapsed := current(s); elapsed != 50*time.Second {
+		t.Errorf("elapsed = %v, want 50s", elapsed)
+	}
+	if b := drain(p); b.seeked == nil || *b.seeked != UsFromDuration(50*time.Second) {
+		t.Errorf("Seeked = %v, want 50s", b.seeked)
+	}
+
+	// Track IDs of other songs, of songs that are no longer current and of other players are ignored.
+	s.Modify(func(st *mpdtest.State) { st.Play(2) })
+	s.ResetCommands()
+	for _, o := range []TrackID{trackID, NoTrack, p.trackID(1, 3), "/not/a/track"} {
+		mustOK(t, p.SetPosition(o, 0))
+	}
+	for _, cmd := range s.Commands() {
+		if strings.HasPrefix(cmd, "seek") {
+			t.Errorf("unexpected %q", cmd)
+		}
+	}
+	if p.status.TrackID == trackID {
+		t.Errorf("the track ID should change with the current song")
+	}
+	mustOK(t, p.SetPosition(p.status.TrackID, UsFromDuration(10*time.Second)))
+	if pos, _, elapsed := current(s); pos != 2 || elapsed != 10*time.Second {
+		t.Errorf("pos %d at %v, want pos 2 at 10s", pos, elapsed)
+	}
+
+	// So are positions before the start or past the end of the track.
+	s.ResetCommands()
+	for _, x := range []time.Duration{-time.Second, 301 * time.Second} {
+		mustOK(t, p.SetPosition(p.status.TrackID, UsFromDuration(x)))
+	}
+	for _, cmd := range s.Commands() {
+		if strings.HasPrefix(cmd, "seek") {
+			t.Errorf("unexpected %q", cmd)
+		}
+	}
+	if _, _, elapsed := current(s); elapsed != 10*time.Second {
+		t.Errorf("elapsed = %v, want 10s", elapsed)
+	}
+}
+
+func TestTrackID(t *testing.T) {
+	for _, test := range []struct {
+		name, path string
+	}{
+		{"org.mpris.MediaPlayer2.mpd", "/org/mpd/MediaPlayer2/Track"},
+		{"org.mpris.MediaPlayer2.mpd.instance1234", "/org/mpd/MediaPlayer2/instance1234/Track"},
+		{"org.mpris.MediaPlayer2.mpd.my-partition", "/org/mpd/MediaPlayer2/my_partition/Track"},
+	} {
+		ins := &Instance{name: test.name}
+		if path := ins.trackIDPath(); path != test.path {
+			t.Errorf("trackIDPath() = %s, want %s", path, test.path)
+		}
+		id := ins.trackID(4, 12)
+		if !id.IsValid() {
+			t.Errorf("%s is not a valid object path", id)
+		}
+		if version, songID, err := ins.parseTrackID(id); err != nil || version != 4 || songID != 12 {
+			t.Errorf("parseTrackID(%s) = %d, %d, %v", id, version, songID, err)
+		}
+	}
+
+	ins := &Instance{name: "org.mpris.MediaPlayer2.mpd.a"}
+	for _, o := range []TrackID{
+		"/org/mpd/MediaPlayer2/b/Track/4/12",
+		"/org/mpd/MediaPlayer2/a/Track/12",
+		"/org/mpd/MediaPlayer2/a/Track/4/12/1",
+		"/org/mpd/MediaPlayer2/a/Track/4/012",
+		"/org/mpd/MediaPlayer2/a/Track/+4/12",
+		"/org/mpd/MediaPlayer2/a/Track/4/",
+		NoTrack,
+	} {
+		if _, _, err := ins.parseTrackID(o); err == nil {
+			t.Errorf("parseTrackID(%s) should fail", o)
+		}
+	}
+}
+
+func TestPlayerUnseekable(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		st.SetQueue(mpdtest.Song{"file": "http://radio.example/stream"})
+		st.Play(0)
+	})
+	if v := getProp(t, p, "CanSeek"); v != false {
+		t.Errorf("CanSeek = %v, want false", v)
+	}
+	s.ResetCommands()
+	mustOK(t, p.SetPosition(p.status.TrackID, 0))
+	for _, cmd := range s.Commands() {
+		if strings.HasPrefix(cmd, "seek") {
+			t.Errorf("unexpected %q", cmd)
+		}
+	}
+
+	// Whether the current song is seekable is checked again, before its event.
+	s.Modify(func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Play(0)
+	})
+	var trackID TrackID
+	s.Inspect(func(st *mpdtest.State) { trackID = p.trackID(st.QueueVersion, st.Queue[st.Current].ID) })
+	mustOK(t, p.SetPosition(trackID, UsFromDuration(10*time.Second)))
+	if _, _, elapsed := current(s); elapsed != 10*time.Second {
+		t.Errorf("elapsed = %v, want 10s", elapsed)
+	}
+}
+
+func TestPlayerOpenUri(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		st.Config["music_directory"] = "/music"
+		st.Library = []mpdtest.Song{{"file": "x/y.flac", "Title": "Y"}}
+	})
+
+	mustOK(t, p.OpenUri("file:///music/x/y.flac"))
+	if pos, playback, _ := current(s); pos != 0 || playback != "play" {
+		t.Fatalf("after OpenUri: pos %d, %s", pos, playback)
+	}
+	if title := p.status.CurrentSong.Title; title != "Y" {
+		t.Errorf("current song = %q, want %q", title, "Y")
+	}
+
+	if err := p.OpenUri("file:///music/missing.flac"); err == nil {
+		t.Error("OpenUri of a missing file should fail")
+	}
+}
+
+func TestPlayerProperties(t *testing.T) {
+	p, s := newTestPlayer(t, threeSongs)
+
+	for loop, want := range map[LoopStatus][2]bool{
+		LoopStatusPlaylist: {true, false},
+		LoopStatusTrack:    {true, true},
+		LoopStatusNone:     {false, false},
+	} {
+		mustOK(t, p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "LoopStatus", dbus.MakeVariant(loop)))
+		var repeat, single bool
+		s.Inspect(func(st *mpdtest.State) { repeat, single = st.Repeat, st.Single })
+		if repeat != want[0] || single != want[1] {
+			t.Errorf("LoopStatus %s: repeat %v single %v, want %v", loop, repeat, single, want)
+		}
+	}
+	if err := p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "LoopStatus", dbus.MakeVariant("Forever")); err == nil {
+		t.Error("setting an invalid LoopStatus should fail")
+	}
+
+	mustOK(t, p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "Shuffle", dbus.MakeVariant(true)))
+	s.Inspect(func(st *mpdtest.State) {
+		if !st.Random {
+			t.Error("Shuffle should enable random")
+		}
+	})
+
+	mustOK(t, p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "Volume", dbus.MakeVariant(0.25)))
+	s.Inspect(func(st *mpdtest.State) {
+		if st.Volume != 25 {
+			t.Errorf("volume = %d, want 25", st.Volume)
+		}
+	})
+
+	if err := p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "PlaybackStatus", dbus.MakeVariant("Playing")); err == nil {
+		t.Error("PlaybackStatus should be read-only")
+	}
+}
+
+func TestPlayerNoMixer(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Volume = -1
+	})
+	setVolume := func(v float64) *dbus.Error {
+		return p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "Volume", dbus.MakeVariant(v))
+	}
+	mixerEvent := func() {
+		for _, h := range p.handlers["mixer"] {
+			if err := h(); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+
+	if err := setVolume(0.5); err == nil {
+		t.Error("Volume should be read-only without a mixer")
+	}
+	if v := getProp(t, p, "Volume"); v != 0.0 {
+		t.Errorf("Volume = %v, want 0", v)
+	}
+
+	s.Modify(func(st *mpdtest.State) { st.Volume = 40 }, "mixer")
+	mixerEvent()
+	if v := getProp(t, p, "Volume"); v != 0.4 {
+		t.Errorf("Volume = %v, want 0.4", v)
+	}
+	mustOK(t, setVolume(0.5))
+
+	// The mixer goes away, before the event is handled.
+	s.Modify(func(st *mpdtest.State) { st.Volume = -1 }, "mixer")
+	if err := setVolume(0.6); err == nil || err.Name != errNoVolume.Name {
+		t.Errorf("setting the volume without a mixer: got %v, want %v", err, errNoVolume)
+	}
+	mixerEvent()
+	if err := setVolume(0.6); err == nil {
+		t.Error("Volume should be read-only without a mixer")
+	}
+	if v := getProp(t, p, "Volume"); v != 0.5 {
+		t.Errorf("Volume = %v, should stay at 0.5", v)
+	}
+}
+
+func TestPlayerEvents(t *testing.T) {
+	p, s := newTestPlayer(t, threeSongs)
+	drain(p)
+
+	s.Modify(func(st *mpdtest.State) { st.Play(2) }, "player")
+	for _, h := range p.handlers["player"] {
+		if err := h(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	b := drain(p)
+	if v, _ := b.changed("PlaybackStatus"); v != PlaybackStatusPlaying {
+		t.Errorf("PlaybackStatus changed to %v, want %v", v, PlaybackStatusPlaying)
+	}
+	if v, _ := b.changed("CanGoNext"); v != nil {
+		t.Errorf("CanGoNext changed to %v on the last song, but it was already false", v)
+	}
+
+	s.Modify(func(st *mpdtest.State) { st.Repeat = true }, "options")
+	for _, h := range p.handlers["options"] {
+		if err := h(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	b = drain(p)
+	if v, _ := b.changed("LoopStatus"); v != LoopStatusPlaylist {
+		t.Errorf("LoopStatus changed to %v, want %v", v, LoopStatusPlaylist)
+	}
+	if v, _ := b.changed("CanGoNext"); v != true {
+		t.Errorf("CanGoNext changed to %v with repeat, want true", v)
+	}
+
+	// Stored playlists change nothing exported.
+	s.Modify(func(st *mpdtest.State) { st.Playlists["mix"] = []string{"a.flac"} }, "stored_playlist")
+	if len(p.handlers["stored_playlist"]) == 0 {
+		t.Error("no handler for stored_playlist events")
+	}
+	for _, h := range p.handlers["stored_playlist"] {
+		if err := h(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if b = drain(p); !b.empty() {
+		t.Errorf("changes %+v on a stored_playlist event", b)
+	}
+}
+
+func TestPlayerErrors(t *testing.T) {
+	p, s := newTestPlayer(t, threeSongs)
+
+	s.Fail("play", 52 /* ACK_ERROR_SYSTEM */, "playback failed")
+	if err := p.Play(); err == nil {
+		t.Error("Play should report MPD's error")
+	}
+	// The status is still refreshed afterwards.
+	mustOK(t, p.Play())
+	if _, playback, _ := current(s); playback != "play" {
+		t.Errorf("after Play: %s", playback)
+	}
+}
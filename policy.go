@@ -0,0 +1,236 @@
+package mpris
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements the volume policy: limits on the volume, and a ramp when playback starts,
+// as well as the volume ramps used by it and by fades.
+
+// VolumePolicy limits the volume, whoever sets it.
+// Volumes are MPRIS volumes, from 0 to 1.
+type VolumePolicy struct {
+	// MaxVolume is the highest volume. 0 means no limit.
+	MaxVolume float64
+	// QuietHours lower the highest volume during parts of the day.
+	QuietHours []QuietHours
+	// RampOnPlay raises the volume from 0 over this duration when playback starts or resumes. 0 disables it.
+	RampOnPlay time.Duration
+}
+
+// QuietHours limit the volume between two times of the day.
+type QuietHours struct {
+	// From and To are times of the day, as durations since midnight.
+	// If To is before From, the quiet hours span midnight.
+	From, To  time.Duration
+	MaxVolume float64
+}
+
+// ParseQuietHours parses quiet hours written as "22:00-07:00=0.3", or "22:00-07:00=30%".
+func ParseQuietHours(s string) (QuietHours, error) {
+	var q QuietHours
+	span, max, ok := strings.Cut(s, "=")
+	if !ok {
+		return q, errors.Errorf("quiet hours %q: missing the maximum volume", s)
+	}
+	from, to, ok := strings.Cut(span, "-")
+	if !ok {
+		return q, errors.Errorf("quiet hours %q: expected a range of times, like 22:00-07:00", s)
+	}
+	var err error
+	if q.From, err = parseTimeOfDay(from); err != nil {
+		return q, errors.Wrapf(err, "quiet hours %q", s)
+	}
+	if q.To, err = parseTimeOfDay(to); err != nil {
+		return q, errors.Wrapf(err, "quiet hours %q", s)
+	}
+	if q.MaxVolume, err = ParseVolume(max); err != nil {
+		return q, errors.Wrapf(err, "quiet hours %q", s)
+	}
+	return q, nil
+}
+
+// ParseVolume parses an MPRIS volume, written as a number from 0 to 1 (e.g. "0.3") or as a percentage (e.g. "30%").
+func ParseVolume(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	scale := 1.0
+	if strings.HasSuffix(s, "%") {
+		s, scale = strings.TrimSuffix(s, "%"), 100
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v/scale < 0 || v/scale > 1 {
+		return 0, errors.Errorf("%q is not a volume between 0 and 1 (or 0%% and 100%%)", s)
+	}
+	return v / scale, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, errors.Errorf("%q is not a time of the day, like 07:00", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// String returns the quiet hours in the form parsed by ParseQuietHours.
+func (q QuietHours) String() string {
+	return fmt.Sprintf("%s-%s=%v", formatTimeOfDay(q.From), formatTimeOfDay(q.To), q.MaxVolume)
+}
+
+// formatTimeOfDay formats a time of the day, as a duration since midnight, in the form parsed by parseTimeOfDay.
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+// contains returns whether the time of the day t falls within the quiet hours.
+func (q QuietHours) contains(t time.Time) bool {
+	y, m, d := t.Date()
+	sinceMidnight := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if q.From <= q.To {
+		return q.From <= sinceMidnight && sinceMidnight < q.To
+	}
+	return sinceMidnight >= q.From || sinceMidnight < q.To
+}
+
+// maxVolume returns the highest volume allowed at the given time.
+func (p VolumePolicy) maxVolume(t time.Time) float64 {
+	max := 1.0
+	if p.MaxVolume > 0 {
+		max = p.MaxVolume
+	}
+	for _, q := range p.QuietHours {
+		if q.contains(t) {
+			max = math.Min(max, q.MaxVolume)
+		}
+	}
+	return max
+}
+
+// maxMPDVolume returns the highest MPD volume allowed now.
+func (p *Player) maxMPDVolume() int {
+	max := p.volumePolicy.maxVolume(time.Now())
+	m := p.volumeCurve.ToMPD(max)
+	// ToMPD rounds, which may go over the limit.
+	for m > 0 && p.volumeCurve.FromMPD(m) > max+1e-9 {
+		m--
+	}
+	return m
+}
+
+// pollQuietHours lowers the volume when quiet hours start.
+func (p *Player) pollQuietHours(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.status.UpdateVolume(p); err != nil {
+				log.Printf("Cannot apply the quiet hours: %v\n", err)
+			}
+		}
+	}
+}
+
+// rampOnPlay raises the volume from 0, if the policy says so, as playback starts or resumes.
+// It assumes that the status lock is held.
+func (p *Player) rampOnPlay() {
+	s := &p.status
+	if p.volumePolicy.RampOnPlay <= 0 || !s.HasVolume || s.ramp != nil {
+		return
+	}
+	target := s.mpdVolume
+	if limit := p.maxMPDVolume(); target > limit {
+		target = limit
+	}
+	p.rampVolume(0, target, p.volumePolicy.RampOnPlay, nil)
+}
+
+// The interval between the steps of volume ramps.
+const rampInterval = 50 * time.Millisecond
+
+// A volumeRamp changes MPD's volume gradually, in the background.
+// While it runs, the Volume property keeps the volume set by the user.
+type volumeRamp struct {
+	current int // The volume last set by the ramp
+	to      int
+	// then runs once the ramp ends, with the status lock held. Fade-outs use it to pause or stop, and restore the volume.
+	then func()
+	stop chan struct{} // Closed when the ramp is stopped
+}
+
+// rampVolume changes MPD's volume gradually from one volume to another, over d, then runs then (if not nil).
+// It assumes that the status lock is held, and replaces any running ramp.
+func (p *Player) rampVolume(from, to int, d time.Duration, then func()) {
+	s := &p.status
+	s.stopRamp()
+	if err := p.mpd.SetVolume(from); err != nil {
+		log.Printf("Cannot ramp the volume: %v\n", err)
+		if then != nil {
+			then()
+		}
+		return
+	}
+	r := &volumeRamp{current: from, to: to, then: then, stop: make(chan struct{})}
+	s.ramp = r
+	steps := int(d / rampInterval)
+	if steps < 1 {
+		steps = 1
+	}
+	go func() {
+		ticker := time.NewTicker(rampInterval)
+		defer ticker.Stop()
+		for remaining := steps; remaining > 0; remaining-- {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+			s.mu.Lock()
+			if s.ramp != r {
+				s.mu.Unlock()
+				return
+			}
+			// Steps are relative to the current volume, which others may have changed.
+			m := r.current + (r.to-r.current)/remaining
+			err := p.mpd.SetVolume(m)
+			if err != nil {
+				log.Printf("Cannot ramp the volume: %v\n", err)
+			}
+			r.current = m
+			done := err != nil || remaining == 1
+			if done {
+				s.ramp = nil
+				if r.then != nil {
+					r.then()
+				}
+			}
+			s.mu.Unlock()
+			if done {
+				return
+			}
+		}
+	}()
+}
+
+// stopRamp stops the running volume ramp, if any, where it is. It assumes that the status lock is held.
+func (s *Status) stopRamp() {
+	if s.ramp != nil {
+		close(s.ramp.stop)
+		s.ramp = nil
+	}
+}
+
+// fadingOut returns whether the volume is fading out, before pausing or stopping.
+func (s *Status) fadingOut() bool {
+	return s.ramp != nil && s.ramp.then != nil
+}
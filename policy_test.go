@@ -0,0 +1,146 @@
+package mpris
+
+import (
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	q, err := ParseQuietHours("22:00-07:30=30%")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if want := (QuietHours{From: 22 * time.Hour, To: 7*time.Hour + 30*time.Minute, MaxVolume: 0.3}); q != want {
+		t.Errorf("ParseQuietHours = %v, want %v", q, want)
+	}
+	if q, err := ParseQuietHours(q.String()); err != nil || q.MaxVolume != 0.3 {
+		t.Errorf("ParseQuietHours(%q) = %v, %v", q.String(), q, err)
+	}
+	for _, s := range []string{"22:00-07:00", "22:00=0.3", "22:00-25:00=0.3", "22:00-07:00=130%", "22:00-07:00=loud"} {
+		if _, err := ParseQuietHours(s); err == nil {
+			t.Errorf("ParseQuietHours(%q) should fail", s)
+		}
+	}
+}
+
+func TestVolumePolicyMaxVolume(t *testing.T) {
+	policy := VolumePolicy{
+		MaxVolume: 0.8,
+		QuietHours: []QuietHours{
+			{From: 22 * time.Hour, To: 7 * time.Hour, MaxVolume: 0.3},
+			{From: 13 * time.Hour, To: 14 * time.Hour, MaxVolume: 0.5},
+		},
+	}
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+	for _, test := range []struct {
+		at   time.Duration
+		want float64
+	}{
+		{12 * time.Hour, 0.8},
+		{13 * time.Hour, 0.5},
+		{14 * time.Hour, 0.8},
+		{21*time.Hour + 59*time.Minute, 0.8},
+		{22 * time.Hour, 0.3},
+		{3 * time.Hour, 0.3},
+		{7 * time.Hour, 0.8},
+	} {
+		if max := policy.maxVolume(day.Add(test.at)); max != test.want {
+			t.Errorf("maxVolume at %v = %v, want %v", test.at, max, test.want)
+		}
+	}
+	if max := (VolumePolicy{}).maxVolume(day); max != 1 {
+		t.Errorf("maxVolume without limits = %v, want 1", max)
+	}
+}
+
+func TestPlayerVolumeLimit(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Volume = 20
+	}, LimitVolume(VolumePolicy{MaxVolume: 0.5}))
+	drain(p)
+	mpdVolume := func() (v int) {
+		s.Inspect(func(st *mpdtest.State) { v = st.Volume })
+		return
+	}
+
+	mustOK(t, p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "Volume", dbus.MakeVariant(0.9)))
+	if v := mpdVolume(); v != 50 {
+		t.Errorf("volume = %d, want 50", v)
+	}
+	if v := getProp(t, p, "Volume"); v != 0.5 {
+		t.Errorf("Volume = %v, want 0.5", v)
+	}
+	drain(p)
+
+	// Other clients are capped too.
+	s.Modify(func(st *mpdtest.State) { st.Volume = 100 }, "mixer")
+	for _, h := range p.handlers["mixer"] {
+		if err := h(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if v := mpdVolume(); v != 50 {
+		t.Errorf("volume = %d, want 50", v)
+	}
+	if v, _ := drain(p).changed("Volume"); v != nil {
+		t.Errorf("Volume changed to %v, but it stayed at the limit", v)
+	}
+
+	v, err := p.extension.StepVolume(1)
+	mustOK(t, err)
+	if v != 0.5 {
+		t.Errorf("StepVolume(1) = %v, want 0.5", v)
+	}
+}
+
+// waitForVolume waits until MPD's volume is v.
+func waitForVolume(t *testing.T, s *mpdtest.Server, v int) {
+	t.Helper()
+	var got int
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		s.Inspect(func(st *mpdtest.State) { got = st.Volume })
+		if got == v {
+			return
+		}
+	}
+	t.Fatalf("volume = %d, want %d", got, v)
+}
+
+func TestPlayerRampOnPlay(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Volume = 60
+	}, LimitVolume(VolumePolicy{RampOnPlay: 300 * time.Millisecond}))
+	drain(p)
+
+	mustOK(t, p.Play())
+	var start int
+	s.Inspect(func(st *mpdtest.State) { start = st.Volume })
+	if start >= 60 {
+		t.Errorf("volume = %d when playback starts, should ramp up from 0", start)
+	}
+	waitForVolume(t, s, 60)
+	if v, ok := drain(p).changed("Volume"); ok {
+		t.Errorf("Volume changed to %v during the ramp", v)
+	}
+
+	// Changing the volume stops the ramp.
+	mustOK(t, p.Stop())
+	mustOK(t, p.Play())
+	mustOK(t, p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "Volume", dbus.MakeVariant(0.1)))
+	time.Sleep(400 * time.Millisecond)
+	waitForVolume(t, s, 10)
+
+	// Resuming from pause ramps too.
+	mustOK(t, p.Pause())
+	mustOK(t, p.Play())
+	s.Inspect(func(st *mpdtest.State) { start = st.Volume })
+	if start >= 10 {
+		t.Errorf("volume = %d when playback resumes, should ramp up from 0", start)
+	}
+	waitForVolume(t, s, 10)
+}
@@ -1,16 +1,156 @@
 package mpris
 
 import (
+	"reflect"
+	"sync"
+
 	"github.com/godbus/dbus/v5"
 	"github.com/godbus/dbus/v5/prop"
 )
 
-// Creates a new property.
+// Creates a new property. It is writable by clients only if it has a callback.
 func newProp(value interface{}, cb func(*prop.Change) *dbus.Error) *prop.Prop {
 	return &prop.Prop{
 		Value:    value,
-		Writable: true,
+		Writable: cb != nil,
 		Emit:     prop.EmitTrue,
 		Callback: cb,
 	}
 }
+
+// Properties implements the `org.freedesktop.DBus.Properties` interface over a set of `prop.Prop`s.
+//
+// Unlike `prop.Properties`, changing a value does not emit a signal by itself:
+// changes are handed to the instance's emitter, which sends every change of an update in one `PropertiesChanged`.
+type Properties struct {
+	mu sync.RWMutex
+	m  prop.Map
+
+	emitter *emitter
+}
+
+// newProperties creates a set of properties from their definitions.
+func newProperties(m prop.Map, e *emitter) *Properties {
+	return &Properties{m: m, emitter: e}
+}
+
+// lookup finds a property definition.
+func (p *Properties) lookup(iface, name string) (*prop.Prop, *dbus.Error) {
+	props, ok := p.m[iface]
+	if !ok {
+		return nil, prop.ErrIfaceNotFound
+	}
+	pr, ok := props[name]
+	if !ok {
+		return nil, prop.ErrPropNotFound
+	}
+	return pr, nil
+}
+
+// Get implements org.freedesktop.DBus.Properties.Get.
+func (p *Properties) Get(iface, name string) (dbus.Variant, *dbus.Error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	pr, err := p.lookup(iface, name)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	return dbus.MakeVariant(pr.Value), nil
+}
+
+// GetAll implements org.freedesktop.DBus.Properties.GetAll.
+func (p *Properties) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	props, ok := p.m[iface]
+	if !ok {
+		return nil, prop.ErrIfaceNotFound
+	}
+	all := make(map[string]dbus.Variant, len(props))
+	for name, pr := range props {
+		all[name] = dbus.MakeVariant(pr.Value)
+	}
+	return all, nil
+}
+
+// Set implements org.freedesktop.DBus.Properties.Set.
+// The property's callback is run first, and the value is only changed if it succeeds.
+// The callback may change the value that is set, e.g. to clamp it.
+func (p *Properties) Set(iface, name string, value dbus.Variant) *dbus.Error {
+	p.mu.RLock()
+	pr, err := p.lookup(iface, name)
+	writable := err == nil && pr.Writable
+	p.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if !writable {
+		return prop.ErrReadOnly
+	}
+	if value.Signature() != dbus.SignatureOf(pr.Value) {
+		return prop.ErrInvalidArg
+	}
+	change := &prop.Change{Iface: iface, Name: name, Value: value.Value()}
+	if pr.Callback != nil {
+		if err := pr.Callback(change); err != nil {
+			return err
+		}
+	}
+	b := &batch{}
+	b.set(iface, name, change.Value)
+	p.apply(b)
+	return nil
+}
+
+// setWritable changes whether clients can set a property.
+// It is reflected in the introspection data, but not signalled.
+func (p *Properties) setWritable(iface, name string, writable bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pr, err := p.lookup(iface, name); err == nil {
+		pr.Writable = writable
+	}
+}
+
+// value returns the current value of a property.
+func (p *Properties) value(iface, name string) interface{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.m[iface][name].Value
+}
+
+// apply stores the values of all changes in b, then hands b to the emitter.
+func (p *Properties) apply(b *batch) {
+	if b.empty() {
+		return
+	}
+	p.mu.Lock()
+	for _, c := range b.props {
+		if pr, err := p.lookup(c.iface, c.name); err == nil {
+			pr.Value = convertLike(c.value, pr.Value)
+		}
+	}
+	p.mu.Unlock()
+	p.emitter.push(b)
+}
+
+// emits returns how changes of a property are signalled.
+func (p *Properties) emits(iface, name string) prop.EmitType {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	pr, err := p.lookup(iface, name)
+	if err != nil {
+		return prop.EmitFalse
+	}
+	return pr.Emit
+}
+
+// convertLike converts v to the type of like, if they share the same underlying kind (e.g. `string` and `PlaybackStatus`).
+func convertLike(v, like interface{}) interface{} {
+	val := reflect.ValueOf(v)
+	t := reflect.TypeOf(like)
+	if t == nil || val.Type() == t || val.Kind() != t.Kind() || !val.Type().ConvertibleTo(t) {
+		return v
+	}
+	return val.Convert(t).Interface()
+}
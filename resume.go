@@ -0,0 +1,309 @@
+package mpris
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/natsukagami/mpd-mpris/mpd"
+	"github.com/pkg/errors"
+)
+
+// This file implements resume positions: long tracks, like audiobooks and podcasts, remember where they were left,
+// and start from there the next time they play.
+
+const (
+	// The sticker keeping resume positions, in seconds.
+	resumeSticker = "resume-position"
+	// Tracks starting before this are resumed. Positions before it are not worth remembering.
+	resumeNearStart = 5 * time.Second
+	// Tracks left within this of their end are finished, and start over the next time.
+	resumeNearEnd = 30 * time.Second
+	// How often the position of a playing track is remembered, should mpd-mpris not see it stop.
+	resumeSaveInterval = 30 * time.Second
+)
+
+// ResumePolicy decides which tracks remember their position, to start from it the next time they play.
+// Streams never do.
+type ResumePolicy struct {
+	// Tracks longer than MinLength remember their position. Zero means that the length does not matter.
+	MinLength time.Duration
+	// Tracks under one of Paths, relative to MPD's music directory (e.g. "Audiobooks"),
+	// or with one of Genres (e.g. "Podcast", in any case), remember their position whatever their length.
+	Paths  []string
+	Genres []string
+	// StateFile keeps the positions, if set. Otherwise, they are kept in MPD's stickers, shared with other clients.
+	StateFile string
+}
+
+// enabled returns whether any track remembers its position.
+func (r ResumePolicy) enabled() bool {
+	return r.MinLength > 0 || len(r.Paths) > 0 || len(r.Genres) > 0
+}
+
+// remembers returns whether song remembers its position.
+func (r ResumePolicy) remembers(song mpd.Song) bool {
+	path := song.Path()
+	if song.ID == -1 || strings.Contains(path, "://") {
+		return false
+	}
+	if r.MinLength > 0 && song.Duration > r.MinLength {
+		return true
+	}
+	for _, dir := range r.Paths {
+		dir = strings.TrimSuffix(dir, "/")
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	for _, genre := range r.Genres {
+		if strings.EqualFold(song.Genre, genre) {
+			return true
+		}
+	}
+	return false
+}
+
+// A positionStore keeps resume positions, by song URI.
+type positionStore interface {
+	// position returns the position of uri, and whether it has one.
+	position(uri string) (time.Duration, bool, error)
+	setPosition(uri string, pos time.Duration) error
+	clearPosition(uri string) error
+}
+
+// newPositionStore returns the store of positions for the policy.
+func newPositionStore(c *mpd.Client, policy ResumePolicy) (positionStore, error) {
+	if policy.StateFile != "" {
+		return newPositionFile(policy.StateFile)
+	}
+	if !c.HasStickers {
+		log.Printf("MPD has no sticker database, so tracks cannot remember their position without a state file\n")
+		return nil, nil
+	}
+	return stickerPositions{c}, nil
+}
+
+// stickerPositions keeps positions in MPD's stickers.
+type stickerPositions struct {
+	c *mpd.Client
+}
+
+func (s stickerPositions) position(uri string) (time.Duration, bool, error) {
+	value, ok, err := s.c.Sticker(uri, resumeSticker)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "invalid position of %s", uri)
+	}
+	return time.Duration(seconds * float64(time.Second)), true, nil
+}
+
+func (s stickerPositions) setPosition(uri string, pos time.Duration) error {
+	return s.c.SetSticker(uri, resumeSticker, fmt.Sprintf("%.3f", pos.Seconds()))
+}
+
+func (s stickerPositions) clearPosition(uri string) error {
+	return s.c.DeleteSticker(uri, resumeSticker)
+}
+
+// positionFile keeps positions in a JSON file, as seconds by song URI.
+// It is guarded by the status lock.
+type positionFile struct {
+	path      string
+	positions map[string]float64
+}
+
+// newPositionFile reads the positions kept at path. The file does not need to exist.
+func newPositionFile(path string) (*positionFile, error) {
+	f := &positionFile{path: path, positions: make(map[string]float64)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := json.Unmarshal(data, &f.positions); err != nil {
+		return nil, errors.Wrapf(err, "cannot read positions from %s", path)
+	}
+	return f, nil
+}
+
+func (f *positionFile) position(uri string) (time.Duration, bool, error) {
+	seconds, ok := f.positions[uri]
+	return time.Duration(seconds * float64(time.Second)), ok, nil
+}
+
+func (f *positionFile) setPosition(uri string, pos time.Duration) error {
+	f.positions[uri] = pos.Round(time.Millisecond).Seconds()
+	return f.save()
+}
+
+func (f *positionFile) clearPosition(uri string) error {
+	if _, ok := f.positions[uri]; !ok {
+		return nil
+	}
+	delete(f.positions, uri)
+	return f.save()
+}
+
+func (f *positionFile) save() error {
+	data, err := json.MarshalIndent(f.positions, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return writeFileAtomically(f.path, append(data, '\n'))
+}
+
+// resumeState follows the position of the current track, which remembers it.
+// It is guarded by the status lock.
+type resumeState struct {
+	songID   int
+	uri      string
+	duration time.Duration
+	pos      time.Duration
+	savedPos time.Duration // The position last remembered
+	savedAt  time.Time
+}
+
+// followSong returns the state following song, which just became current, or nil if it does not remember its position.
+func (p *Player) followSong(status mpd.Status, song mpd.Song) *resumeState {
+	if p.positions == nil || !p.resume.remembers(song) {
+		return nil
+	}
+	r := &resumeState{songID: song.ID, uri: song.Path(), duration: song.Duration, pos: status.Seek, savedAt: time.Now()}
+	pos, ok, err := p.positions.position(r.uri)
+	if err != nil {
+		log.Printf("Cannot read the position of %s: %+v\n", r.uri, err)
+	} else if ok {
+		r.savedPos = pos
+	}
+	return r
+}
+
+// resumeSong starts following song, which just became current.
+// If playback starts near zero, it seeks to the remembered position. It assumes that the status lock is held.
+func (p *Player) resumeSong(b *batch, status mpd.Status, song mpd.Song) {
+	r := p.followSong(status, song)
+	p.status.resuming = r
+	// Seeking while stopped would start playback.
+	if r == nil || status.State == "stop" || status.Seek >= resumeNearStart || !r.resumable(r.savedPos) {
+		return
+	}
+	p.resumeAt(b, r, r.savedPos)
+}
+
+// resumeAt seeks the current track to pos, and returns whether it did. It assumes that the status lock is held.
+func (p *Player) resumeAt(b *batch, r *resumeState, pos time.Duration) bool {
+	log.Printf("Resuming %s at %v\n", r.uri, pos)
+	if err := p.mpd.SeekID(r.songID, pos); err != nil {
+		log.Printf("Cannot resume %s: %+v\n", r.uri, err)
+		return false
+	}
+	r.pos = pos
+	p.status.Seek = pos
+	b.set("org.mpris.MediaPlayer2.Player", "Position", UsFromDuration(pos))
+	b.seek(UsFromDuration(pos))
+	return true
+}
+
+// resumable returns whether playback may resume from pos, which is neither near the start nor near the end.
+func (r *resumeState) resumable(pos time.Duration) bool {
+	return pos >= resumeNearStart && (r.duration <= 0 || pos < r.duration-resumeNearEnd)
+}
+
+// followResume follows the position of the current track, before the status is updated to MPD's,
+// and returns the position of playback, which changes if the track is resumed.
+// The position is remembered when the track changes, pauses or stops, and every so often while it plays.
+// Playing a stopped track again from the start resumes it. It assumes that the status lock is held.
+func (p *Player) followResume(b *batch, status mpd.Status) time.Duration {
+	s := &p.status
+	r := s.resuming
+	if r == nil {
+		return status.Seek
+	}
+	if status.Song != r.songID {
+		p.saveResume(r)
+		s.resuming = nil
+		return status.Seek
+	}
+	switch status.State {
+	case "play":
+		if s.PlaybackStatus == PlaybackStatusStopped && status.Seek < resumeNearStart && r.resumable(r.pos) && p.resumeAt(b, r, r.pos) {
+			return r.pos
+		}
+		p.keepResume(status.Seek)
+	case "pause":
+		r.pos = status.Seek
+		p.saveResume(r)
+	default:
+		// Stopping loses MPD's position, but not ours.
+		p.saveResume(r)
+	}
+	return status.Seek
+}
+
+// keepResume records pos as the position of the current track, and remembers it every so often.
+// It assumes that the status lock is held.
+func (p *Player) keepResume(pos time.Duration) {
+	r := p.status.resuming
+	if r == nil {
+		return
+	}
+	r.pos = pos
+	if time.Since(r.savedAt) >= resumeSaveInterval {
+		p.saveResume(r)
+	}
+}
+
+// saveResume remembers the position of the track followed by r, or forgets it if the track is finished.
+// It assumes that the status lock is held.
+func (p *Player) saveResume(r *resumeState) {
+	r.savedAt = time.Now()
+	if r.pos == r.savedPos || r.pos < resumeNearStart {
+		return
+	}
+	var err error
+	set := r.resumable(r.pos)
+	if set {
+		err = p.positions.setPosition(r.uri, r.pos)
+	} else {
+		err = p.positions.clearPosition(r.uri)
+	}
+	if err != nil {
+		log.Printf("Cannot remember the position of %s: %+v\n", r.uri, err)
+		return
+	}
+	if _, ok := p.positions.(stickerPositions); ok && (set || r.savedPos != 0) {
+		// MPD tells about the sticker written (or removed, if there was one) with a sticker event.
+		p.status.stickerWritten = true
+	}
+	r.savedPos = r.pos
+}
+
+// clearResume forgets the position of the track at uri, or of the current track if uri is empty.
+// The current track does not remember its position again until it plays again.
+func (p *Player) clearResume(uri string) error {
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if uri == "" {
+		if s.CurrentSong.ID == -1 {
+			return errors.New("there is no current track")
+		}
+		uri = s.CurrentSong.Path()
+	}
+	if s.resuming != nil && s.resuming.uri == uri {
+		s.resuming = nil
+	}
+	if p.positions == nil {
+		return nil
+	}
+	return p.positions.clearPosition(uri)
+}
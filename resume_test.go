@@ -0,0 +1,180 @@
+package mpris
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/natsukagami/mpd-mpris/mpd"
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func TestResumePolicy(t *testing.T) {
+	policy := ResumePolicy{MinLength: 20 * time.Minute, Paths: []string{"Audiobooks/"}, Genres: []string{"podcast"}}
+	song := func(path, genre string, duration time.Duration) mpd.Song {
+		return mpd.Song{File: mpd.File{Genre: genre, Duration: duration, Attrs: map[string]string{"file": path}}}
+	}
+	for _, test := range []struct {
+		song mpd.Song
+		want bool
+	}{
+		{song("Music/a.flac", "Rock", 3*time.Minute), false},
+		{song("Music/long.flac", "Classical", time.Hour), true},
+		{song("Audiobooks/book.m4b", "", time.Minute), true},
+		{song("AudiobooksOld/book.m4b", "", time.Minute), false},
+		{song("Podcasts/1.mp3", "Podcast", time.Minute), true},
+		{song("http://radio.example/stream", "Podcast", time.Hour), false},
+	} {
+		if got := policy.remembers(test.song); got != test.want {
+			t.Errorf("remembers(%s) = %v, want %v", test.song.Path(), got, test.want)
+		}
+	}
+}
+
+func TestPositionFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "positions.json")
+	f, err := newPositionFile(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := f.setPosition("book.m4b", 90*time.Second+1500*time.Microsecond); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := f.setPosition("other.m4b", time.Minute); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := f.clearPosition("other.m4b"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if f, err = newPositionFile(path); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if pos, ok, _ := f.position("book.m4b"); !ok || pos != 90*time.Second+2*time.Millisecond {
+		t.Errorf("position(book.m4b) = %v, %v", pos, ok)
+	}
+	if _, ok, _ := f.position("other.m4b"); ok {
+		t.Error("cleared positions should stay cleared")
+	}
+}
+
+func TestPlayerResume(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		for _, q := range st.Queue {
+			st.Library = append(st.Library, q.Song)
+		}
+		st.Stickers["b.flac"] = map[string]string{resumeSticker: "42.000"}
+		st.Play(0)
+	}, ResumePositions(ResumePolicy{MinLength: 150 * time.Second}))
+	playerEvent := func() {
+		for _, h := range p.handlers["player"] {
+			if err := h(); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+	sticker := func(uri string) (value string) {
+		s.Inspect(func(st *mpdtest.State) { value = st.Stickers[uri][resumeSticker] })
+		return
+	}
+	drain(p)
+
+	// Short tracks do not remember their position.
+	s.Modify(func(st *mpdtest.State) { st.Elapsed = 50 * time.Second })
+	playerEvent()
+	mustOK(t, p.Next())
+	if value := sticker("a.flac"); value != "" {
+		t.Errorf("a.flac remembered %s", value)
+	}
+
+	// Long tracks resume where they were left.
+	if pos, _, elapsed := current(s); pos != 1 || elapsed != 42*time.Second {
+		t.Errorf("playing %d at %v, want b.flac resumed at 42s", pos, elapsed)
+	}
+	if b := drain(p); b.seeked == nil || *b.seeked != UsFromDuration(42*time.Second) {
+		t.Errorf("Seeked = %v, want 42s", b.seeked)
+	}
+	s.Modify(func(st *mpdtest.State) { st.Elapsed = 80 * time.Second })
+	playerEvent()
+	mustOK(t, p.Next())
+	if value := sticker("b.flac"); value != "80.000" {
+		t.Errorf("b.flac remembered %q, want 80s", value)
+	}
+
+	// Pausing and stopping remember the position too.
+	s.Modify(func(st *mpdtest.State) { st.Elapsed = 100 * time.Second })
+	mustOK(t, p.Pause())
+	if value := sticker("c.flac"); value != "100.000" {
+		t.Errorf("c.flac remembered %q after pausing, want 100s", value)
+	}
+	mustOK(t, p.Play())
+	s.Modify(func(st *mpdtest.State) { st.Elapsed = 120 * time.Second })
+	playerEvent()
+	mustOK(t, p.Stop())
+	if value := sticker("c.flac"); value != "120.000" {
+		t.Errorf("c.flac remembered %q after stopping, want 120s", value)
+	}
+	mustOK(t, p.Play())
+	if _, _, elapsed := current(s); elapsed != 120*time.Second {
+		t.Errorf("playing again at %v, want 120s", elapsed)
+	}
+
+	// Finished tracks start over.
+	s.Modify(func(st *mpdtest.State) { st.Elapsed = 290 * time.Second })
+	mustOK(t, p.Pause())
+	if value := sticker("c.flac"); value != "" {
+		t.Errorf("c.flac remembered %q near its end", value)
+	}
+
+	s.Modify(func(st *mpdtest.State) { st.Stickers["b.flac"][resumeSticker] = "60.000" })
+	mustOK(t, p.extension.ClearResumePosition("b.flac"))
+	if value := sticker("b.flac"); value != "" {
+		t.Errorf("b.flac remembered %q after clearing it", value)
+	}
+
+	// The sticker events of our own positions do not read the bookmarks again, unlike those of other clients.
+	stickerCommands := func() (n int) {
+		for _, h := range p.handlers["sticker"] {
+			if err := h(); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+		for _, cmd := range s.Commands() {
+			if strings.HasPrefix(cmd, "sticker") {
+				n++
+			}
+		}
+		s.ResetCommands()
+		return n
+	}
+	mustOK(t, p.Play())
+	s.Modify(func(st *mpdtest.State) { st.Elapsed = 200 * time.Second })
+	mustOK(t, p.Pause())
+	s.ResetCommands()
+	if n := stickerCommands(); n != 0 {
+		t.Errorf("%d sticker commands on the event of our own position, want none", n)
+	}
+	if n := stickerCommands(); n != 1 {
+		t.Errorf("%d sticker commands on another event, want 1", n)
+	}
+}
+
+func TestPlayerNoStickers(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Play(0)
+		st.NoStickers = true
+	}, ResumePositions(ResumePolicy{MinLength: 150 * time.Second}))
+	if p.positions != nil {
+		t.Errorf("positions kept in %T without a sticker database", p.positions)
+	}
+	// Neither positions nor bookmarks are looked up as tracks change.
+	s.ResetCommands()
+	mustOK(t, p.Next())
+	for _, cmd := range s.Commands() {
+		if strings.HasPrefix(cmd, "sticker") {
+			t.Errorf("unexpected %q", cmd)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package mpris
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd"
+	"github.com/pkg/errors"
+)
+
+// This file implements the shuffle modes: MPD's random mode, or a shuffle of the queue itself,
+// which shows what comes next to every client, and which is put back in order afterwards.
+
+// ShuffleMode is what Shuffle does.
+type ShuffleMode string
+
+const (
+	// ShuffleRandom turns MPD's random mode on: the queue stays in order, and plays in a random one.
+	ShuffleRandom ShuffleMode = "random"
+	// ShuffleQueue shuffles the queue around the current song, and puts it back in order when Shuffle is turned off.
+	ShuffleQueue ShuffleMode = "queue"
+)
+
+// ParseShuffleMode returns the ShuffleMode with the given name: "random" or "queue".
+func ParseShuffleMode(name string) (ShuffleMode, error) {
+	for _, m := range []ShuffleMode{ShuffleRandom, ShuffleQueue} {
+		if strings.EqualFold(string(m), name) {
+			return m, nil
+		}
+	}
+	return "", errors.Errorf("unknown shuffle mode %q", name)
+}
+
+// queueSongIDs returns the IDs of the songs of the queue, in order.
+func (p *Player) queueSongIDs() ([]int, error) {
+	queue, err := p.mpd.PlaylistInfo(-1, -1)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, len(queue))
+	for i, f := range queue {
+		if ids[i], err = strconv.Atoi(f.Attrs["Id"]); err != nil {
+			return nil, errors.Wrap(err, "parsing song ID")
+		}
+	}
+	return ids, nil
+}
+
+// shuffleQueue records the order of the queue, then moves the current song to its start and shuffles the rest,
+// so that every other song comes next. It assumes that the status lock is held.
+func (p *Player) shuffleQueue(b *batch) *dbus.Error {
+	s := &p.status
+	ids, err := p.queueSongIDs()
+	if err != nil {
+		return p.transformErr(err)
+	}
+	// Shuffling again keeps the order from before the first shuffle.
+	previous := s.queueOrder
+	if previous == nil {
+		s.queueOrder = ids
+	}
+	current := s.CurrentSong.ID
+	derr := s.updateWith(p, b, func(cl *mpd.CommandList) {
+		cl.Random(false) // The queue plays in its new order.
+		start := 0
+		if current != -1 {
+			cl.MoveID(current, 0)
+			start = 1
+		}
+		if len(ids) > start+1 {
+			cl.Shuffle(start, -1)
+		}
+	})
+	if derr != nil && previous == nil {
+		s.queueOrder = nil
+	}
+	return derr
+}
+
+// unshuffleQueue puts the songs of the queue back in the order recorded by shuffleQueue.
+// Songs added since then follow, in their current order. It assumes that the status lock is held.
+func (p *Player) unshuffleQueue(b *batch) *dbus.Error {
+	s := &p.status
+	if s.queueOrder == nil {
+		return s.updateWith(p, b, func(cl *mpd.CommandList) { cl.Random(false) })
+	}
+	ids, err := p.queueSongIDs()
+	if err != nil {
+		return p.transformErr(err)
+	}
+	queued := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		queued[id] = true
+	}
+	order := make([]int, 0, len(ids))
+	for _, id := range s.queueOrder {
+		if queued[id] {
+			order = append(order, id)
+			delete(queued, id)
+		}
+	}
+	for _, id := range ids {
+		if queued[id] {
+			order = append(order, id)
+		}
+	}
+	// Each move puts one more song in place.
+	type move struct{ id, pos int }
+	var moves []move
+	for pos, id := range order {
+		if ids[pos] != id {
+			moves = append(moves, move{id, pos})
+			ids = moveID(ids, id, pos)
+		}
+	}
+	recorded := s.queueOrder
+	s.queueOrder = nil
+	if err := s.updateWith(p, b, func(cl *mpd.CommandList) {
+		cl.Random(false)
+		for _, m := range moves {
+			cl.MoveID(m.id, m.pos)
+		}
+	}); err != nil {
+		s.queueOrder = recorded
+		return err
+	}
+	log.Printf("Queue put back in order\n")
+	return nil
+}
+
+// moveID returns ids, with id moved to position pos, as MPD's moveid does.
+func moveID(ids []int, id, pos int) []int {
+	for i, other := range ids {
+		if other == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	ids = append(ids[:pos], append([]int{id}, ids[pos:]...)...)
+	return ids
+}
@@ -0,0 +1,67 @@
+package mpris
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func TestParseShuffleMode(t *testing.T) {
+	if m, err := ParseShuffleMode("Queue"); err != nil || m != ShuffleQueue {
+		t.Errorf("ParseShuffleMode(Queue) = %v, %v", m, err)
+	}
+	if _, err := ParseShuffleMode("albums"); err == nil {
+		t.Error("ParseShuffleMode(albums) should fail")
+	}
+}
+
+func TestPlayerShuffleQueue(t *testing.T) {
+	files := []string{"a.flac", "b.flac", "c.flac", "d.flac", "e.flac", "f.flac"}
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		for _, f := range files {
+			st.Enqueue(mpdtest.Song{"file": f, "duration": "100"})
+		}
+		st.Library = append(st.Library, mpdtest.Song{"file": "new.flac"})
+		st.Play(2)
+	}, UseShuffleMode(ShuffleQueue))
+	queue := func() (files []string, current string, random bool) {
+		s.Inspect(func(st *mpdtest.State) {
+			for _, q := range st.Queue {
+				files = append(files, q.Song["file"])
+			}
+			current, random = st.Queue[st.Current].Song["file"], st.Random
+		})
+		return
+	}
+	drain(p)
+
+	mustOK(t, p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "Shuffle", dbus.MakeVariant(true)))
+	shuffled, current, random := queue()
+	if current != "c.flac" || shuffled[0] != "c.flac" || len(shuffled) != len(files) || random {
+		t.Errorf("queue %v playing %s (random %v), want c.flac first, and random off", shuffled, current, random)
+	}
+	if v := getProp(t, p, "Shuffle"); v != true {
+		t.Errorf("Shuffle = %v", v)
+	}
+
+	// Songs added in between stay, after the others.
+	s.Modify(func(st *mpdtest.State) { st.Enqueue(mpdtest.Song{"file": "new.flac"}) })
+	mustOK(t, p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "Shuffle", dbus.MakeVariant(false)))
+	restored, current, _ := queue()
+	if want := append(files, "new.flac"); !reflect.DeepEqual(restored, want) || current != "c.flac" {
+		t.Errorf("queue %v playing %s, want %v playing c.flac", restored, current, want)
+	}
+	if v := getProp(t, p, "Shuffle"); v != false {
+		t.Errorf("Shuffle = %v", v)
+	}
+
+	// Clearing the queue forgets the order.
+	mustOK(t, p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "Shuffle", dbus.MakeVariant(true)))
+	s.Modify(func(st *mpdtest.State) { st.SetQueue() })
+	mustOK(t, p.status.UpdateQueue(p))
+	if v := getProp(t, p, "Shuffle"); v != false {
+		t.Errorf("Shuffle = %v with an empty queue", v)
+	}
+}
@@ -0,0 +1,279 @@
+package mpris
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd"
+)
+
+// This file implements the sleep timer, which stops playback after some time, or after some tracks.
+// The volume may fade out over the last part of the timer, and is restored once playback has stopped.
+// On the last track, MPD's single mode stops playback at its very end; the options are restored afterwards.
+
+// The interval between updates of the SleepTimerRemaining property.
+const sleepTimerInterval = time.Second
+
+// A sleepTimer stops playback at a deadline, or at the end of a track.
+// Its fields are guarded by the status lock.
+type sleepTimer struct {
+	// For timers stopping after some time: when playback stops. Zero for timers stopping after some tracks.
+	deadline time.Time
+	// For timers stopping after some tracks: the number of tracks to play after the current one,
+	// the ID of the current one, and how long the tracks after it last, unless random mode picks them.
+	tracks int
+	songID int
+	queued time.Duration
+	random bool
+	// Whether single mode stops playback at the end of the last track, and the options it replaced.
+	single     bool
+	singleMode string
+	repeat     bool
+
+	fade    time.Duration // The volume fades out over this last part of the timer
+	fading  bool
+	expired bool // The last track has ended: playback stops at once
+
+	reported time.Duration // The last value of SleepTimerRemaining
+	wake     chan struct{} // Wakes the timer up before its next tick
+	stop     chan struct{} // Closed when the timer is cancelled
+}
+
+// remaining returns the time left before playback stops. Tracks of unknown length count as zero.
+func (t *sleepTimer) remaining(s *Status) time.Duration {
+	if !t.deadline.IsZero() {
+		return time.Until(t.deadline)
+	}
+	left := s.CurrentSong.Duration - s.Seek
+	if left < 0 {
+		left = 0
+	}
+	return left + t.queued
+}
+
+// report updates SleepTimerRemaining to left, in whole seconds, if it has changed.
+func (t *sleepTimer) report(b *batch, left time.Duration) {
+	if left < 0 {
+		left = 0
+	}
+	if reported := left.Round(time.Second); reported != t.reported {
+		t.reported = reported
+		b.set("org.mpd.MediaPlayer2.Player", "SleepTimerRemaining", UsFromDuration(reported))
+	}
+}
+
+// setSleepTimer replaces the sleep timer with t, and starts it.
+func (p *Player) setSleepTimer(t *sleepTimer) *dbus.Error {
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	// Timers stopping after some tracks count from the position of now.
+	if err := s.updateWith(p, b, nil); err != nil {
+		return err
+	}
+	p.cancelSleepTimer(b)
+	if t.deadline.IsZero() {
+		t.songID = s.CurrentSong.ID
+		t.queued, t.random = p.queuedDuration(s.CurrentSong, t.tracks)
+		if t.tracks == 0 {
+			p.stopAfterTrack(t)
+		}
+	}
+	t.reported = -1
+	t.report(b, t.remaining(s))
+	t.wake = make(chan struct{}, 1)
+	t.stop = make(chan struct{})
+	s.sleep = t
+	go p.runSleepTimer(t)
+	return nil
+}
+
+// cancelSleepTimer cancels the sleep timer, if any, and its fade-out, restoring the volume.
+// It assumes that the status lock is held.
+func (p *Player) cancelSleepTimer(b *batch) {
+	s := &p.status
+	t := s.sleep
+	if t == nil {
+		return
+	}
+	p.endSleepTimer(b)
+	if t.fading && s.fadingOut() {
+		restore := s.mpdVolume
+		s.stopRamp()
+		if err := p.mpd.SetVolume(restore); err != nil {
+			log.Printf("Cannot restore the volume: %v\n", err)
+		}
+	}
+}
+
+// endSleepTimer removes the sleep timer, leaving any fade-out going, and restores the options it changed.
+// It assumes that the status lock is held.
+func (p *Player) endSleepTimer(b *batch) {
+	s := &p.status
+	t := s.sleep
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	s.sleep = nil
+	b.set("org.mpd.MediaPlayer2.Player", "SleepTimerRemaining", TimeInUs(-1))
+	if t.single {
+		cl := p.mpd.BeginCommandList()
+		cl.SingleMode(t.singleMode)
+		cl.Repeat(t.repeat)
+		if err := cl.End(); err != nil {
+			log.Printf("Cannot restore single mode and repeat: %v\n", err)
+		}
+	}
+}
+
+// stopAfterTrack has MPD stop playback at the end of the current track, the last one of t, with single mode.
+// Repeat would make single mode repeat the track instead, so it is off until the timer ends.
+// If MPD is too old for "oneshot" single mode, t stops playback itself when the track is over.
+// It assumes that the status lock is held.
+func (p *Player) stopAfterTrack(t *sleepTimer) {
+	status, err := p.mpd.Status()
+	if err != nil {
+		log.Printf("Cannot look up single mode: %v\n", err)
+		return
+	}
+	cl := p.mpd.BeginCommandList()
+	cl.SingleMode(mpd.SingleOneshot)
+	if status.Repeat {
+		cl.Repeat(false)
+	}
+	if err := cl.End(); err != nil {
+		log.Printf("Cannot set single mode: %v\n", err)
+		return
+	}
+	t.single, t.singleMode, t.repeat = true, status.SingleMode, status.Repeat
+}
+
+// runSleepTimer runs the timer t until it is over.
+func (p *Player) runSleepTimer(t *sleepTimer) {
+	for {
+		wait, over := p.tickSleepTimer(t)
+		if over {
+			return
+		}
+		select {
+		case <-t.stop:
+			return
+		case <-t.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// tickSleepTimer updates the time left of t, and stops playback or starts fading out when it is time.
+// It returns how long to wait until the next tick, and whether the timer is over.
+func (p *Player) tickSleepTimer(t *sleepTimer) (time.Duration, bool) {
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	if s.sleep != t {
+		return 0, true
+	}
+	stop := func(cl *mpd.CommandList) { cl.Stop() }
+	left := t.remaining(s)
+	switch {
+	case t.expired:
+		// The next track has started already: there is no time to fade.
+		log.Printf("Sleep timer expired, stopping playback\n")
+		p.endSleepTimer(b)
+		if err := s.updateWith(p, b, stop); err != nil {
+			log.Printf("Cannot stop playback: %v\n", err)
+		}
+		return 0, true
+	case t.random:
+		// Only the current track counts: there is more to play once it ends.
+	case left <= 0 && !s.fadingOut() && !t.single:
+		log.Printf("Sleep timer expired, stopping playback\n")
+		p.endSleepTimer(b)
+		if err := p.fadeOut(b, stop); err != nil {
+			log.Printf("Cannot stop playback: %v\n", err)
+		}
+		return 0, true
+	case left <= t.fade && !t.fading && s.HasVolume && s.PlaybackStatus == PlaybackStatusPlaying:
+		// The fade ends with playback, which then ends the timer.
+		// With single mode, MPD may stop first, but the volume is down already.
+		t.fading = true
+		from := s.mpdVolume
+		if s.ramp != nil {
+			from = s.ramp.current
+		}
+		p.rampVolume(from, 0, left, p.afterFadeOut(stop))
+	}
+
+	t.report(b, left)
+	wait := sleepTimerInterval
+	if untilFade := left - t.fade; !t.fading && untilFade > 0 && untilFade < wait {
+		wait = untilFade
+	}
+	if left > 0 && left < wait {
+		wait = left
+	}
+	return wait, false
+}
+
+// sleepSongChanged counts down the tracks of a sleep timer stopping after some tracks, as the current song changes.
+// It assumes that the status lock is held.
+func (p *Player) sleepSongChanged(song mpd.Song) {
+	t := p.status.sleep
+	if t == nil || !t.deadline.IsZero() || song.ID == t.songID {
+		return
+	}
+	if t.tracks == 0 {
+		t.expired = true
+		select {
+		case t.wake <- struct{}{}:
+		default:
+		}
+		return
+	}
+	t.tracks--
+	t.songID = song.ID
+	t.queued, t.random = p.queuedDuration(song, t.tracks)
+	if t.tracks == 0 {
+		p.stopAfterTrack(t)
+	}
+}
+
+// queuedDuration returns how long the n tracks after song last, in queue order.
+// In random mode, the next tracks are not known until they play: they count as zero, and random is true.
+func (p *Player) queuedDuration(song mpd.Song, n int) (d time.Duration, random bool) {
+	pos, err := strconv.Atoi(song.Attrs["Pos"])
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	status, err := p.mpd.Status()
+	if err != nil {
+		log.Printf("Cannot look up the queue: %v\n", err)
+		return 0, false
+	}
+	if status.Random {
+		return 0, true
+	}
+	end := pos + 1 + n
+	if length := int(status.PlaylistLength / time.Second); end > length {
+		end = length
+	}
+	if pos+1 >= end {
+		return 0, false
+	}
+	files, err := p.mpd.PlaylistInfo(pos+1, end)
+	if err != nil {
+		log.Printf("Cannot look up the queue: %v\n", err)
+		return 0, false
+	}
+	for _, f := range files {
+		d += f.Duration
+	}
+	return d, false
+}
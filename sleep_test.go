@@ -0,0 +1,145 @@
+package mpris
+
+import (
+	"testing"
+	"time"
+
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func sleepTimerRemaining(t *testing.T, p *Player) time.Duration {
+	t.Helper()
+	v, err := p.Instance.props.Get("org.mpd.MediaPlayer2.Player", "SleepTimerRemaining")
+	if err != nil {
+		t.Fatalf("Get(SleepTimerRemaining): %v", err)
+	}
+	return v.Value().(TimeInUs).Duration()
+}
+
+func TestPlayerSleepAfter(t *testing.T) {
+	p, s := newFadingPlayer(t)
+	p.fade = 0
+
+	mustOK(t, p.extension.SleepAfter(UsFromDuration(2*time.Second), 0))
+	if left := sleepTimerRemaining(t, p); left != 2*time.Second {
+		t.Errorf("SleepTimerRemaining = %v, want 2s", left)
+	}
+	// A new timer replaces the old one.
+	mustOK(t, p.extension.SleepAfter(UsFromDuration(300*time.Millisecond), 0))
+	waitForPlayback(t, s, "stop", 60)
+	time.Sleep(50 * time.Millisecond)
+	if left := sleepTimerRemaining(t, p); left != -time.Microsecond {
+		t.Errorf("SleepTimerRemaining = %v after the timer, want -1µs", left)
+	}
+
+	if err := p.extension.SleepAfter(0, 0); err == nil {
+		t.Error("SleepAfter(0) should fail")
+	}
+}
+
+func TestPlayerSleepFade(t *testing.T) {
+	p, s := newFadingPlayer(t)
+
+	mustOK(t, p.extension.SleepAfter(UsFromDuration(500*time.Millisecond), UsFromDuration(400*time.Millisecond)))
+	time.Sleep(300 * time.Millisecond)
+	s.Inspect(func(st *mpdtest.State) {
+		if st.Volume >= 60 {
+			t.Errorf("volume = %d, should be fading out", st.Volume)
+		}
+	})
+	waitForPlayback(t, s, "stop", 60)
+	if v, ok := drain(p).changed("Volume"); ok {
+		t.Errorf("Volume changed to %v during the fade", v)
+	}
+
+	// Cancelling the timer restores the volume at once.
+	mustOK(t, p.Play())
+	waitForVolume(t, s, 60)
+	mustOK(t, p.extension.SleepAfter(UsFromDuration(time.Second), UsFromDuration(time.Second)))
+	time.Sleep(300 * time.Millisecond)
+	mustOK(t, p.extension.CancelSleepTimer())
+	waitForVolume(t, s, 60)
+	time.Sleep(time.Second)
+	waitForPlayback(t, s, "play", 60)
+	if left := sleepTimerRemaining(t, p); left >= 0 {
+		t.Errorf("SleepTimerRemaining = %v after cancelling", left)
+	}
+}
+
+func TestPlayerSleepAfterTracks(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Play(0)
+		st.Elapsed = 40 * time.Second
+	})
+
+	mustOK(t, p.extension.SleepAfterTracks(1, 0))
+	if left := sleepTimerRemaining(t, p); left != 260*time.Second {
+		t.Errorf("SleepTimerRemaining = %v, want the rest of A and B", left)
+	}
+	mustOK(t, p.Next())
+	if pos, playback, _ := current(s); pos != 1 || playback != "play" {
+		t.Errorf("pos %d, %s after the first track, want pos 1 playing", pos, playback)
+	}
+	mustOK(t, p.Next())
+	waitForPlayback(t, s, "stop", 100)
+
+	// On the last track, single mode stops playback at its end, rather than the timer.
+	// Repeat would replay the track, so it is off until then.
+	s.Modify(func(st *mpdtest.State) {
+		st.Play(0)
+		st.Repeat = true
+	})
+	mustOK(t, p.extension.SleepAfterTracks(1, 0))
+	s.Modify(func(st *mpdtest.State) { st.Finish() })
+	mustOK(t, p.status.UpdatePlayer(p))
+	s.Inspect(func(st *mpdtest.State) {
+		if !st.Single || !st.Oneshot || st.Repeat {
+			t.Errorf("single %v, oneshot %v, repeat %v on the last track, want single oneshot without repeat", st.Single, st.Oneshot, st.Repeat)
+		}
+	})
+	s.Modify(func(st *mpdtest.State) { st.Finish() })
+	mustOK(t, p.status.UpdatePlayer(p))
+	if pos, playback, _ := current(s); pos != 1 || playback != "stop" {
+		t.Errorf("pos %d, %s after the last track, want pos 1 stopped", pos, playback)
+	}
+	s.Inspect(func(st *mpdtest.State) {
+		if st.Single || !st.Repeat {
+			t.Errorf("single %v, repeat %v after stopping, want repeat back on", st.Single, st.Repeat)
+		}
+	})
+	if left := sleepTimerRemaining(t, p); left >= 0 {
+		t.Errorf("SleepTimerRemaining = %v after the last track", left)
+	}
+
+	// Stopping playback ends the timer.
+	mustOK(t, p.Play())
+	mustOK(t, p.extension.SleepAfterTracks(0, 0))
+	mustOK(t, p.Stop())
+	if left := sleepTimerRemaining(t, p); left >= 0 {
+		t.Errorf("SleepTimerRemaining = %v after stopping", left)
+	}
+
+	// In random mode, the next tracks count once they play, and the timer goes on after the current one.
+	s.Modify(func(st *mpdtest.State) {
+		st.Play(0)
+		st.Elapsed = 40 * time.Second
+		st.Random = true
+	})
+	mustOK(t, p.extension.SleepAfterTracks(1, 0))
+	if left := sleepTimerRemaining(t, p); left != 60*time.Second {
+		t.Errorf("SleepTimerRemaining = %v in random mode, want the rest of A", left)
+	}
+	s.Modify(func(st *mpdtest.State) { st.Elapsed = 100 * time.Second })
+	p.tickSleepTimer(p.status.sleep)
+	if _, playback, _ := current(s); playback != "play" {
+		t.Errorf("%s at the end of the first track in random mode, want play", playback)
+	}
+	s.Modify(func(st *mpdtest.State) { st.Play(2) })
+	mustOK(t, p.status.UpdatePlayer(p))
+	p.tickSleepTimer(p.status.sleep)
+	if left := sleepTimerRemaining(t, p); left != 300*time.Second {
+		t.Errorf("SleepTimerRemaining = %v on the last track, want all of C", left)
+	}
+	mustOK(t, p.extension.CancelSleepTimer())
+}
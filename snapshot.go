@@ -0,0 +1,251 @@
+package mpris
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd"
+	"github.com/pkg/errors"
+)
+
+// This file implements queue snapshots, which save the whole queue with the playback position and options,
+// to come back to them later. Unlike stored playlists, they keep where playback was.
+
+// A Snapshot is the state of the queue at some point.
+type Snapshot struct {
+	Name  string    `json:"name"`
+	Taken time.Time `json:"taken"`
+	// The URIs of the songs of the queue.
+	Queue []string `json:"queue"`
+	// The position of the current song in Queue, or -1, and how far it had played.
+	Current int           `json:"current"`
+	Elapsed time.Duration `json:"elapsed"`
+	// MPD's playback state: "play", "pause" or "stop".
+	State  string `json:"state"`
+	Random bool   `json:"random"`
+	Repeat bool   `json:"repeat"`
+	// MPD's single mode: "0", "1" or "oneshot".
+	Single  string `json:"single"`
+	Consume bool   `json:"consume"`
+}
+
+// UnmarshalJSON reads a snapshot, including those saved while single mode was a boolean, as true or false.
+func (s *Snapshot) UnmarshalJSON(data []byte) error {
+	type snapshot Snapshot
+	v := struct {
+		*snapshot
+		Single interface{} `json:"single"`
+	}{snapshot: (*snapshot)(s)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch single := v.Single.(type) {
+	case nil:
+		s.Single = mpd.SingleOff
+	case bool:
+		s.Single = mpd.SingleOff
+		if single {
+			s.Single = mpd.SingleOn
+		}
+	case string:
+		s.Single = single
+	default:
+		return errors.Errorf("invalid single mode %v of snapshot %q", single, s.Name)
+	}
+	return nil
+}
+
+// dbusInfo returns what ListSnapshots tells about the snapshot.
+func (s Snapshot) dbusInfo() map[string]dbus.Variant {
+	return map[string]dbus.Variant{
+		"taken":   dbus.MakeVariant(s.Taken.Unix()),
+		"length":  dbus.MakeVariant(uint32(len(s.Queue))),
+		"current": dbus.MakeVariant(int32(s.Current)),
+		"elapsed": dbus.MakeVariant(int64(UsFromDuration(s.Elapsed))),
+		"state":   dbus.MakeVariant(s.State),
+	}
+}
+
+// snapshotStore keeps snapshots, in a file if any.
+type snapshotStore struct {
+	mu        sync.Mutex
+	path      string
+	snapshots []Snapshot
+}
+
+// newSnapshotStore creates a store, with the snapshots of the file at path, if any.
+// The file does not need to exist: it is created once snapshots are saved.
+func newSnapshotStore(path string) (*snapshotStore, error) {
+	st := &snapshotStore{path: path}
+	if path == "" {
+		return st, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := json.Unmarshal(data, &st.snapshots); err != nil {
+		return nil, errors.Wrapf(err, "reading snapshots from %s", path)
+	}
+	return st, nil
+}
+
+// find returns the index of the snapshot with the given name, or -1. It assumes that the lock is held.
+func (st *snapshotStore) find(name string) int {
+	for i, s := range st.snapshots {
+		if s.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// save writes the snapshots to the file, if any. It assumes that the lock is held.
+func (st *snapshotStore) save() error {
+	if st.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(st.snapshots, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return writeFileAtomically(st.path, append(data, '\n'))
+}
+
+// add adds the snapshot, replacing the one with the same name, if any.
+func (st *snapshotStore) add(s Snapshot) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if i := st.find(s.Name); i >= 0 {
+		st.snapshots[i] = s
+	} else {
+		st.snapshots = append(st.snapshots, s)
+	}
+	return st.save()
+}
+
+// get returns the snapshot with the given name.
+func (st *snapshotStore) get(name string) (Snapshot, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	i := st.find(name)
+	if i < 0 {
+		return Snapshot{}, errors.Errorf("there is no snapshot named %q", name)
+	}
+	return st.snapshots[i], nil
+}
+
+// remove removes the snapshot with the given name.
+func (st *snapshotStore) remove(name string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	i := st.find(name)
+	if i < 0 {
+		return errors.Errorf("there is no snapshot named %q", name)
+	}
+	st.snapshots = append(st.snapshots[:i], st.snapshots[i+1:]...)
+	return st.save()
+}
+
+// list returns the snapshots, in the order they were first saved.
+func (st *snapshotStore) list() []Snapshot {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return append([]Snapshot(nil), st.snapshots...)
+}
+
+// takeSnapshot returns the state of the queue, under the given name.
+func (p *Player) takeSnapshot(name string) (Snapshot, *dbus.Error) {
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, _, err := s.fetch(p, nil, false)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	queue, qerr := p.mpd.PlaylistInfo(-1, -1)
+	if qerr != nil {
+		return Snapshot{}, p.transformErr(qerr)
+	}
+	snap := Snapshot{
+		Name:    name,
+		Taken:   time.Now().Round(0),
+		Queue:   make([]string, len(queue)),
+		Current: -1,
+		State:   status.State,
+		Random:  status.Random,
+		Repeat:  status.Repeat,
+		Single:  status.SingleMode,
+		Consume: status.Consume,
+	}
+	for i, f := range queue {
+		snap.Queue[i] = f.Path()
+	}
+	if pos, err := strconv.Atoi(status.Attrs["song"]); err == nil && status.Song != -1 {
+		snap.Current = pos
+		snap.Elapsed = status.Seek
+	}
+	return snap, nil
+}
+
+// restoreSnapshot replaces the queue, the playback position and options with those of the snapshot.
+// Songs that MPD cannot find anymore are left out.
+func (p *Player) restoreSnapshot(snap Snapshot) *dbus.Error {
+	// Checked first, as a failing command would leave the queue cleared.
+	switch snap.Single {
+	case mpd.SingleOff, mpd.SingleOn, mpd.SingleOneshot:
+	default:
+		return dbus.MakeFailedError(errors.Errorf("snapshot %q has an invalid single mode %q", snap.Name, snap.Single))
+	}
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	if _, _, err := s.fetch(p, func(cl *mpd.CommandList) {
+		cl.Stop()
+		cl.Clear()
+		cl.Random(snap.Random)
+		cl.Repeat(snap.Repeat)
+		cl.SingleMode(snap.Single)
+		cl.Consume(snap.Consume)
+	}, false); err != nil {
+		return err
+	}
+	// Added one by one, as a missing song would interrupt a command list.
+	current := -1
+	for i, uri := range snap.Queue {
+		id, err := p.mpd.AddID(uri, -1)
+		if err != nil {
+			log.Printf("Cannot restore %s into the queue: %v\n", uri, err)
+			continue
+		}
+		if i == snap.Current {
+			current = id
+		}
+	}
+	if current == -1 {
+		return s.updateWith(p, b, nil)
+	}
+	// MPD cannot make a song current without playing it: a stopped one is played, then stopped.
+	return s.updateWith(p, b, func(cl *mpd.CommandList) {
+		cl.PlayID(current)
+		// Stopping forgets the position anyway.
+		if snap.Elapsed > 0 && snap.State != "stop" {
+			cl.SeekID(current, snap.Elapsed)
+		}
+		switch snap.State {
+		case "pause":
+			cl.Pause(true)
+		case "stop":
+			cl.Stop()
+		}
+	})
+}
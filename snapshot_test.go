@@ -0,0 +1,151 @@
+package mpris
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/natsukagami/mpd-mpris/mpd"
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshots.json")
+	st, err := newSnapshotStore(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	snap := Snapshot{Name: "guest", Taken: time.Date(2026, 10, 18, 20, 0, 0, 0, time.UTC), Queue: []string{"a.flac", "b.flac"}, Current: 1, Elapsed: 42 * time.Second, State: "pause", Random: true}
+	for _, s := range []Snapshot{{Name: "guest"}, snap, {Name: "other"}} {
+		if err := st.add(s); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if err := st.remove("other"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if st, err = newSnapshotStore(path); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got := st.list(); !reflect.DeepEqual(got, []Snapshot{snap}) {
+		t.Errorf("snapshots read back = %+v, want %+v", got, snap)
+	}
+	if _, err := st.get("other"); err == nil {
+		t.Error("removed snapshots should be gone")
+	}
+
+	// Files where single mode was a boolean still load.
+	old := `[{"name":"on","single":true},{"name":"off","single":false}]`
+	if err := os.WriteFile(path, []byte(old), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if st, err = newSnapshotStore(path); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for name, want := range map[string]string{"on": mpd.SingleOn, "off": mpd.SingleOff} {
+		if snap, err := st.get(name); err != nil || snap.Single != want {
+			t.Errorf("single mode of %s = %q (%v), want %q", name, snap.Single, err, want)
+		}
+	}
+}
+
+func TestPlayerSnapshot(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		for _, q := range st.Queue {
+			st.Library = append(st.Library, q.Song)
+		}
+		st.Library = append(st.Library, mpdtest.Song{"file": "guest.flac"})
+		st.Play(1)
+		st.Elapsed = 42 * time.Second
+		st.Random = true
+	})
+
+	mustOK(t, p.extension.SaveSnapshot("before"))
+	snapshots, err := p.extension.ListSnapshots()
+	mustOK(t, err)
+	if length := snapshots["before"]["length"].Value(); length != uint32(3) {
+		t.Errorf("ListSnapshots()[before][length] = %v", length)
+	}
+
+	s.Modify(func(st *mpdtest.State) {
+		st.SetQueue(mpdtest.Song{"file": "guest.flac"})
+		st.Play(0)
+		st.Random, st.Consume = false, true
+		// Songs gone from the library are left out.
+		st.Library = st.Library[1:]
+	})
+	mustOK(t, p.extension.RestoreSnapshot("before"))
+	s.Inspect(func(st *mpdtest.State) {
+		var queue []string
+		for _, q := range st.Queue {
+			queue = append(queue, q.Song["file"])
+		}
+		if !reflect.DeepEqual(queue, []string{"b.flac", "c.flac"}) {
+			t.Errorf("queue = %v after restoring", queue)
+		}
+		if st.Current != 0 || st.Playback != "play" || st.Elapsed != 42*time.Second {
+			t.Errorf("playing %d (%s) at %v, want b.flac at 42s", st.Current, st.Playback, st.Elapsed)
+		}
+		if !st.Random || st.Consume {
+			t.Errorf("random %v, consume %v after restoring", st.Random, st.Consume)
+		}
+	})
+	if pos, _ := getProp(t, p, "Position").(TimeInUs); pos != UsFromDuration(42*time.Second) {
+		t.Errorf("Position = %v", pos)
+	}
+
+	// The current song is kept while stopped, and so is single mode, even "oneshot".
+	s.Modify(func(st *mpdtest.State) {
+		st.Play(1)
+		st.Playback = "stop"
+		st.Single, st.Oneshot = true, true
+	})
+	mustOK(t, p.extension.SaveSnapshot("stopped"))
+	s.Modify(func(st *mpdtest.State) {
+		st.Play(0)
+		st.Single, st.Oneshot = false, false
+	})
+	mustOK(t, p.extension.RestoreSnapshot("stopped"))
+	s.Inspect(func(st *mpdtest.State) {
+		if st.Current != 1 || st.Playback != "stop" {
+			t.Errorf("current %d (%s) after restoring, want c.flac stopped", st.Current, st.Playback)
+		}
+		if !st.Single || !st.Oneshot {
+			t.Errorf("single %v, oneshot %v after restoring, want single oneshot", st.Single, st.Oneshot)
+		}
+	})
+
+	// Stopped songs are not sought, as stopping forgets the position anyway.
+	if err := p.snapshots.add(Snapshot{Name: "stopped", Queue: []string{"b.flac"}, Current: 0, Elapsed: 42 * time.Second, State: "stop", Single: mpd.SingleOff}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	s.ResetCommands()
+	mustOK(t, p.extension.RestoreSnapshot("stopped"))
+	for _, cmd := range s.Commands() {
+		if strings.HasPrefix(cmd, "seek") {
+			t.Errorf("unexpected %q restoring a stopped snapshot", cmd)
+		}
+	}
+
+	// A snapshot with an invalid single mode leaves the queue alone.
+	if err := p.snapshots.add(Snapshot{Name: "invalid", Queue: []string{"a.flac"}, Current: -1, State: "stop", Single: "2"}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := p.extension.RestoreSnapshot("invalid"); err == nil {
+		t.Error("restoring a snapshot with an invalid single mode should fail")
+	}
+	s.Inspect(func(st *mpdtest.State) {
+		if len(st.Queue) != 1 || st.Current != 0 {
+			t.Errorf("queue %v, current %d after failing to restore", st.Queue, st.Current)
+		}
+	})
+
+	if err := p.extension.RestoreSnapshot("missing"); err == nil {
+		t.Error("restoring a missing snapshot should fail")
+	}
+	mustOK(t, p.extension.RemoveSnapshot("before"))
+}
@@ -2,15 +2,20 @@ package mpris
 
 import (
 	"fmt"
-	"time"
-
-	"github.com/godbus/dbus/v5"
+	"strconv"
+	"strings"
 
 	"github.com/natsukagami/mpd-mpris/mpd"
+	"github.com/pkg/errors"
 )
 
-// TrackIDFormat is the formatter string for a track ID.
-const TrackIDFormat = "/org/mpd/Tracks/%d"
+// NoTrack is the track ID meaning that there is no current track.
+// https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Simple-Type:Track_Id
+const NoTrack TrackID = "/org/mpris/MediaPlayer2/TrackList/NoTrack"
+
+// trackIDRoot is the root of all track IDs. The specification reserves `/org/mpris` for itself,
+// so track IDs cannot live under the player's object path there; they live under `/org/mpd/MediaPlayer2` instead.
+const trackIDRoot = "/org/mpd/MediaPlayer2"
 
 // This file implements a struct that satisfies the `org.mpris.MediaPlayer2.TrackList` interface.
 
@@ -28,53 +33,84 @@ type URI string
 // https://specifications.freedesktop.org/mpris-spec/latest/Track_List_Interface.html#Mapping:Metadata_Map
 type MetadataMap map[string]interface{}
 
-func (m *MetadataMap) nonEmptyString(field, value string) {
-	if value != "" {
-		(*m)[field] = value
+// trackIDPath returns the path under which the instance's track IDs live,
+// e.g. `/org/mpd/MediaPlayer2/instance1234/Track` for `org.mpris.MediaPlayer2.mpd.instance1234`.
+func (ins *Instance) trackIDPath() string {
+	path := trackIDRoot
+	for _, part := range strings.Split(strings.TrimPrefix(ins.Name(), "org.mpris.MediaPlayer2.mpd"), ".") {
+		if part != "" {
+			path += "/" + objectPathElement(part)
+		}
 	}
+	return path + "/Track"
 }
 
-func (m *MetadataMap) nonEmptySlice(field string, values []string) {
-	toAdd := []string{}
-	for _, v := range values {
-		if v != "" {
-			toAdd = append(toAdd, v)
+// objectPathElement replaces the characters that are not allowed in object paths.
+func objectPathElement(s string) string {
+	return strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '_' {
+			return r
 		}
+		return '_'
+	}, s)
+}
+
+// trackID returns the track ID of the song with the given ID, which became current at the given queue version.
+// The queue version tells apart the songs that MPD gives the same ID to, e.g. after a restart.
+func (ins *Instance) trackID(queueVersion, songID int) TrackID {
+	return TrackID(fmt.Sprintf("%s/%d/%d", ins.trackIDPath(), queueVersion, songID))
+}
+
+// parseTrackID returns the queue version and the song ID from a track ID of the instance.
+func (ins *Instance) parseTrackID(o TrackID) (queueVersion, songID int, err error) {
+	rest := strings.TrimPrefix(string(o), ins.trackIDPath()+"/")
+	parts := strings.Split(rest, "/")
+	if rest == string(o) || len(parts) != 2 {
+		return 0, 0, errors.Errorf("%s is not a track ID of this player", o)
 	}
-	if len(toAdd) > 0 {
-		(*m)[field] = toAdd
+	if queueVersion, err = parseTrackIDPart(parts[0]); err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid track ID %s", o)
 	}
+	if songID, err = parseTrackIDPart(parts[1]); err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid track ID %s", o)
+	}
+	return queueVersion, songID, nil
+}
+
+// parseTrackIDPart parses a non-negative number, as formatted by trackID.
+func parseTrackIDPart(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || strconv.Itoa(n) != s || n < 0 {
+		return 0, errors.Errorf("%q is not a number", s)
+	}
+	return n, nil
 }
 
-// MapFromSong returns a MetadataMap from the Song struct in mpd.
-func MapFromSong(s mpd.Song) MetadataMap {
+// MapFromSong returns a MetadataMap from the Song struct in mpd, with the given track ID.
+func MapFromSong(id TrackID, s mpd.Song) MetadataMap {
+	b := newMetadataBuilder()
 	if s.ID == -1 {
 		// No song
-		return MetadataMap{
-			"mpris:trackid": dbus.ObjectPath("/org/mpris/MediaPlayer2/TrackList/NoTrack"),
-		}
+		b.trackID(NoTrack)
+		return b.build()
 	}
 
-	m := &MetadataMap{
-		"mpris:trackid": dbus.ObjectPath(fmt.Sprintf(TrackIDFormat, s.ID)),
-		"mpris:length":  s.Duration / time.Microsecond,
-	}
+	b.trackID(id)
+	b.length(s.Duration)
 
-	m.nonEmptyString("xesam:album", s.Album)
-	m.nonEmptyString("xesam:title", s.Title)
-	m.nonEmptyString("xesam:url", s.Filepath)
-	m.nonEmptyString("xesam:contentCreated", s.Date)
-	m.nonEmptySlice("xesam:albumArtist", []string{s.AlbumArtist})
-	m.nonEmptySlice("xesam:artist", []string{s.Artist})
-	m.nonEmptySlice("xesam:genre", []string{s.Genre})
+	b.string("xesam:album", s.Album)
+	b.string("xesam:title", s.Title)
+	b.string("xesam:url", s.Filepath)
+	b.date("xesam:contentCreated", s.Date)
+	b.strings("xesam:albumArtist", s.AlbumArtist)
+	b.strings("xesam:artist", s.Artist)
+	b.strings("xesam:genre", s.Genre)
 
 	if artURI, ok := s.AlbumArtURI(); ok {
-		(*m)["mpris:artUrl"] = artURI
+		b.string("mpris:artUrl", artURI)
 	}
 
-	if s.Track != 0 {
-		(*m)["xesam:trackNumber"] = s.Track
-	}
+	b.number("xesam:trackNumber", s.Track)
 
-	return *m
+	return b.build()
 }
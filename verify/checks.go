@@ -0,0 +1,540 @@
+package verify
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/pkg/errors"
+)
+
+// A stale track ID, used to check that SetPosition ignores it.
+// Players must not use IDs under /org/mpris, so it cannot be the current track.
+const staleTrack = "/org/mpris/MediaPlayer2/verify/StaleTrack"
+
+type checker struct {
+	conn   *dbus.Conn
+	obj    dbus.BusObject
+	owner  string // The unique name of the player
+	opts   Options
+	report *Report
+
+	signals chan *dbus.Signal
+	// The `PropertiesChanged` signals received so far.
+	changed []*dbus.Signal
+
+	// The introspected interfaces, by name.
+	ifaces map[string]introspect.Interface
+}
+
+func (c *checker) add(check string, status Status, format string, args ...interface{}) {
+	c.report.Results = append(c.report.Results, Result{Check: check, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) pass(check string)         { c.add(check, Pass, "") }
+func (c *checker) skip(check, reason string) { c.add(check, Skip, "%s", reason) }
+func (c *checker) problems(check string, status Status, problems []string) {
+	if len(problems) == 0 {
+		c.pass(check)
+		return
+	}
+	c.add(check, status, "%s", strings.Join(problems, "; "))
+}
+
+// ============================================================================
+// Introspection
+
+// introspect checks the introspection data against the specification.
+func (c *checker) introspect() error {
+	var data string
+	if err := c.obj.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&data); err != nil {
+		return errors.Wrap(err, "introspecting the player")
+	}
+	var node introspect.Node
+	if err := xml.Unmarshal([]byte(data), &node); err != nil {
+		return errors.Wrap(err, "parsing the introspection data")
+	}
+	c.ifaces = make(map[string]introspect.Interface)
+	for _, iface := range node.Interfaces {
+		c.ifaces[iface.Name] = iface
+	}
+
+	for _, spec := range interfaces {
+		iface, ok := c.ifaces[spec.name]
+		if !ok {
+			if spec.optional {
+				c.skip("interface "+spec.name, "not implemented")
+			} else {
+				c.add("interface "+spec.name, Fail, "missing")
+			}
+			continue
+		}
+		c.pass("interface " + spec.name)
+		for _, m := range spec.methods {
+			c.checkMethod(iface, m)
+		}
+		for _, s := range spec.signals {
+			c.checkSignal(iface, s)
+		}
+		for _, p := range spec.props {
+			c.checkPropertyDecl(iface, p)
+		}
+	}
+	return nil
+}
+
+func argTypes(args []introspect.Arg, direction string) []string {
+	types := []string{}
+	for _, arg := range args {
+		if arg.Direction == direction {
+			types = append(types, arg.Type)
+		}
+	}
+	return types
+}
+
+func sameTypes(a, b []string) bool {
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
+
+func (c *checker) checkMethod(iface introspect.Interface, spec methodSpec) {
+	check := fmt.Sprintf("method %s.%s", iface.Name, spec.name)
+	for _, m := range iface.Methods {
+		if m.Name != spec.name {
+			continue
+		}
+		want := append([]string{}, spec.args...)
+		if got := argTypes(m.Args, "in"); !sameTypes(got, want) {
+			c.add(check, Fail, "takes (%s), want (%s)", strings.Join(got, ", "), strings.Join(want, ", "))
+			return
+		}
+		if got := argTypes(m.Args, "out"); len(got) > 0 {
+			c.add(check, Fail, "returns (%s), want nothing", strings.Join(got, ", "))
+			return
+		}
+		c.pass(check)
+		return
+	}
+	c.add(check, Fail, "missing")
+}
+
+func (c *checker) checkSignal(iface introspect.Interface, spec signalSpec) {
+	check := fmt.Sprintf("signal %s.%s", iface.Name, spec.name)
+	for _, s := range iface.Signals {
+		if s.Name != spec.name {
+			continue
+		}
+		// Signal arguments have no direction, or "out".
+		var got []string
+		for _, arg := range s.Args {
+			got = append(got, arg.Type)
+		}
+		if !sameTypes(got, spec.args) {
+			c.add(check, Fail, "has arguments (%s), want (%s)", strings.Join(got, ", "), strings.Join(spec.args, ", "))
+			return
+		}
+		c.pass(check)
+		return
+	}
+	c.add(check, Fail, "missing")
+}
+
+func findProperty(iface introspect.Interface, name string) (introspect.Property, bool) {
+	for _, p := range iface.Properties {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return introspect.Property{}, false
+}
+
+func (c *checker) checkPropertyDecl(iface introspect.Interface, spec propSpec) {
+	check := fmt.Sprintf("property %s.%s", iface.Name, spec.name)
+	p, ok := findProperty(iface, spec.name)
+	if !ok {
+		if spec.optional {
+			c.skip(check, "not implemented")
+		} else {
+			c.add(check, Fail, "missing")
+		}
+		return
+	}
+	status := Warn
+	var problems []string
+	if p.Type != spec.signature {
+		status = Fail
+		problems = append(problems, fmt.Sprintf("has type %s, want %s", p.Type, spec.signature))
+	}
+	switch {
+	case access(p.Access) == spec.access:
+	case spec.access == readWrite && (spec.optional || spec.mayBeReadOnly):
+		// Optional capabilities may be read-only, but clients are told to check CanXXX instead.
+		// Others may be read-only for a while, and clients are expected to cope with failed sets.
+		problems = append(problems, fmt.Sprintf("is %s, the specification says %s", p.Access, spec.access))
+	default:
+		status = Fail
+		problems = append(problems, fmt.Sprintf("is %s, want %s", p.Access, spec.access))
+	}
+	if spec.noEmit {
+		for _, a := range p.Annotations {
+			if a.Name == "org.freedesktop.DBus.Property.EmitsChangedSignal" && a.Value != "false" {
+				problems = append(problems, fmt.Sprintf("is annotated EmitsChangedSignal=%s, want false", a.Value))
+			}
+		}
+	}
+	c.problems(check, status, problems)
+}
+
+// ============================================================================
+// Values
+
+// checkValues checks the values of all properties.
+func (c *checker) checkValues() {
+	for _, spec := range interfaces {
+		if _, ok := c.ifaces[spec.name]; !ok {
+			continue
+		}
+		var values map[string]dbus.Variant
+		if err := c.obj.Call("org.freedesktop.DBus.Properties.GetAll", 0, spec.name).Store(&values); err != nil {
+			c.add("values of "+spec.name, Fail, "GetAll failed: %v", err)
+			continue
+		}
+		for _, p := range spec.props {
+			v, ok := values[p.name]
+			if !ok {
+				if !p.optional {
+					c.add(fmt.Sprintf("value of %s.%s", spec.name, p.name), Fail, "missing from GetAll")
+				}
+				continue
+			}
+			c.checkValue(spec.name, p, v, values)
+		}
+	}
+}
+
+func (c *checker) checkValue(iface string, spec propSpec, v dbus.Variant, all map[string]dbus.Variant) {
+	check := fmt.Sprintf("value of %s.%s", iface, spec.name)
+	if sig := v.Signature().String(); sig != spec.signature {
+		c.add(check, Fail, "%v has type %s, want %s", v.Value(), sig, spec.signature)
+		return
+	}
+	var problems []string
+	switch spec.name {
+	case "PlaybackStatus":
+		problems = oneOf(v.Value().(string), "Playing", "Paused", "Stopped")
+	case "LoopStatus":
+		problems = oneOf(v.Value().(string), "None", "Track", "Playlist")
+	case "Volume":
+		if x := v.Value().(float64); x < 0 || math.IsNaN(x) {
+			problems = append(problems, fmt.Sprintf("%v is negative", x))
+		}
+	case "Position":
+		if x := v.Value().(int64); x < 0 {
+			problems = append(problems, fmt.Sprintf("%v is negative", x))
+		}
+	case "MinimumRate":
+		if x := v.Value().(float64); x > 1 {
+			problems = append(problems, fmt.Sprintf("%v is above 1.0", x))
+		}
+	case "MaximumRate":
+		if x := v.Value().(float64); x < 1 {
+			problems = append(problems, fmt.Sprintf("%v is below 1.0", x))
+		}
+	case "Rate":
+		x := v.Value().(float64)
+		if min, ok := all["MinimumRate"].Value().(float64); ok && x < min {
+			problems = append(problems, fmt.Sprintf("%v is below MinimumRate", x))
+		}
+		if max, ok := all["MaximumRate"].Value().(float64); ok && x > max {
+			problems = append(problems, fmt.Sprintf("%v is above MaximumRate", x))
+		}
+	case "Metadata":
+		c.checkMetadata(v.Value().(map[string]dbus.Variant))
+		return
+	}
+	c.problems(check, Fail, problems)
+}
+
+func oneOf(v string, allowed ...string) []string {
+	for _, a := range allowed {
+		if v == a {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("%q is not one of %s", v, strings.Join(allowed, ", "))}
+}
+
+// checkMetadata checks the entries of the current track's metadata.
+func (c *checker) checkMetadata(m map[string]dbus.Variant) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if _, ok := m["mpris:trackid"]; !ok {
+		c.add("metadata mpris:trackid", Fail, "missing")
+	}
+	for _, k := range keys {
+		check := "metadata " + k
+		v := m[k]
+		want, known := metadataTypes[k]
+		if !known {
+			if !strings.Contains(k, ":") {
+				c.add(check, Warn, "not namespaced")
+			}
+			continue
+		}
+		if sig := v.Signature().String(); sig != want {
+			c.add(check, Fail, "%v has type %s, want %s", v.Value(), sig, want)
+			continue
+		}
+		var problems []string
+		switch k {
+		case "mpris:trackid":
+			id := v.Value().(dbus.ObjectPath)
+			if !id.IsValid() {
+				problems = append(problems, fmt.Sprintf("%q is not a valid object path", id))
+			} else if id != noTrack && strings.HasPrefix(string(id), "/org/mpris/") {
+				problems = append(problems, fmt.Sprintf("%s is in the reserved /org/mpris namespace", id))
+			}
+		case "mpris:length":
+			if x := v.Value().(int64); x < 0 {
+				problems = append(problems, fmt.Sprintf("%v is negative", x))
+			}
+		}
+		for _, d := range metadataDates {
+			if k == d && !isISO8601(v.Value().(string)) {
+				problems = append(problems, fmt.Sprintf("%q is not an ISO 8601 date", v.Value()))
+			}
+		}
+		c.problems(check, Fail, problems)
+	}
+}
+
+// The ISO 8601 formats accepted in metadata: dates with reduced precision, and date-times.
+var iso8601Layouts = []string{
+	"2006",
+	"2006-01",
+	"2006-01-02",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04Z07:00",
+	time.RFC3339,
+	time.RFC3339Nano,
+}
+
+func isISO8601(s string) bool {
+	for _, layout := range iso8601Layouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================
+// Behaviour
+
+// nextSignal waits for the next signal of the player, recording `PropertiesChanged` signals on the way.
+func (c *checker) nextSignal(timeout time.Duration) (*dbus.Signal, bool) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case sig := <-c.signals:
+			if sig.Sender != c.owner || sig.Path != objectPath {
+				continue
+			}
+			if sig.Name == "org.freedesktop.DBus.Properties.PropertiesChanged" {
+				c.changed = append(c.changed, sig)
+			}
+			return sig, true
+		case <-deadline:
+			return nil, false
+		}
+	}
+}
+
+// waitSeeked waits for a `Seeked` signal, and returns its position.
+func (c *checker) waitSeeked(timeout time.Duration) (int64, bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		sig, ok := c.nextSignal(time.Until(deadline))
+		if !ok {
+			return 0, false
+		}
+		if sig.Name == "org.mpris.MediaPlayer2.Player.Seeked" && len(sig.Body) == 1 {
+			x, _ := sig.Body[0].(int64)
+			return x, true
+		}
+	}
+}
+
+// drain records the signals already received.
+func (c *checker) drain() {
+	for {
+		if _, ok := c.nextSignal(0); !ok {
+			return
+		}
+	}
+}
+
+func (c *checker) get(name string) (dbus.Variant, error) {
+	return c.obj.GetProperty("org.mpris.MediaPlayer2.Player." + name)
+}
+
+// position returns the current Position.
+func (c *checker) position() (int64, bool) {
+	v, err := c.get("Position")
+	if err != nil {
+		return 0, false
+	}
+	x, ok := v.Value().(int64)
+	return x, ok
+}
+
+// exercise calls SetPosition, and checks the signals that follow.
+func (c *checker) exercise() {
+	const (
+		seeked = "SetPosition emits Seeked"
+		stale  = "SetPosition ignores stale track IDs"
+	)
+	reason := c.canSeek()
+	if reason != "" {
+		c.skip(seeked, reason)
+		c.skip(stale, reason)
+		return
+	}
+	md, _ := c.get("Metadata")
+	metadata := md.Value().(map[string]dbus.Variant)
+	trackID := metadata["mpris:trackid"].Value().(dbus.ObjectPath)
+	length := metadata["mpris:length"].Value().(int64)
+	original, _ := c.position()
+
+	// Seek somewhere else, on a whole second.
+	target := (length / 3) / 1e6 * 1e6
+	if abs(target-original) < 2e6 {
+		target = (2 * length / 3) / 1e6 * 1e6
+	}
+	tolerance := int64(1e6)
+
+	c.drain()
+	if err := c.obj.Call("org.mpris.MediaPlayer2.Player.SetPosition", 0, trackID, target).Err; err != nil {
+		c.add(seeked, Fail, "SetPosition failed: %v", err)
+		c.skip(stale, "SetPosition failed")
+		return
+	}
+	if x, ok := c.waitSeeked(c.opts.Timeout); !ok {
+		c.add(seeked, Fail, "no Seeked signal within %v", c.opts.Timeout)
+	} else if abs(x-target) > tolerance {
+		c.add(seeked, Fail, "Seeked(%d), want %d", x, target)
+	} else if x, ok := c.position(); !ok {
+		c.add(seeked, Fail, "cannot get Position")
+	} else if abs(x-target) > 2*tolerance {
+		c.add(seeked, Fail, "Position is %d after SetPosition(%d)", x, target)
+	} else {
+		c.pass(seeked)
+	}
+
+	c.drain()
+	err := c.obj.Call("org.mpris.MediaPlayer2.Player.SetPosition", 0, dbus.ObjectPath(staleTrack), int64(0)).Err
+	if x, ok := c.waitSeeked(c.opts.Timeout / 2); ok {
+		c.add(stale, Fail, "seeked to %d", x)
+	} else if x, ok := c.position(); ok && abs(x-target) > 2*tolerance {
+		c.add(stale, Fail, "Position changed to %d", x)
+	} else if err != nil {
+		c.add(stale, Warn, "the call is ignored, but returned an error: %v", err)
+	} else {
+		c.pass(stale)
+	}
+
+	// Restore the position.
+	if err := c.obj.Call("org.mpris.MediaPlayer2.Player.SetPosition", 0, trackID, original).Err; err == nil {
+		c.waitSeeked(c.opts.Timeout)
+	}
+}
+
+// canSeek returns why the current track cannot be seeked, if it cannot.
+func (c *checker) canSeek() string {
+	for _, name := range []string{"CanControl", "CanSeek"} {
+		v, err := c.get(name)
+		if err != nil {
+			return fmt.Sprintf("cannot get %s: %v", name, err)
+		}
+		if b, _ := v.Value().(bool); !b {
+			return name + " is false"
+		}
+	}
+	md, err := c.get("Metadata")
+	if err != nil {
+		return fmt.Sprintf("cannot get Metadata: %v", err)
+	}
+	metadata, _ := md.Value().(map[string]dbus.Variant)
+	if id, _ := metadata["mpris:trackid"].Value().(dbus.ObjectPath); id == "" || id == noTrack {
+		return "there is no current track"
+	}
+	if length, ok := metadata["mpris:length"].Value().(int64); !ok || length < 6e6 {
+		return "the current track has no length, or is too short"
+	}
+	return ""
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// checkEmitted checks the `PropertiesChanged` signals received during the checks.
+func (c *checker) checkEmitted() {
+	const check = "PropertiesChanged signals"
+	// Wait a bit for the last ones.
+	for {
+		if _, ok := c.nextSignal(c.opts.Timeout / 4); !ok {
+			break
+		}
+	}
+	if len(c.changed) == 0 {
+		c.skip(check, "none received")
+		return
+	}
+	specs := make(map[string]propSpec)
+	for _, iface := range interfaces {
+		for _, p := range iface.props {
+			specs[iface.name+"."+p.name] = p
+		}
+	}
+	var problems []string
+	for _, sig := range c.changed {
+		if len(sig.Body) != 3 {
+			problems = append(problems, fmt.Sprintf("malformed signal %v", sig.Body))
+			continue
+		}
+		iface, _ := sig.Body[0].(string)
+		changed, _ := sig.Body[1].(map[string]dbus.Variant)
+		invalidated, _ := sig.Body[2].([]string)
+		for name, v := range changed {
+			spec, ok := specs[iface+"."+name]
+			if !ok {
+				continue
+			}
+			if spec.noEmit {
+				problems = append(problems, fmt.Sprintf("%s is emitted", name))
+			} else if sig := v.Signature().String(); sig != spec.signature {
+				problems = append(problems, fmt.Sprintf("%s is emitted with type %s, want %s", name, sig, spec.signature))
+			}
+		}
+		for _, name := range invalidated {
+			if specs[iface+"."+name].noEmit {
+				problems = append(problems, fmt.Sprintf("%s is invalidated", name))
+			}
+		}
+	}
+	c.problems(check, Fail, problems)
+}
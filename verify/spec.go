@@ -0,0 +1,134 @@
+package verify
+
+// This file describes the parts of the MPRIS specification that are checked.
+// https://specifications.freedesktop.org/mpris-spec/latest/
+
+// The object path of MPRIS players.
+const objectPath = "/org/mpris/MediaPlayer2"
+
+// The track ID of "no track".
+const noTrack = "/org/mpris/MediaPlayer2/TrackList/NoTrack"
+
+type access string
+
+const (
+	read      access = "read"
+	readWrite access = "readwrite"
+)
+
+type propSpec struct {
+	name      string
+	signature string
+	access    access
+	optional  bool
+	// Whether a writable property may be read-only while the player cannot change it, e.g. Volume without a mixer.
+	mayBeReadOnly bool
+	// Whether the property is left out of `PropertiesChanged`.
+	// https://specifications.freedesktop.org/mpris-spec/latest/Player_Interface.html#Property:Position
+	noEmit bool
+}
+
+type methodSpec struct {
+	name string
+	// The signatures of the input arguments.
+	args []string
+}
+
+type signalSpec struct {
+	name string
+	args []string
+}
+
+type interfaceSpec struct {
+	name     string
+	optional bool
+	methods  []methodSpec
+	signals  []signalSpec
+	props    []propSpec
+}
+
+var rootInterface = interfaceSpec{
+	name: "org.mpris.MediaPlayer2",
+	methods: []methodSpec{
+		{name: "Raise"},
+		{name: "Quit"},
+	},
+	props: []propSpec{
+		{name: "CanQuit", signature: "b", access: read},
+		{name: "Fullscreen", signature: "b", access: readWrite, optional: true},
+		{name: "CanSetFullscreen", signature: "b", access: read, optional: true},
+		{name: "CanRaise", signature: "b", access: read},
+		{name: "HasTrackList", signature: "b", access: read},
+		{name: "Identity", signature: "s", access: read},
+		{name: "DesktopEntry", signature: "s", access: read, optional: true},
+		{name: "SupportedUriSchemes", signature: "as", access: read},
+		{name: "SupportedMimeTypes", signature: "as", access: read},
+	},
+}
+
+var playerInterface = interfaceSpec{
+	name: "org.mpris.MediaPlayer2.Player",
+	methods: []methodSpec{
+		{name: "Next"},
+		{name: "Previous"},
+		{name: "Pause"},
+		{name: "PlayPause"},
+		{name: "Stop"},
+		{name: "Play"},
+		{name: "Seek", args: []string{"x"}},
+		{name: "SetPosition", args: []string{"o", "x"}},
+		{name: "OpenUri", args: []string{"s"}},
+	},
+	signals: []signalSpec{
+		{name: "Seeked", args: []string{"x"}},
+	},
+	props: []propSpec{
+		{name: "PlaybackStatus", signature: "s", access: read},
+		{name: "LoopStatus", signature: "s", access: readWrite, optional: true},
+		{name: "Rate", signature: "d", access: readWrite},
+		{name: "Shuffle", signature: "b", access: readWrite, optional: true},
+		{name: "Metadata", signature: "a{sv}", access: read},
+		{name: "Volume", signature: "d", access: readWrite, mayBeReadOnly: true},
+		{name: "Position", signature: "x", access: read, noEmit: true},
+		{name: "MinimumRate", signature: "d", access: read},
+		{name: "MaximumRate", signature: "d", access: read},
+		{name: "CanGoNext", signature: "b", access: read},
+		{name: "CanGoPrevious", signature: "b", access: read},
+		{name: "CanPlay", signature: "b", access: read},
+		{name: "CanPause", signature: "b", access: read},
+		{name: "CanSeek", signature: "b", access: read},
+		{name: "CanControl", signature: "b", access: read},
+	},
+}
+
+var interfaces = []interfaceSpec{rootInterface, playerInterface}
+
+// The types of the metadata entries defined by the specification.
+// https://www.freedesktop.org/wiki/Specifications/mpris-spec/metadata/
+var metadataTypes = map[string]string{
+	"mpris:trackid":        "o",
+	"mpris:length":         "x",
+	"mpris:artUrl":         "s",
+	"xesam:album":          "s",
+	"xesam:albumArtist":    "as",
+	"xesam:artist":         "as",
+	"xesam:asText":         "s",
+	"xesam:audioBPM":       "i",
+	"xesam:autoRating":     "d",
+	"xesam:comment":        "as",
+	"xesam:composer":       "as",
+	"xesam:contentCreated": "s",
+	"xesam:discNumber":     "i",
+	"xesam:firstUsed":      "s",
+	"xesam:genre":          "as",
+	"xesam:lastUsed":       "s",
+	"xesam:lyricist":       "as",
+	"xesam:title":          "s",
+	"xesam:trackNumber":    "i",
+	"xesam:url":            "s",
+	"xesam:useCount":       "i",
+	"xesam:userRating":     "d",
+}
+
+// The metadata entries holding dates, in ISO 8601.
+var metadataDates = []string{"xesam:contentCreated", "xesam:firstUsed", "xesam:lastUsed"}
@@ -0,0 +1,169 @@
+// Package verify checks that an MPRIS player on the bus follows the MPRIS specification.
+//
+// It introspects the player and checks its interfaces, methods, signals and properties
+// (with their types and access), then checks the values of its properties, including the metadata entries.
+// Unless asked not to, it also exercises the player: it seeks the current track and checks the signals that follow.
+//
+// https://specifications.freedesktop.org/mpris-spec/latest/
+package verify
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/pkg/errors"
+)
+
+// Status is the outcome of a check.
+type Status int
+
+// Defined Statuses.
+const (
+	Pass Status = iota
+	// Warn is for deviations that clients are expected to cope with.
+	Warn
+	Fail
+	// Skip is for checks that could not be run, e.g. seeking when nothing is playing.
+	Skip
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "PASS"
+	case Warn:
+		return "WARN"
+	case Fail:
+		return "FAIL"
+	case Skip:
+		return "SKIP"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// Result is the result of a check.
+type Result struct {
+	Check  string
+	Status Status
+	Detail string
+}
+
+// Report holds the results of all checks run against a player.
+type Report struct {
+	Player  string
+	Results []Result
+}
+
+// Failed returns whether any check failed.
+func (r *Report) Failed() bool {
+	for _, res := range r.Results {
+		if res.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// Failures returns the names of the failed checks.
+func (r *Report) Failures() []string {
+	var checks []string
+	for _, res := range r.Results {
+		if res.Status == Fail {
+			checks = append(checks, res.Check)
+		}
+	}
+	return checks
+}
+
+// Print writes the report in a human readable form.
+func (r *Report) Print(w io.Writer) error {
+	counts := make(map[Status]int)
+	fmt.Fprintf(w, "Checking %s\n", r.Player)
+	for _, res := range r.Results {
+		counts[res.Status]++
+		if res.Detail == "" {
+			fmt.Fprintf(w, "%s %s\n", res.Status, res.Check)
+		} else {
+			fmt.Fprintf(w, "%s %s: %s\n", res.Status, res.Check, res.Detail)
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d passed, %d warnings, %d failed, %d skipped\n", counts[Pass], counts[Warn], counts[Fail], counts[Skip])
+	return err
+}
+
+// Options change how the checks are run.
+type Options struct {
+	// ReadOnly disables the checks that change the player's state.
+	ReadOnly bool
+	// Timeout is how long to wait for signals. Defaults to 2 seconds.
+	Timeout time.Duration
+}
+
+// FindPlayer returns the name of an MPRIS player on the bus.
+// If name is given without dots (e.g. "mpd"), it is taken as the part after "org.mpris.MediaPlayer2.".
+// If name is empty, the first player (in alphabetical order) is returned.
+func FindPlayer(conn *dbus.Conn, name string) (string, error) {
+	const prefix = "org.mpris.MediaPlayer2."
+	if name != "" && !strings.Contains(name, ".") {
+		name = prefix + name
+	}
+	var names []string
+	if err := conn.BusObject().Call("org.freedesktop.DBus.ListNames", 0).Store(&names); err != nil {
+		return "", errors.WithStack(err)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if (name == "" && strings.HasPrefix(n, prefix)) || n == name {
+			return n, nil
+		}
+	}
+	if name == "" {
+		return "", errors.New("no MPRIS player on the bus")
+	}
+	return "", errors.Errorf("%s is not on the bus", name)
+}
+
+// Run checks the player with the given bus name.
+// Errors are only returned if the checks cannot run at all; problems with the player are reported.
+func Run(conn *dbus.Conn, name string, opts Options) (*Report, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	var owner string
+	if err := conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, name).Store(&owner); err != nil {
+		return nil, errors.Wrapf(err, "looking up %s", name)
+	}
+
+	c := &checker{
+		conn:    conn,
+		obj:     conn.Object(name, objectPath),
+		owner:   owner,
+		opts:    opts,
+		report:  &Report{Player: name},
+		signals: make(chan *dbus.Signal, 64),
+	}
+	match := []dbus.MatchOption{dbus.WithMatchSender(name), dbus.WithMatchObjectPath(objectPath)}
+	if err := conn.AddMatchSignal(match...); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer conn.RemoveMatchSignal(match...)
+	conn.Signal(c.signals)
+	defer conn.RemoveSignal(c.signals)
+
+	if err := c.introspect(); err != nil {
+		return nil, err
+	}
+	c.checkValues()
+	if opts.ReadOnly {
+		c.skip("SetPosition emits Seeked", "read-only mode")
+		c.skip("SetPosition ignores stale track IDs", "read-only mode")
+	} else {
+		c.exercise()
+	}
+	c.checkEmitted()
+	return c.report, nil
+}
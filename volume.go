@@ -0,0 +1,126 @@
+package mpris
+
+import (
+	"math"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/pkg/errors"
+)
+
+// The range of DBVolume, from the lowest audible volume to the full volume.
+const volumeRangeDB = 60.0
+
+// VolumeCurve maps MPRIS volumes (from 0 to 1) to MPD volumes (from 0 to 100), and back.
+// Mapping an MPD volume to MPRIS and back gives the same MPD volume.
+type VolumeCurve struct {
+	name string
+	// toMPD and fromMPD map between MPRIS volumes and MPD volumes, both from 0 to 1.
+	toMPD, fromMPD func(float64) float64
+}
+
+// Defined VolumeCurves.
+var (
+	// LinearVolume maps MPRIS volumes to MPD volumes as they are.
+	LinearVolume = VolumeCurve{
+		name:    "linear",
+		toMPD:   func(v float64) float64 { return v },
+		fromMPD: func(m float64) float64 { return m },
+	}
+	// CubicVolume maps MPRIS volumes to their cube, which is close to how loudness is perceived.
+	CubicVolume = VolumeCurve{
+		name:    "cubic",
+		toMPD:   func(v float64) float64 { return v * v * v },
+		fromMPD: math.Cbrt,
+	}
+	// DBVolume maps MPRIS volumes linearly to decibels, over 60dB. Volume 0 is muted.
+	DBVolume = VolumeCurve{
+		name: "db",
+		toMPD: func(v float64) float64 {
+			if v == 0 {
+				return 0
+			}
+			return math.Pow(10, (v-1)*volumeRangeDB/20)
+		},
+		fromMPD: func(m float64) float64 {
+			if m == 0 {
+				return 0
+			}
+			return 1 + 20*math.Log10(m)/volumeRangeDB
+		},
+	}
+)
+
+var volumeCurves = []VolumeCurve{LinearVolume, CubicVolume, DBVolume}
+
+// ParseVolumeCurve returns the VolumeCurve with the given name: "linear", "cubic" or "db".
+func ParseVolumeCurve(name string) (VolumeCurve, error) {
+	for _, c := range volumeCurves {
+		if strings.EqualFold(c.name, name) {
+			return c, nil
+		}
+	}
+	return VolumeCurve{}, errors.Errorf("unknown volume curve %q", name)
+}
+
+// String returns the name of the curve.
+func (c VolumeCurve) String() string {
+	return c.name
+}
+
+// ToMPD returns the MPD volume of an MPRIS volume. MPRIS volumes out of range are clamped.
+func (c VolumeCurve) ToMPD(v float64) int {
+	return int(math.Round(c.toMPD(clampVolume(v)) * 100))
+}
+
+// FromMPD returns the MPRIS volume of an MPD volume.
+func (c VolumeCurve) FromMPD(m int) float64 {
+	return clampVolume(c.fromMPD(float64(m) / 100))
+}
+
+func clampVolume(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// stepVolume changes the volume by delta, from MPD's current volume, and returns the new volume.
+// The volume always changes by at least one MPD step, unless it is at either end.
+func (p *Player) stepVolume(delta float64) (float64, *dbus.Error) {
+	s := &p.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &batch{}
+	defer p.Instance.props.apply(b)
+	// Start from MPD's volume, rather than ours: the "mixer" event for a previous change may not have arrived yet.
+	status, _, err := s.fetch(p, nil, false)
+	if err != nil {
+		return 0, err
+	}
+	s.updateVolume(p, b, status)
+	if !s.HasVolume {
+		return 0, errNoVolume
+	}
+
+	m := p.volumeCurve.ToMPD(s.Volume + delta)
+	if m == s.mpdVolume && delta > 0 && m < 100 {
+		m++
+	} else if m == s.mpdVolume && delta < 0 && m > 0 {
+		m--
+	}
+	if limit := p.maxMPDVolume(); m > limit {
+		m = limit
+	}
+	if m == s.mpdVolume {
+		return s.Volume, nil
+	}
+	if !s.fadingOut() {
+		s.stopRamp()
+		if err := p.mpd.SetVolume(m); err != nil {
+			return 0, p.transformErr(err)
+		}
+	}
+	// A fade-out goes on, and restores the new volume at the end.
+	s.mpdVolume = m
+	s.Volume = p.volumeCurve.FromMPD(m)
+	b.set("org.mpris.MediaPlayer2.Player", "Volume", s.Volume)
+	return s.Volume, nil
+}
@@ -0,0 +1,138 @@
+package mpris
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/natsukagami/mpd-mpris/mpd/mpdtest"
+)
+
+func TestVolumeCurves(t *testing.T) {
+	for _, c := range volumeCurves {
+		if m := c.ToMPD(0); m != 0 {
+			t.Errorf("%s: ToMPD(0) = %d, want 0", c, m)
+		}
+		if m := c.ToMPD(1); m != 100 {
+			t.Errorf("%s: ToMPD(1) = %d, want 100", c, m)
+		}
+		if m := c.ToMPD(1.5); m != 100 {
+			t.Errorf("%s: ToMPD(1.5) = %d, want 100", c, m)
+		}
+		last := -1.0
+		for m := 0; m <= 100; m++ {
+			v := c.FromMPD(m)
+			if v <= last {
+				t.Errorf("%s: FromMPD(%d) = %v is not above FromMPD(%d) = %v", c, m, v, m-1, last)
+			}
+			if back := c.ToMPD(v); back != m {
+				t.Errorf("%s: ToMPD(FromMPD(%d)) = %d", c, m, back)
+			}
+			last = v
+		}
+	}
+
+	for _, test := range []struct {
+		curve VolumeCurve
+		v     float64
+		want  int
+	}{
+		{LinearVolume, 0.5, 50},
+		{CubicVolume, 0.5, 13},
+		{DBVolume, 0.5, 3},
+		{DBVolume, 0.9, 50},
+	} {
+		if m := test.curve.ToMPD(test.v); m != test.want {
+			t.Errorf("%s: ToMPD(%v) = %d, want %d", test.curve, test.v, m, test.want)
+		}
+	}
+
+	if c, err := ParseVolumeCurve("dB"); err != nil || c.String() != "db" {
+		t.Errorf("ParseVolumeCurve(dB) = %v, %v", c, err)
+	}
+	if _, err := ParseVolumeCurve("log"); err == nil {
+		t.Error("ParseVolumeCurve(log) should fail")
+	}
+}
+
+func TestPlayerVolume(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Volume = 40
+	})
+	p.volumeCurve = CubicVolume
+	drain(p)
+	mixerEvent := func() {
+		for _, h := range p.handlers["mixer"] {
+			if err := h(); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+
+	// Every change of MPD's volume is seen.
+	s.Modify(func(st *mpdtest.State) { st.Volume = 41 }, "mixer")
+	mixerEvent()
+	if v, ok := drain(p).changed("Volume"); !ok || v != CubicVolume.FromMPD(41) {
+		t.Errorf("Volume changed to %v, want %v", v, CubicVolume.FromMPD(41))
+	}
+
+	// Our own changes are not emitted again.
+	mustOK(t, p.Instance.props.Set("org.mpris.MediaPlayer2.Player", "Volume", dbus.MakeVariant(0.5)))
+	s.Inspect(func(st *mpdtest.State) {
+		if st.Volume != 13 {
+			t.Errorf("volume = %d, want 13", st.Volume)
+		}
+	})
+	drain(p)
+	mixerEvent()
+	if v, ok := drain(p).changed("Volume"); ok {
+		t.Errorf("Volume changed to %v after our own change", v)
+	}
+	if v := getProp(t, p, "Volume"); v != 0.5 {
+		t.Errorf("Volume = %v, want 0.5", v)
+	}
+}
+
+func TestPlayerStepVolume(t *testing.T) {
+	p, s := newTestPlayer(t, func(st *mpdtest.State) {
+		threeSongs(st)
+		st.Volume = 50
+	})
+	drain(p)
+
+	v, err := p.extension.StepVolume(2)
+	mustOK(t, err)
+	if v != 0.6 {
+		t.Errorf("StepVolume(2) = %v, want 0.6", v)
+	}
+	// Steps start from MPD's volume, even if no event has been seen yet.
+	s.Modify(func(st *mpdtest.State) { st.Volume = 20 })
+	v, err = p.extension.StepVolume(-1)
+	mustOK(t, err)
+	if v != 0.15 {
+		t.Errorf("StepVolume(-1) = %v, want 0.15", v)
+	}
+	if got, _ := drain(p).changed("Volume"); got != 0.15 {
+		t.Errorf("Volume changed to %v, want 0.15", got)
+	}
+
+	// Small steps still change the volume.
+	p.volumeStep = 0.001
+	v, err = p.extension.StepVolume(1)
+	mustOK(t, err)
+	if v != 0.16 {
+		t.Errorf("StepVolume(1) = %v, want 0.16", v)
+	}
+
+	s.Modify(func(st *mpdtest.State) { st.Volume = 100 })
+	v, err = p.extension.StepVolume(1)
+	mustOK(t, err)
+	if v != 1 {
+		t.Errorf("StepVolume(1) at full volume = %v, want 1", v)
+	}
+
+	s.Modify(func(st *mpdtest.State) { st.Volume = -1 })
+	if _, err := p.extension.StepVolume(1); err == nil {
+		t.Error("StepVolume without a mixer should fail")
+	}
+}